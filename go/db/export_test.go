@@ -0,0 +1,59 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	MySQLConfig      = (*GormDBCtx).mysqlConfig
+	PostgreSQLConfig = (*GormDBCtx).postgreSQLConfig
+	RedactDSN        = redactDSN
+
+	ConnLifetimeExceeds = connLifetimeExceeds
+	AutoTunePoolSize    = autoTunePoolSize
+	WrapConnectError    = wrapConnectError
+)
+
+// build the dsn from the stored auth, as Connect does
+func AuthMySQLConfig(ctx *GormDBCtx) (*mysql.Config, error) {
+	return ctx.mysqlConfig(ctx.username, ctx.password, ctx.host, ctx.dbName, ctx.tlsOption)
+}
+
+func AuthPostgreSQLConfig(ctx *GormDBCtx) (*pgx.ConnConfig, error) {
+	return ctx.postgreSQLConfig(ctx.username, ctx.password, ctx.host, ctx.dbName, ctx.tlsOption)
+}
+
+func SetCloseSQLDB(closeFn func(*sql.DB) error) (restore func()) {
+	prev := closeSQLDB
+	closeSQLDB = closeFn
+	return func() { closeSQLDB = prev }
+}
+
+func SetFastDBCheck(check func(ctx *GormDBCtx, name string) (bool, error)) (restore func()) {
+	prev := fastDBCheck
+	fastDBCheck = check
+	return func() { fastDBCheck = prev }
+}
+
+func SetNumCPU(n int) (restore func()) {
+	prev := numCPU
+	numCPU = func() int { return n }
+	return func() { numCPU = prev }
+}
+
+// closed once the goroutine of EnableConnReaper exited, nil when not started
+func ConnReaperDone(ctx *GormDBCtx) <-chan struct{} {
+	return ctx.connStatsDone
+}
+
+func PoolSizes(ctx *GormDBCtx) (maxOpenConns, maxIdleConns int) {
+	return ctx.maxOpenConns, ctx.maxIdleConns
+}
+
+func PoolLifetimes(ctx *GormDBCtx) (connMaxLifetime, connMaxIdleTime time.Duration) {
+	return ctx.connMaxLifetime, ctx.connMaxIdleTime
+}
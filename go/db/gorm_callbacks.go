@@ -0,0 +1,11 @@
+package db
+
+// callbacks registered on every Connect, each one is a no-op until its
+// setter enables it
+func (ctx *GormDBCtx) installCallbacks() {
+	ctx.installQueryMetrics()
+	ctx.installMaxRows()
+	ctx.installAcquireTimeout()
+	ctx.installSlowQueryWatchdog()
+	ctx.installCaptureQueries()
+}
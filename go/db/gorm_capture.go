@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const captureQueriesStartKey = "kd:capture_queries_start"
+
+type CapturedQuery struct {
+	// with placeholders, the args aren't kept
+	SQL          string
+	Duration     time.Duration
+	RowsAffected int64
+	Err          error
+}
+
+type captureQueriesKey struct{}
+
+type queryCapture struct {
+	mu      sync.Mutex
+	queries []CapturedQuery
+}
+
+// CaptureQueries returns stdCtx marked so every statement run through gorm
+// with it (WithContext, DB...) is recorded, and a getter returning a copy of
+// what was recorded so far, in order; transactions begin/commit aren't
+// statements
+func CaptureQueries(stdCtx context.Context) (context.Context, func() []CapturedQuery) {
+	capture := new(queryCapture)
+
+	return context.WithValue(stdCtx, captureQueriesKey{}, capture), func() []CapturedQuery {
+		capture.mu.Lock()
+		defer capture.mu.Unlock()
+
+		return append([]CapturedQuery{}, capture.queries...)
+	}
+}
+
+// no-op for the statements without a CaptureQueries ctx
+func (ctx *GormDBCtx) installCaptureQueries() {
+	capturing := func(tx *gorm.DB) *queryCapture {
+		if tx.Statement.Context == nil {
+			return nil
+		}
+		capture, _ := tx.Statement.Context.Value(captureQueriesKey{}).(*queryCapture)
+		return capture
+	}
+
+	before := func(tx *gorm.DB) {
+		if capturing(tx) != nil {
+			tx.InstanceSet(captureQueriesStartKey, time.Now())
+		}
+	}
+	after := func(tx *gorm.DB) {
+		start, ok := tx.InstanceGet(captureQueriesStartKey)
+		if !ok {
+			return
+		}
+		capture := capturing(tx)
+		if capture == nil {
+			return
+		}
+
+		query := CapturedQuery{
+			SQL:          tx.Statement.SQL.String(),
+			Duration:     time.Since(start.(time.Time)),
+			RowsAffected: tx.Statement.RowsAffected,
+			Err:          tx.Error,
+		}
+
+		capture.mu.Lock()
+		defer capture.mu.Unlock()
+
+		capture.queries = append(capture.queries, query)
+	}
+
+	for _, db := range []*gorm.DB{ctx.R, ctx.W} {
+		if db == nil || db.Callback().Query().Get("kd:capture_queries_before") != nil {
+			continue
+		}
+
+		callback := db.Callback()
+		_ = callback.Create().Before("*").Register("kd:capture_queries_before", before)
+		_ = callback.Create().After("*").Register("kd:capture_queries_after", after)
+		_ = callback.Query().Before("*").Register("kd:capture_queries_before", before)
+		_ = callback.Query().After("*").Register("kd:capture_queries_after", after)
+		_ = callback.Update().Before("*").Register("kd:capture_queries_before", before)
+		_ = callback.Update().After("*").Register("kd:capture_queries_after", after)
+		_ = callback.Delete().Before("*").Register("kd:capture_queries_before", before)
+		_ = callback.Delete().After("*").Register("kd:capture_queries_after", after)
+		_ = callback.Row().Before("*").Register("kd:capture_queries_before", before)
+		_ = callback.Row().After("*").Register("kd:capture_queries_after", after)
+		_ = callback.Raw().Before("*").Register("kd:capture_queries_before", before)
+		_ = callback.Raw().After("*").Register("kd:capture_queries_after", after)
+	}
+}
@@ -0,0 +1,62 @@
+package db_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kdnetwork/code-snippet/go/db"
+)
+
+func TestCaptureQueries(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "capture_queries_test.db"))
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	if err := ctx.W.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)").Error; err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	captureCtx, captured := db.CaptureQueries(context.Background())
+
+	if err := ctx.W.WithContext(captureCtx).Exec("INSERT INTO items (name) VALUES (?), (?)", "a", "b").Error; err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	var names []string
+	if err := ctx.DB(captureCtx).Raw("SELECT name FROM items ORDER BY id").Scan(&names).Error; err != nil {
+		t.Fatalf("Select failed: %v", err)
+	}
+
+	// outside of the captured context
+	if _, err := ctx.CountRows(context.Background(), "items"); err != nil {
+		t.Fatalf("CountRows failed: %v", err)
+	}
+
+	queries := captured()
+	if len(queries) != 2 {
+		t.Fatalf("Expected 2 captured queries, got %d: %+v", len(queries), queries)
+	}
+	if !strings.HasPrefix(queries[0].SQL, "INSERT INTO items") || queries[0].RowsAffected != 2 {
+		t.Errorf("Unexpected first query: %+v", queries[0])
+	}
+	if !strings.HasPrefix(queries[1].SQL, "SELECT name FROM items") {
+		t.Errorf("Unexpected second query: %+v", queries[1])
+	}
+	for i, query := range queries {
+		if query.Duration <= 0 || query.Err != nil {
+			t.Errorf("query %d should have a timing and no error: %+v", i, query)
+		}
+	}
+
+	t.Run("Error", func(t *testing.T) {
+		errCtx, captured := db.CaptureQueries(context.Background())
+		_ = ctx.W.WithContext(errCtx).Exec("INSERT INTO missing (name) VALUES ('a')")
+
+		if queries := captured(); len(queries) != 1 || queries[0].Err == nil {
+			t.Errorf("Expected the failed query with its error, got %+v", queries)
+		}
+	})
+}
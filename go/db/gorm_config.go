@@ -0,0 +1,134 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm/logger"
+)
+
+// Duration reads "5s", "1m30s"... from JSON/YAML/TOML strings
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Config is the plain struct version of the SetX chain, for config files
+type Config struct {
+	Mode          string `json:"mode" yaml:"mode" toml:"mode"`
+	ServicePrefix string `json:"service_prefix" yaml:"service_prefix" toml:"service_prefix"`
+	// silent, error, warn, info (default: gorm default, silent)
+	LogLevel string `json:"log_level" yaml:"log_level" toml:"log_level"`
+
+	// sqlite
+	Path            string `json:"path" yaml:"path" toml:"path"`
+	WAL             bool   `json:"wal" yaml:"wal" toml:"wal"`
+	AllowMemoryMode bool   `json:"allow_memory_mode" yaml:"allow_memory_mode" toml:"allow_memory_mode"`
+
+	// mysql/postgresql, Port 0 -> default port of Mode
+	Host      string `json:"host" yaml:"host" toml:"host"`
+	Port      int    `json:"port" yaml:"port" toml:"port"`
+	User      string `json:"user" yaml:"user" toml:"user"`
+	Password  string `json:"password" yaml:"password" toml:"password"`
+	DBName    string `json:"db_name" yaml:"db_name" toml:"db_name"`
+	TLSOption string `json:"tls_option" yaml:"tls_option" toml:"tls_option"`
+
+	DialTimeout      Duration `json:"dial_timeout" yaml:"dial_timeout" toml:"dial_timeout"`
+	StatementTimeout Duration `json:"statement_timeout" yaml:"statement_timeout" toml:"statement_timeout"`
+
+	// pool, 0 -> database/sql default
+	MaxOpenConns    int      `json:"max_open_conns" yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int      `json:"max_idle_conns" yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime Duration `json:"conn_max_lifetime" yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	ConnMaxIdleTime Duration `json:"conn_max_idle_time" yaml:"conn_max_idle_time" toml:"conn_max_idle_time"`
+}
+
+var logLevels = map[string]logger.LogLevel{
+	"silent": logger.Silent,
+	"error":  logger.Error,
+	"warn":   logger.Warn,
+	"info":   logger.Info,
+}
+
+// NewFromConfig validates cfg and returns a ctx ready to Connect
+func NewFromConfig(cfg Config) (*GormDBCtx, error) {
+	mode := strings.ToLower(cfg.Mode)
+	if !slices.Contains([]string{DBModeMySQL, DBModePostgreSQL, DBModeSQLite}, mode) {
+		return nil, errors.New("invalid db mode `" + cfg.Mode + "`")
+	}
+
+	var errs []error
+	switch mode {
+	case DBModeSQLite:
+		if cfg.Path == "" {
+			errs = append(errs, errors.New("path is required for sqlite"))
+		}
+	default:
+		if cfg.Host == "" {
+			errs = append(errs, fmt.Errorf("host is required for %s", mode))
+		}
+		if cfg.Port < 0 || cfg.Port > 65535 {
+			errs = append(errs, fmt.Errorf("invalid port %d", cfg.Port))
+		}
+	}
+
+	logLevel, ok := logLevels[strings.ToLower(cfg.LogLevel)]
+	if !ok && cfg.LogLevel != "" {
+		errs = append(errs, errors.New("invalid log level `"+cfg.LogLevel+"`"))
+	}
+
+	for name, d := range map[string]Duration{
+		"dial_timeout":       cfg.DialTimeout,
+		"statement_timeout":  cfg.StatementTimeout,
+		"conn_max_lifetime":  cfg.ConnMaxLifetime,
+		"conn_max_idle_time": cfg.ConnMaxIdleTime,
+	} {
+		if d < 0 {
+			errs = append(errs, fmt.Errorf("negative %s", name))
+		}
+	}
+	if cfg.MaxOpenConns < 0 || cfg.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("negative pool size"))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	ctx := new(GormDBCtx).SetDBMode(mode)
+	ctx.ServicePrefix = cfg.ServicePrefix
+	ctx.LogLevel = logLevel
+
+	if mode == DBModeSQLite {
+		ctx.SetDBPath(cfg.Path)
+		ctx.WALMode = cfg.WAL
+		ctx.AllowMemoryMode = cfg.AllowMemoryMode
+	} else {
+		ctx.SetDBAuthParts(cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.DBName, cfg.TLSOption)
+	}
+
+	if cfg.DialTimeout > 0 {
+		dialTimeout := time.Duration(cfg.DialTimeout)
+		ctx.SetDialTimeout(&dialTimeout)
+	}
+	ctx.SetStatementTimeout(time.Duration(cfg.StatementTimeout)).
+		SetMaxOpenConns(cfg.MaxOpenConns).
+		SetMaxIdleConns(cfg.MaxIdleConns).
+		SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime)).
+		SetConnMaxIdleTime(time.Duration(cfg.ConnMaxIdleTime))
+
+	return ctx, nil
+}
@@ -0,0 +1,98 @@
+package db_test
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kdnetwork/code-snippet/go/db"
+)
+
+func TestNewFromConfig(t *testing.T) {
+	parse := func(t *testing.T, raw string) db.Config {
+		t.Helper()
+		var cfg db.Config
+		if err := json.Unmarshal([]byte(raw), &cfg); err != nil {
+			t.Fatalf("Failed to unmarshal config: %v", err)
+		}
+		return cfg
+	}
+
+	t.Run("SQLite", func(t *testing.T) {
+		dbFile := filepath.Join(t.TempDir(), "config_test.db")
+		cfg := parse(t, `{"mode": "sqlite", "path": `+strconv.Quote(dbFile)+`, "wal": true, "log_level": "warn", "max_open_conns": 4}`)
+
+		ctx, err := db.NewFromConfig(cfg)
+		if err != nil {
+			t.Fatalf("NewFromConfig failed: %v", err)
+		}
+		if err := ctx.Connect(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+		defer ctx.Close()
+
+		if mode, err := ctx.JournalMode(); err != nil || mode != "wal" {
+			t.Errorf("Expected wal, got %s (%v)", mode, err)
+		}
+		connr, _ := ctx.R.DB()
+		if n := connr.Stats().MaxOpenConnections; n != 4 {
+			t.Errorf("Expected MaxOpenConnections 4, got %d", n)
+		}
+	})
+
+	t.Run("MySQL", func(t *testing.T) {
+		cfg := parse(t, `{"mode": "mysql", "host": "db.internal", "user": "app", "password": "pw", "db_name": "app", "dial_timeout": "3s", "statement_timeout": "1500ms"}`)
+
+		ctx, err := db.NewFromConfig(cfg)
+		if err != nil {
+			t.Fatalf("NewFromConfig failed: %v", err)
+		}
+		dsn, err := db.AuthMySQLConfig(ctx)
+		if err != nil {
+			t.Fatalf("Failed to build MySQL config: %v", err)
+		}
+		if dsn.Addr != "db.internal:3306" || dsn.User != "app" || dsn.DBName != "app" {
+			t.Errorf("unexpected dsn: %s", dsn.FormatDSN())
+		}
+		if dsn.Timeout != 3*time.Second || dsn.Params["max_execution_time"] != "1500" {
+			t.Errorf("timeouts not applied: %s", dsn.FormatDSN())
+		}
+	})
+
+	t.Run("PostgreSQL", func(t *testing.T) {
+		cfg := parse(t, `{"mode": "postgresql", "host": "db.internal", "port": 6432, "user": "app", "password": "pw", "db_name": "app", "tls_option": "disable"}`)
+
+		ctx, err := db.NewFromConfig(cfg)
+		if err != nil {
+			t.Fatalf("NewFromConfig failed: %v", err)
+		}
+		pgxConfig, err := db.AuthPostgreSQLConfig(ctx)
+		if err != nil {
+			t.Fatalf("Failed to build PostgreSQL config: %v", err)
+		}
+		if pgxConfig.Host != "db.internal" || pgxConfig.Port != 6432 || pgxConfig.Database != "app" {
+			t.Errorf("unexpected config: %s:%d/%s", pgxConfig.Host, pgxConfig.Port, pgxConfig.Database)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for name, raw := range map[string]string{
+			"Mode":        `{"mode": "oracle"}`,
+			"MissingPath": `{"mode": "sqlite"}`,
+			"MissingHost": `{"mode": "mysql"}`,
+			"Port":        `{"mode": "postgresql", "host": "db.internal", "port": 70000}`,
+			"LogLevel":    `{"mode": "sqlite", "path": "a.db", "log_level": "loud"}`,
+		} {
+			if _, err := db.NewFromConfig(parse(t, raw)); err == nil {
+				t.Errorf("%s: expected a validation error", name)
+			}
+		}
+
+		var cfg db.Config
+		if err := json.Unmarshal([]byte(`{"dial_timeout": "soon"}`), &cfg); err == nil {
+			t.Error("Expected an invalid duration to fail unmarshaling")
+		}
+	})
+}
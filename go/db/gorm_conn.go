@@ -1,12 +1,16 @@
 package db
 
 import (
+	"cmp"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"database/sql"
 	"errors"
+	"io/fs"
 	"log/slog"
+	"maps"
+	"net"
 	"net/url"
 	"os"
 	"slices"
@@ -16,6 +20,7 @@ import (
 	"time"
 
 	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5"
 	gorm_mysql_driver "gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -28,6 +33,17 @@ const (
 	DBModePostgreSQL = "postgresql"
 )
 
+var ErrNotSupported = errors.New("not supported db")
+
+// returned instead of a nil-pointer panic when R/W are used before Connect
+// (or after Close)
+var ErrNotConnected = errors.New("not connected")
+
+var defaultPorts = map[string]int{
+	DBModeMySQL:      3306,
+	DBModePostgreSQL: 5432,
+}
+
 type GormDBCtx struct {
 	// for mysql/postgresql: R == W
 	R *gorm.DB
@@ -35,15 +51,34 @@ type GormDBCtx struct {
 
 	LogLevel      logger.LogLevel
 	logger        logger.Interface
+	liveLogger    *liveLogger
 	ServicePrefix string
 	DBMode        string
+	instanceLabel string
 
 	// *- sqlite only
 	AllowMemoryMode bool
 	WALMode         bool
+	walOptional     bool
+
+	// path of the last ConnectToSQLite
+	sqlitePath string
+	// auth of the last ConnectToMySQL/ConnectToPostgreSQL
+	serverConnArgs serverConnArgs
+	// busy handler of the current W pool, read by its retries and new
+	// connections
+	sqliteBusy *atomic.Pointer[func(attempts int) bool]
+
+	sqliteBusyHandler  func(attempts int) bool
+	sqlitePageSize     int
+	sqliteAutoVacuum   string
+	sqliteMmapSize     int64
+	sqliteSecureDelete string
 
 	// *- mysql only
-	CertPool *x509.CertPool
+	CertPool          *x509.CertPool
+	interpolateParams bool
+	failoverHosts     []string
 
 	// auth
 	dbPath    string
@@ -53,9 +88,58 @@ type GormDBCtx struct {
 	host      string
 	tlsOption string
 
+	logDSN      bool
+	connInitSQL []string
+	// EnforceReadOnly
+	readOnly bool
+
+	fastDBCheckRetries int
+	fastDBCheckDelay   time.Duration
+
+	queryMetricsEnabled atomic.Bool
+	queryMetrics        *queryMetrics
+	maxRows             atomic.Int64
+	acquireTimeout      atomic.Int64
+	slowQueryWatchdog   atomic.Int64
+
+	// pool, 0 -> database/sql default
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+	connMaxIdleTime time.Duration
+
+	connMaxLifetimeJitter float64
+	connReaperStop        chan struct{}
+	connReaperDone        chan struct{}
+
+	connStatsInterval time.Duration
+	connStatsStop     chan struct{}
+	connStatsDone     chan struct{}
+
 	// timeout
 	dialTimeout        *time.Duration
+	statementTimeout   time.Duration
 	NumLeakedGoroutine atomic.Int64
+
+	// *- mysql/postgresql only
+	dialContext     func(ctx context.Context, network, addr string) (net.Conn, error)
+	connID          func(stdCtx context.Context) string
+	tlsServerName   string
+	timeZone        string
+	maxConnsPerHost int
+
+	// *- postgresql only
+	postgresParams map[string]string
+	// of the last Connect, for the dedicated connections of Listen
+	pgxConfig *pgx.ConnConfig
+}
+
+type serverConnArgs struct {
+	username  string
+	password  string
+	host      string
+	dbName    string
+	tlsOption string
 }
 
 // mysql, sqlite, postgresql
@@ -76,6 +160,72 @@ func (ctx *GormDBCtx) SetDBPath(path string) *GormDBCtx {
 	return ctx
 }
 
+// sqlite
+//
+// 512 ~ 65536 (power of 2), only applies to a fresh database: it must be set
+// before the first table is created (or followed by a VACUUM), and can't be
+// changed at all once the database is in WAL mode
+func (ctx *GormDBCtx) SetSQLitePageSize(size int) *GormDBCtx {
+	if size >= 512 && size <= 65536 && size&(size-1) == 0 {
+		ctx.sqlitePageSize = size
+	}
+
+	return ctx
+}
+
+// sqlite
+//
+// none, full, incremental; switching from none on an existing database only
+// takes effect after a VACUUM
+func (ctx *GormDBCtx) SetSQLiteAutoVacuum(mode string) *GormDBCtx {
+	lowerMode := strings.ToLower(mode)
+	if slices.Contains([]string{"none", "full", "incremental"}, lowerMode) {
+		ctx.sqliteAutoVacuum = lowerMode
+	}
+
+	return ctx
+}
+
+// sqlite
+//
+// bytes of the database file read through memory-mapped I/O, set on every
+// connection (R included), 0 -> sqlite default (usually disabled); capped by
+// SQLITE_MAX_MMAP_SIZE of the build and the address space of the OS, in WAL
+// mode the -shm file is mapped anyway and writes still go through the WAL
+func (ctx *GormDBCtx) SetSQLiteMmapSize(bytes int64) *GormDBCtx {
+	if bytes >= 0 {
+		ctx.sqliteMmapSize = bytes
+	}
+
+	return ctx
+}
+
+// sqlite
+//
+// on, off, fast; set on every connection. on -> deleted content is
+// overwritten with zeros, every delete writes (and with WAL, logs) the freed
+// pages too, so deletes cost more I/O; fast -> only overwritten when it costs
+// no extra I/O, old content may remain in the freelist and the WAL. A VACUUM
+// is still needed for content deleted before it was enabled.
+func (ctx *GormDBCtx) SetSQLiteSecureDelete(mode string) *GormDBCtx {
+	lowerMode := strings.ToLower(mode)
+	if slices.Contains([]string{"on", "off", "fast"}, lowerMode) {
+		ctx.sqliteSecureDelete = lowerMode
+	}
+
+	return ctx
+}
+
+// sqlite
+//
+// required = false -> a failing WAL pragma only logs a warning and the db
+// stays on the default journal mode (default: true)
+func (ctx *GormDBCtx) SetWALRequired(required bool) *GormDBCtx {
+	ctx.walOptional = !required
+
+	return ctx
+}
+
 // mysql/postgresql
 func (ctx *GormDBCtx) SetDBAuth(username, password, host, dbName, tlsOption string) *GormDBCtx {
 	ctx.username = username
@@ -87,6 +237,38 @@ func (ctx *GormDBCtx) SetDBAuth(username, password, host, dbName, tlsOption stri
 	return ctx
 }
 
+// mysql/postgresql
+//
+// port <= 0 -> default port of DBMode (3306/5432), call it after SetDBMode
+func (ctx *GormDBCtx) SetDBAuthParts(username, password, host string, port int, dbName, tlsOption string) *GormDBCtx {
+	if port <= 0 {
+		port = defaultPorts[ctx.DBMode]
+	}
+	if port > 0 && !isUnixSocket(host) {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+
+	return ctx.SetDBAuth(username, password, host, dbName, tlsOption)
+}
+
+// mysql/postgresql
+func (ctx *GormDBCtx) SetDBName(dbName string) *GormDBCtx {
+	ctx.dbName = dbName
+
+	return ctx
+}
+
+// mysql
+//
+// extra nodes (host:port) tried in order after the SetDBAuth host when it
+// can't be reached, on Connect and on every new connection of the pool; the
+// TLS server name still comes from the SetDBAuth host
+func (ctx *GormDBCtx) SetFailoverHosts(hosts []string) *GormDBCtx {
+	ctx.failoverHosts = slices.Clone(hosts)
+
+	return ctx
+}
+
 // mysql
 func (ctx *GormDBCtx) SetCertPool(pool *x509.CertPool) *GormDBCtx {
 	ctx.CertPool = pool
@@ -94,6 +276,18 @@ func (ctx *GormDBCtx) SetCertPool(pool *x509.CertPool) *GormDBCtx {
 	return ctx
 }
 
+// mysql
+//
+// placeholders are interpolated client-side (one round trip instead of
+// prepare/exec/close). The driver escapes every arg, but the escaping depends
+// on the connection charset, keep the default utf8mb4 and never switch to a
+// multi-byte charset like GBK/Big5 with SET NAMES when this is enabled.
+func (ctx *GormDBCtx) SetMySQLInterpolateParams(enabled bool) *GormDBCtx {
+	ctx.interpolateParams = enabled
+
+	return ctx
+}
+
 // mysql/postgresql
 func (ctx *GormDBCtx) SetDialTimeout(timeout *time.Duration) *GormDBCtx {
 	if timeout != nil && timeout.Seconds() >= 0 {
@@ -103,11 +297,114 @@ func (ctx *GormDBCtx) SetDialTimeout(timeout *time.Duration) *GormDBCtx {
 	return ctx
 }
 
+// mysql/postgresql
+//
+// overrides how the connection is dialed (SOCKS proxy, SSH tunnel...),
+// for postgresql the host is passed to dial unresolved
+func (ctx *GormDBCtx) SetDialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) *GormDBCtx {
+	ctx.dialContext = dial
+
+	return ctx
+}
+
+// mysql/postgresql
+//
+// name the server certificate must be issued for instead of the host, the
+// chain is still verified against the CA (tlsOption file, SetCertPool or the
+// system roots); only applies when TLS is on: mysql custom CA/CertPool/"true",
+// postgresql verify-full
+func (ctx *GormDBCtx) SetTLSServerName(name string) *GormDBCtx {
+	ctx.tlsServerName = name
+
+	return ctx
+}
+
+// mysql/postgresql
+//
+// IANA name (Europe/Paris, UTC...), "" -> the server/driver default
+// mysql -> loc, the zone DATETIME/TIMESTAMP values are parsed into
+// postgresql -> TimeZone of the session, timestamptz are rendered in it
+func (ctx *GormDBCtx) SetTimeZone(tz string) *GormDBCtx {
+	ctx.timeZone = tz
+
+	return ctx
+}
+
+// postgresql
+//
+// libpq connection options merged into the DSN (keepalives, tcp_user_timeout,
+// options=-c ..., application_name...), keys outside the libpq allowlist are
+// dropped with a warning; other setters (SetDialTimeout...) take precedence
+func (ctx *GormDBCtx) SetPostgresParams(params map[string]string) *GormDBCtx {
+	ctx.postgresParams = make(map[string]string, len(params))
+	for key, value := range params {
+		if !postgresParamsAllowlist[key] {
+			ctx.slogger().Warn(ctx.ServicePrefix, "method", "postgres_params", "err", "unknown libpq option `"+key+"`")
+			continue
+		}
+		ctx.postgresParams[key] = value
+	}
+
+	return ctx
+}
+
+// mysql/postgresql
+//
+// server-side limit, postgresql -> statement_timeout, mysql -> max_execution_time
+// (read-only SELECT only), 0 -> disabled
+func (ctx *GormDBCtx) SetStatementTimeout(timeout time.Duration) *GormDBCtx {
+	if timeout >= 0 {
+		ctx.statementTimeout = timeout
+	}
+
+	return ctx
+}
+
+// log the DSN (password redacted) on successful connect
+func (ctx *GormDBCtx) SetLogDSN(enabled bool) *GormDBCtx {
+	ctx.logDSN = enabled
+	return ctx
+}
+
+// mysql/postgresql/sqlite
+//
+// retries > 0 -> FastDBCheck checks again up to retries times, delay apart,
+// while the database isn't found or the check failed transiently
+// (IsRetryable), for databases just provisioned (replica lag...); false with
+// a nil error then means not found on every try
+func (ctx *GormDBCtx) SetFastDBCheckRetry(retries int, delay time.Duration) *GormDBCtx {
+	ctx.fastDBCheckRetries = max(retries, 0)
+	ctx.fastDBCheckDelay = max(delay, 0)
+
+	return ctx
+}
+
 func (ctx *GormDBCtx) SetLogger(logger logger.Interface) *GormDBCtx {
 	ctx.logger = logger
 	return ctx
 }
 
+// names this connection (primary, analytics...) apart from ServicePrefix,
+// added as the "instance" attribute of every slog record of ctx
+func (ctx *GormDBCtx) SetInstanceLabel(label string) *GormDBCtx {
+	ctx.instanceLabel = label
+	return ctx
+}
+
+// InstanceLabel -> for tagging metrics/traces exported from ctx
+func (ctx *GormDBCtx) InstanceLabel() string {
+	return ctx.instanceLabel
+}
+
+// attributes shared by every slog record of ctx
+func (ctx *GormDBCtx) slogger() *slog.Logger {
+	l := slog.Default().With("dbmode", ctx.DBMode)
+	if ctx.instanceLabel != "" {
+		l = l.With("instance", ctx.instanceLabel)
+	}
+	return l
+}
+
 func (ctx *GormDBCtx) Logger() logger.Interface {
 	if ctx.logger != nil {
 		return ctx.logger
@@ -115,6 +412,75 @@ func (ctx *GormDBCtx) Logger() logger.Interface {
 	return logger.Default.LogMode(ctx.LogLevel)
 }
 
+// Clone copies the configuration only, handles (R/W) and counters are reset
+func (ctx *GormDBCtx) Clone() *GormDBCtx {
+	clone := &GormDBCtx{
+		LogLevel:      ctx.LogLevel,
+		logger:        ctx.logger,
+		ServicePrefix: ctx.ServicePrefix,
+		DBMode:        ctx.DBMode,
+		instanceLabel: ctx.instanceLabel,
+
+		AllowMemoryMode: ctx.AllowMemoryMode,
+		WALMode:         ctx.WALMode,
+		walOptional:     ctx.walOptional,
+
+		sqliteBusyHandler:  ctx.sqliteBusyHandler,
+		sqlitePageSize:     ctx.sqlitePageSize,
+		sqliteAutoVacuum:   ctx.sqliteAutoVacuum,
+		sqliteMmapSize:     ctx.sqliteMmapSize,
+		sqliteSecureDelete: ctx.sqliteSecureDelete,
+
+		interpolateParams: ctx.interpolateParams,
+		failoverHosts:     slices.Clone(ctx.failoverHosts),
+
+		dbPath:    ctx.dbPath,
+		dbName:    ctx.dbName,
+		username:  ctx.username,
+		password:  ctx.password,
+		host:      ctx.host,
+		tlsOption: ctx.tlsOption,
+
+		logDSN:      ctx.logDSN,
+		connInitSQL: slices.Clone(ctx.connInitSQL),
+		readOnly:    ctx.readOnly,
+
+		fastDBCheckRetries: ctx.fastDBCheckRetries,
+		fastDBCheckDelay:   ctx.fastDBCheckDelay,
+
+		maxOpenConns:    ctx.maxOpenConns,
+		maxIdleConns:    ctx.maxIdleConns,
+		connMaxLifetime: ctx.connMaxLifetime,
+		connMaxIdleTime: ctx.connMaxIdleTime,
+
+		connMaxLifetimeJitter: ctx.connMaxLifetimeJitter,
+		connStatsInterval:     ctx.connStatsInterval,
+
+		statementTimeout: ctx.statementTimeout,
+		dialContext:      ctx.dialContext,
+		connID:           ctx.connID,
+		tlsServerName:    ctx.tlsServerName,
+		timeZone:         ctx.timeZone,
+		maxConnsPerHost:  ctx.maxConnsPerHost,
+		postgresParams:   maps.Clone(ctx.postgresParams),
+	}
+	clone.queryMetricsEnabled.Store(ctx.queryMetricsEnabled.Load())
+	clone.maxRows.Store(ctx.maxRows.Load())
+	clone.acquireTimeout.Store(ctx.acquireTimeout.Load())
+	clone.slowQueryWatchdog.Store(ctx.slowQueryWatchdog.Load())
+
+	// ConnectToMySQL appends to the pool
+	if ctx.CertPool != nil {
+		clone.CertPool = ctx.CertPool.Clone()
+	}
+	if ctx.dialTimeout != nil {
+		dialTimeout := *ctx.dialTimeout
+		clone.dialTimeout = &dialTimeout
+	}
+
+	return clone
+}
+
 func (ctx *GormDBCtx) Connect() error {
 	switch ctx.DBMode {
 	case DBModeSQLite:
@@ -145,20 +511,31 @@ func (ctx *GormDBCtx) ConnectToDefault() error {
 	return errors.New("invalid db mode `" + ctx.DBMode + "`")
 }
 
-func (ctx *GormDBCtx) Close() error {
-	closeDB := func(db *gorm.DB) error {
-		if db == nil {
-			return nil
-		}
-		sqlDB, err := db.DB()
-		if err != nil {
-			return err
-		}
-		if sqlDB != nil {
-			return sqlDB.Close()
-		}
+var closeSQLDB = (*sql.DB).Close
+
+func closeDB(db *gorm.DB) error {
+	return closeDBWith(db, closeSQLDB)
+}
+
+// closeSQLDB resolved by the caller, goroutines outliving the call don't read
+// it again
+func closeDBWith(db *gorm.DB, closeSQLDB func(*sql.DB) error) error {
+	if db == nil {
 		return nil
 	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	if sqlDB != nil {
+		return closeSQLDB(sqlDB)
+	}
+	return nil
+}
+
+func (ctx *GormDBCtx) Close() error {
+	ctx.stopConnReapers()
+	ctx.stopConnStats()
 
 	if err := closeDB(ctx.R); err != nil {
 		return err
@@ -176,36 +553,140 @@ func (ctx *GormDBCtx) Close() error {
 	return nil
 }
 
+// CloseWithTimeout is Close bounded by timeout, so a hung driver can't block
+// shutdown. R/W are reset even if the close is still running.
+func (ctx *GormDBCtx) CloseWithTimeout(timeout time.Duration) error {
+	ctx.stopConnReapers()
+	ctx.stopConnStats()
+
+	r, w := ctx.R, ctx.W
+	ctx.R = nil
+	ctx.W = nil
+
+	closeFn := closeSQLDB
+	done := make(chan error, 1)
+	go func() {
+		err := closeDBWith(r, closeFn)
+		if w != r {
+			err = errors.Join(err, closeDBWith(w, closeFn))
+		}
+		done <- err
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		err := errors.New("database close timeout")
+		ctx.slogger().Error(ctx.ServicePrefix, "method", "close", "err", err)
+		return err
+	}
+}
+
+func (ctx *GormDBCtx) ensureConnected() error {
+	if ctx.R == nil || ctx.W == nil {
+		return ErrNotConnected
+	}
+	return nil
+}
+
+// sqlite -> true (R: unlimited, W: max 1 conn)
+// mysql/postgresql -> false (R == W)
+func (ctx *GormDBCtx) HasSeparateReadWrite() bool {
+	return ctx.R != nil && ctx.W != nil && ctx.R != ctx.W
+}
+
+// Session -> ctx.W.Session(opts), for per-query settings (SkipDefaultTransaction,
+// FullSaveAssociations, AllowGlobalUpdate...) without touching the shared W;
+// not connected -> a handle failing with ErrNotConnected
+func (ctx *GormDBCtx) Session(opts *gorm.Session) *gorm.DB {
+	if err := ctx.ensureConnected(); err != nil {
+		return notConnectedDB()
+	}
+	return ctx.W.Session(opts)
+}
+
+// ReadSession -> ctx.R.Session(opts)
+func (ctx *GormDBCtx) ReadSession(opts *gorm.Session) *gorm.DB {
+	if err := ctx.ensureConnected(); err != nil {
+		return notConnectedDB()
+	}
+	return ctx.R.Session(opts)
+}
+
+// :memory:, file::memory:..., file:name?mode=memory
+func isSQLiteMemoryPath(path string) bool {
+	return path == ":memory:" || strings.HasPrefix(path, "file::memory:") || strings.Contains(path, "mode=memory")
+}
+
+// IsInMemory -> sqlite on a memory database (the path of the last
+// ConnectToSQLite, else SetDBPath), nothing is persisted and the data goes
+// away with the last connection
+func (ctx *GormDBCtx) IsInMemory() bool {
+	path := ctx.dbPath
+	if ctx.sqlitePath != "" {
+		path = ctx.sqlitePath
+	}
+	return ctx.DBMode == DBModeSQLite && isSQLiteMemoryPath(path)
+}
+
 func (ctx *GormDBCtx) ConnectToSQLite(path string) error {
 	ctx.DBMode = DBModeSQLite
+	ctx.sqlitePath = path
 
 	// memory mode
-	if !ctx.AllowMemoryMode && (path == ":memory:" || strings.HasPrefix(path, "file::memory:") || strings.Contains(path, "mode=memory")) {
-		slog.Error(ctx.ServicePrefix, "dbmode", ctx.DBMode, "method", "precheck", "err", "memory mode not allowed")
+	if !ctx.AllowMemoryMode && isSQLiteMemoryPath(path) {
+		ctx.slogger().Error(ctx.ServicePrefix, "method", "precheck", "err", "memory mode not allowed")
 		return errors.New("memory mode not allowed")
 	}
 
+	gormLogger := ctx.connLogger()
+
+	busy := new(atomic.Pointer[func(attempts int) bool])
+	if handler := ctx.sqliteBusyHandler; handler != nil {
+		busy.Store(&handler)
+	}
+
 	// write
-	writeDBHandle, err := gorm.Open(SqliteDriverOpen(path), &gorm.Config{
-		Logger: ctx.Logger(),
+	writeDialector, err := ctx.openSQLiteDialector(path, busy)
+	if err != nil {
+		ctx.slogger().Error(ctx.ServicePrefix, "method", "open", "conn_type", "w", "err", err)
+		return err
+	}
+	writeDBHandle, err := gorm.Open(writeDialector, &gorm.Config{
+		Logger: gormLogger,
 	})
 	if err != nil {
-		slog.Error(ctx.ServicePrefix, "dbmode", ctx.DBMode, "method", "open", "conn_type", "w", "err", err)
+		ctx.slogger().Error(ctx.ServicePrefix, "method", "open", "conn_type", "w", "err", err)
 		return err
 	}
 	connw, err := writeDBHandle.DB()
 	if err != nil {
-		slog.Error(ctx.ServicePrefix, "dbmode", ctx.DBMode, "method", "edit", "conn_type", "w", "err", err)
+		ctx.slogger().Error(ctx.ServicePrefix, "method", "edit", "conn_type", "w", "err", err)
 		return err
 	}
 	connw.SetMaxOpenConns(1) // prevent "database is locked" error
 
+	// wrapped before the handle is shared, SetSQLiteBusyHandler only swaps the
+	// handler
+	busyPool := &busyRetryConnPool{busyRetryTarget: connw, db: connw, handler: busy}
+	writeDBHandle.ConnPool = busyPool
+	writeDBHandle.Statement.ConnPool = busyPool
+
 	//read
-	readDBHandle, err := gorm.Open(SqliteDriverOpen(path), &gorm.Config{
-		Logger: ctx.Logger(),
+	readDialector, err := ctx.openSQLiteDialector(path, nil)
+	if err != nil {
+		ctx.slogger().Error(ctx.ServicePrefix, "method", "open", "conn_type", "r", "err", err)
+		return err
+	}
+	readDBHandle, err := gorm.Open(readDialector, &gorm.Config{
+		Logger: gormLogger,
 	})
 	if err != nil {
-		slog.Error(ctx.ServicePrefix, "dbmode", ctx.DBMode, "method", "open", "conn_type", "r", "err", err)
+		ctx.slogger().Error(ctx.ServicePrefix, "method", "open", "conn_type", "r", "err", err)
 		return err
 	}
 
@@ -217,28 +698,52 @@ func (ctx *GormDBCtx) ConnectToSQLite(path string) error {
 	// 	return err
 	// }
 
-	slog.Info(ctx.ServicePrefix, "dbmode", ctx.DBMode, "status", "connected")
+	ctx.logConnected(path)
 
-	var magicSQLiteExecSQL = `PRAGMA busy_timeout = 5000;PRAGMA synchronous = NORMAL;PRAGMA cache_size = 100000;PRAGMA foreign_keys = true;PRAGMA temp_store = memory;`
+	// the pragmas set in the path by the driver options win
+	dsnPragmas := sqliteDSNPragmas(path)
 
-	if ctx.WALMode {
-		magicSQLiteExecSQL = `PRAGMA journal_mode = WAL;` + magicSQLiteExecSQL
+	// layout pragmas go first, page_size is rejected once WAL is enabled
+	var layoutSQLiteExecSQL string
+	if ctx.sqlitePageSize > 0 && !dsnPragmas.has("page_size") {
+		layoutSQLiteExecSQL += `PRAGMA page_size = ` + strconv.Itoa(ctx.sqlitePageSize) + `;`
+	}
+	if ctx.sqliteAutoVacuum != "" && !dsnPragmas.has("auto_vacuum") {
+		layoutSQLiteExecSQL += `PRAGMA auto_vacuum = ` + ctx.sqliteAutoVacuum + `;`
+	}
+	// query_only rejects auto_vacuum, the layout of an existing file is set
+	// already
+	if layoutSQLiteExecSQL != "" && !ctx.readOnly {
+		if err := writeDBHandle.Exec(layoutSQLiteExecSQL).Error; err != nil {
+			ctx.slogger().Error(ctx.ServicePrefix, "method", "layout", "err", err)
+			return err
+		}
 	}
 
-	if err := writeDBHandle.Exec(magicSQLiteExecSQL).Error; err != nil {
-		slog.Error(ctx.ServicePrefix, "dbmode", ctx.DBMode, "method", "wal", "err", err)
-		return err
+	if ctx.WALMode && !dsnPragmas.has("journal_mode") {
+		if err := writeDBHandle.Exec(`PRAGMA journal_mode = WAL;`).Error; err != nil {
+			if !ctx.walOptional {
+				ctx.slogger().Error(ctx.ServicePrefix, "method", "wal", "err", err)
+				return err
+			}
+			// e.g. NFS/SMB mounts, stays on the default journal mode
+			ctx.slogger().Warn(ctx.ServicePrefix, "method", "wal", "fallback", "default journal mode", "err", err)
+		}
 	}
 
+	ctx.sqliteBusy = busy
 	ctx.R = readDBHandle
 	ctx.W = writeDBHandle
 
+	ctx.applyPoolConfig()
+	ctx.startConnReapers()
+	ctx.startConnStats()
+	ctx.installCallbacks()
+
 	return nil
 }
 
-func (ctx *GormDBCtx) ConnectToMySQL(username string, password string, host string, dbname string, tlsOption string) error {
-	ctx.DBMode = DBModeMySQL
-
+func (ctx *GormDBCtx) mysqlConfig(username string, password string, host string, dbname string, tlsOption string) (*mysql.Config, error) {
 	dsn := mysql.NewConfig()
 	dsn.User = username
 	dsn.Passwd = password
@@ -249,17 +754,33 @@ func (ctx *GormDBCtx) ConnectToMySQL(username string, password string, host stri
 	}
 	dsn.Addr = host
 	dsn.DBName = dbname
-	dsn.Params = map[string]string{
-		"charset":   "utf8mb4",
-		"parseTime": "True",
-		"loc":       "Local",
+	dsn.InterpolateParams = ctx.interpolateParams
+	// Params are sent as SET on the connector, the driver options are fields
+	if err := dsn.Apply(mysql.Charset("utf8mb4", "")); err != nil {
+		return nil, err
+	}
+	dsn.ParseTime = true
+	dsn.Loc = time.Local
+	if ctx.timeZone != "" {
+		loc, err := time.LoadLocation(ctx.timeZone)
+		if err != nil {
+			ctx.slogger().Error(ctx.ServicePrefix, "method", "load_location", "err", err)
+			return nil, err
+		}
+		dsn.Loc = loc
 	}
+	dsn.Params = map[string]string{}
 
 	if dsn.Net == "tcp" {
 		if tlsOption != "" {
 			lowerTLSOption := strings.ToLower(tlsOption)
-			if slices.Contains([]string{"true", "false", "skip-verify", "preferred"}, lowerTLSOption) {
-				dsn.Params["tls"] = lowerTLSOption
+			if lowerTLSOption == "true" && ctx.tlsServerName != "" {
+				dsn.TLS = &tls.Config{
+					ServerName: ctx.tlsServerName,
+					RootCAs:    ctx.CertPool,
+				}
+			} else if slices.Contains([]string{"true", "false", "skip-verify", "preferred"}, lowerTLSOption) {
+				dsn.TLSConfig = lowerTLSOption
 			} else {
 				if ctx.CertPool == nil {
 					ctx.CertPool = x509.NewCertPool()
@@ -267,47 +788,112 @@ func (ctx *GormDBCtx) ConnectToMySQL(username string, password string, host stri
 
 				pem, err := os.ReadFile(tlsOption)
 				if err != nil {
-					slog.Error(ctx.ServicePrefix, "dbmode", ctx.DBMode, "method", "read_cert", "err", err)
-					return err
+					ctx.slogger().Error(ctx.ServicePrefix, "method", "read_cert", "err", err)
+					return nil, err
 				}
 				if ok := ctx.CertPool.AppendCertsFromPEM(pem); !ok {
-					slog.Error(ctx.ServicePrefix, "dbmode", ctx.DBMode, "method", "append_cert", "err", err)
-					return errors.New("failed to append pem")
+					ctx.slogger().Error(ctx.ServicePrefix, "method", "append_cert", "err", err)
+					return nil, errors.New("failed to append pem")
 				}
 				parsedURL, err := url.Parse("tcp://" + host)
 				if err != nil {
-					slog.Error(ctx.ServicePrefix, "dbmode", ctx.DBMode, "method", "read_host", "err", err)
-					return err
+					ctx.slogger().Error(ctx.ServicePrefix, "method", "read_host", "err", err)
+					return nil, err
 				}
 
-				if err = mysql.RegisterTLSConfig("custom", &tls.Config{
-					ServerName: parsedURL.Hostname(),
+				dsn.TLS = &tls.Config{
+					ServerName: cmp.Or(ctx.tlsServerName, parsedURL.Hostname()),
 					RootCAs:    ctx.CertPool,
-				}); err != nil {
-					slog.Error(ctx.ServicePrefix, "dbmode", ctx.DBMode, "method", "register_tls_config_from_file", "err", err)
-					return err
 				}
-				dsn.Params["tls"] = "custom"
 			}
 		} else if ctx.CertPool != nil {
 			parsedURL, err := url.Parse("tcp://" + host)
-			if err = mysql.RegisterTLSConfig("custom", &tls.Config{
-				ServerName: parsedURL.Hostname(),
+			if err != nil {
+				ctx.slogger().Error(ctx.ServicePrefix, "method", "read_host", "err", err)
+				return nil, err
+			}
+			dsn.TLS = &tls.Config{
+				ServerName: cmp.Or(ctx.tlsServerName, parsedURL.Hostname()),
 				RootCAs:    ctx.CertPool,
-			}); err != nil {
-				slog.Error(ctx.ServicePrefix, "dbmode", ctx.DBMode, "method", "register_tls_config_from_cert_pool", "err", err)
-				return err
 			}
-			dsn.Params["tls"] = "custom"
 		}
 	}
 
-	var dbHandle *gorm.DB
-	var err error
+	if ctx.statementTimeout > 0 {
+		dsn.Params["max_execution_time"] = strconv.FormatInt(ctx.statementTimeout.Milliseconds(), 10)
+	}
+
+	if ctx.dialContext != nil {
+		dsn.DialFunc = ctx.dialContext
+	}
 
 	if ctx.dialTimeout != nil {
 		dsn.Timeout = *ctx.dialTimeout
+	}
+
+	if ctx.maxConnsPerHost > 0 {
+		dial := dsn.DialFunc
+		if dial == nil {
+			dial = (&net.Dialer{Timeout: dsn.Timeout}).DialContext
+		}
+		dsn.DialFunc = hostLimitDial(dial, ctx.maxConnsPerHost)
+	}
+
+	if len(ctx.failoverHosts) > 0 && dsn.Net == "tcp" {
+		dsn.DialFunc = ctx.failoverDial(append([]string{host}, ctx.failoverHosts...), dsn.DialFunc, dsn.Timeout)
+	}
+
+	return dsn, nil
+}
 
+// every new connection tries the hosts in order, starting from the last one
+// that worked
+func (ctx *GormDBCtx) failoverDial(hosts []string, dial func(ctx context.Context, network, addr string) (net.Conn, error), timeout time.Duration) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: timeout}).DialContext
+	}
+
+	var current atomic.Int64
+	current.Store(-1)
+
+	return func(dialCtx context.Context, network, _ string) (net.Conn, error) {
+		start := max(current.Load(), 0)
+
+		var errs []error
+		failedOver := false
+		for i := range int64(len(hosts)) {
+			index := (start + i) % int64(len(hosts))
+			conn, err := dial(dialCtx, network, hosts[index])
+			if err == nil {
+				// spilling over from a full host (SetMaxConnsPerHost) isn't a
+				// failover
+				if (i == 0 || failedOver) && current.Swap(index) != index {
+					ctx.slogger().Info(ctx.ServicePrefix, "method", "failover", "host", hosts[index])
+				}
+				return conn, nil
+			}
+			errs = append(errs, err)
+			failedOver = failedOver || !errors.Is(err, ErrMaxConnsPerHost)
+
+			if dialCtx.Err() != nil {
+				break
+			}
+		}
+		return nil, errors.Join(errs...)
+	}
+}
+
+func (ctx *GormDBCtx) ConnectToMySQL(username string, password string, host string, dbname string, tlsOption string) error {
+	ctx.DBMode = DBModeMySQL
+
+	dsn, err := ctx.mysqlConfig(username, password, host, dbname, tlsOption)
+	if err != nil {
+		return err
+	}
+
+	var dbHandle *gorm.DB
+
+	if ctx.dialTimeout != nil {
 		type result struct {
 			db  *gorm.DB
 			err error
@@ -322,9 +908,7 @@ func (ctx *GormDBCtx) ConnectToMySQL(username string, password string, host stri
 			ctx.NumLeakedGoroutine.Add(1)
 			defer ctx.NumLeakedGoroutine.Add(-1)
 
-			db, err := gorm.Open(gorm_mysql_driver.New(gorm_mysql_driver.Config{
-				DSNConfig: dsn,
-			}), &gorm.Config{Logger: ctx.Logger()})
+			db, err := ctx.openMySQL(dsn)
 			resChan <- result{db, err}
 		}()
 
@@ -334,36 +918,60 @@ func (ctx *GormDBCtx) ConnectToMySQL(username string, password string, host stri
 		select {
 		case <-timeoutCtx.Done():
 			err = errors.New("database connection timeout")
-			slog.Error(ctx.ServicePrefix, "dbmode", ctx.DBMode, "method", "connect", "err", err)
+			ctx.slogger().Error(ctx.ServicePrefix, "method", "connect", "err", err)
 			return err
 		case res := <-resChan:
 			if res.err != nil {
-				return res.err
+				return wrapConnectError(res.err)
 			}
 			dbHandle = res.db
 		}
 	} else {
-		dbHandle, err = gorm.Open(gorm_mysql_driver.New(gorm_mysql_driver.Config{
-			DSNConfig: dsn,
-		}), &gorm.Config{Logger: ctx.Logger()})
+		dbHandle, err = ctx.openMySQL(dsn)
 	}
 
 	if err != nil {
-		slog.Error(ctx.ServicePrefix, "dbmode", ctx.DBMode, "method", "open", "err", err)
+		err = wrapConnectError(err)
+		ctx.slogger().Error(ctx.ServicePrefix, "method", "open", "err", err)
 		return err
 	}
 
-	slog.Info(ctx.ServicePrefix, "dbmode", ctx.DBMode, "status", "connected")
+	ctx.logConnected(redactDSN(dsn.FormatDSN()))
 
+	ctx.serverConnArgs = serverConnArgs{username, password, host, dbname, tlsOption}
 	ctx.R = dbHandle
 	ctx.W = dbHandle
+	ctx.applyPoolConfig()
+	ctx.startConnReapers()
+	ctx.startConnStats()
+	ctx.installCallbacks()
+	ctx.checkConnLifetime()
 
 	return nil
 }
 
-func (ctx *GormDBCtx) ConnectToPostgreSQL(username string, password string, host string, dbname string, tlsOption string) error {
-	ctx.DBMode = DBModePostgreSQL
+// gorm formats DSNConfig back into a DSN string, which drops func fields like
+// DialFunc, so the connector is built here
+func (ctx *GormDBCtx) openMySQL(dsn *mysql.Config) (*gorm.DB, error) {
+	connector, err := ctx.mysqlConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB := sql.OpenDB(withConnInitSQL(connector, ctx.serverConnInitSQL()))
+	dbHandle, err := gorm.Open(gorm_mysql_driver.New(gorm_mysql_driver.Config{
+		DSNConfig: dsn,
+		Conn:      sqlDB,
+	}), &gorm.Config{Logger: ctx.connLogger()})
+	if err != nil {
+		_ = sqlDB.Close()
+		return nil, err
+	}
 
+	return dbHandle, nil
+}
+
+func (ctx *GormDBCtx) postgreSQLConfig(username string, password string, host string, dbname string, tlsOption string) (*pgx.ConnConfig, error) {
 	if dbname == "" {
 		dbname = "postgres"
 	}
@@ -384,6 +992,10 @@ func (ctx *GormDBCtx) ConnectToPostgreSQL(username string, password string, host
 
 	q := dsn.Query()
 
+	for key, value := range ctx.postgresParams {
+		q.Set(key, value)
+	}
+
 	if tlsOption != "" {
 		lowerTLSOption := strings.ToLower(tlsOption)
 		if slices.Contains([]string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}, lowerTLSOption) {
@@ -394,31 +1006,104 @@ func (ctx *GormDBCtx) ConnectToPostgreSQL(username string, password string, host
 		}
 	}
 
+	if ctx.timeZone != "" {
+		q.Set("TimeZone", ctx.timeZone)
+	}
+
 	if ctx.dialTimeout != nil {
 		q.Set("connect_timeout", strconv.Itoa(int(ctx.dialTimeout.Seconds())))
 	}
 
+	if ctx.statementTimeout > 0 {
+		q.Set("statement_timeout", strconv.FormatInt(ctx.statementTimeout.Milliseconds(), 10))
+	}
+
 	dsn.RawQuery = q.Encode()
 
+	pgxConfig, err := pgx.ParseConfig(dsn.String())
+	if err != nil {
+		ctx.slogger().Error(ctx.ServicePrefix, "method", "parse_config", "err", err)
+		return nil, err
+	}
+	pgxConfig.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol // disables implicit prepared statement usage
+
+	if ctx.dialContext != nil {
+		pgxConfig.DialFunc = ctx.dialContext
+		// let the dialer (proxy) resolve the host
+		pgxConfig.LookupFunc = func(_ context.Context, host string) ([]string, error) {
+			return []string{host}, nil
+		}
+	}
+
+	// verify-full checks the host against the certificate, verify-ca doesn't
+	if ctx.tlsServerName != "" {
+		if pgxConfig.TLSConfig != nil {
+			pgxConfig.TLSConfig.ServerName = ctx.tlsServerName
+		}
+		for _, fallback := range pgxConfig.Fallbacks {
+			if fallback.TLSConfig != nil {
+				fallback.TLSConfig.ServerName = ctx.tlsServerName
+			}
+		}
+	}
+
+	if err := applyPostgresSocketParams(pgxConfig, ctx.postgresParams); err != nil {
+		ctx.slogger().Error(ctx.ServicePrefix, "method", "parse_config", "err", err)
+		return nil, err
+	}
+	if _, ok := ctx.postgresParams["tcp_user_timeout"]; ok && !tcpUserTimeoutSupported {
+		ctx.slogger().Warn(ctx.ServicePrefix, "method", "parse_config", "err", "tcp_user_timeout is not supported on this platform, ignored")
+	}
+
+	// outermost, the socket params need the *net.TCPConn
+	if ctx.maxConnsPerHost > 0 {
+		pgxConfig.DialFunc = hostLimitDial(pgxConfig.DialFunc, ctx.maxConnsPerHost)
+	}
+
+	return pgxConfig, nil
+}
+
+func (ctx *GormDBCtx) ConnectToPostgreSQL(username string, password string, host string, dbname string, tlsOption string) error {
+	ctx.DBMode = DBModePostgreSQL
+
+	pgxConfig, err := ctx.postgreSQLConfig(username, password, host, dbname, tlsOption)
+	if err != nil {
+		return err
+	}
+
+	sqlDB := sql.OpenDB(withConnInitSQL(ctx.postgreSQLConnector(pgxConfig), ctx.serverConnInitSQL()))
 	dbHandle, err := gorm.Open(postgres.New(postgres.Config{
-		DSN:                  dsn.String(),
-		PreferSimpleProtocol: true, // disables implicit prepared statement usage
-	}), &gorm.Config{Logger: ctx.Logger()})
+		Conn: sqlDB,
+	}), &gorm.Config{Logger: ctx.connLogger()})
 
 	if err != nil {
-		slog.Error(ctx.ServicePrefix, "dbmode", ctx.DBMode, "method", "open", "err", err)
+		_ = sqlDB.Close()
+		err = wrapConnectError(err)
+		ctx.slogger().Error(ctx.ServicePrefix, "method", "open", "err", err)
 		return err
 	}
 
-	slog.Info(ctx.ServicePrefix, "dbmode", ctx.DBMode, "status", "connected")
+	ctx.logConnected(redactDSN(pgxConfig.ConnString()))
 
+	ctx.serverConnArgs = serverConnArgs{username, password, host, dbname, tlsOption}
+	ctx.pgxConfig = pgxConfig
 	ctx.R = dbHandle
 	ctx.W = dbHandle
+	ctx.applyPoolConfig()
+	ctx.startConnReapers()
+	ctx.startConnStats()
+	ctx.installCallbacks()
+	ctx.checkConnLifetime()
 
 	return nil
 }
 
+// Version -> "" when not connected
 func (ctx *GormDBCtx) Version() string {
+	if ctx.ensureConnected() != nil {
+		return ""
+	}
+
 	versionStruct := new(struct {
 		Version string
 	})
@@ -447,18 +1132,36 @@ func (ctx *GormDBCtx) DBName() string {
 	return ctx.dbName
 }
 
+// a single check, replaced in tests
+var fastDBCheck = (*GormDBCtx).fastDBCheckOnce
+
 func (ctx *GormDBCtx) FastDBCheck(name string) (bool, error) {
+	exists, err := fastDBCheck(ctx, name)
+	for retry := 0; retry < ctx.fastDBCheckRetries && !exists && (err == nil || IsRetryable(err)); retry++ {
+		time.Sleep(ctx.fastDBCheckDelay)
+		exists, err = fastDBCheck(ctx, name)
+	}
+	return exists, err
+}
+
+func (ctx *GormDBCtx) fastDBCheckOnce(name string) (bool, error) {
 	switch ctx.DBMode {
 	case DBModePostgreSQL:
+		if err := ctx.ensureConnected(); err != nil {
+			return false, err
+		}
 		var exists bool
 		err := ctx.R.Raw("SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = ?);", name).Scan(&exists).Error
 		return exists, err
 	case DBModeMySQL:
+		if err := ctx.ensureConnected(); err != nil {
+			return false, err
+		}
 		var count int64
 		err := ctx.R.Raw("SELECT COUNT(*) AS count FROM information_schema.schemata WHERE schema_name = ?;", name).Scan(&count).Error
 		return count > 0, err
 	case DBModeSQLite:
-		if name == ":memory:" || strings.HasPrefix(name, "file::memory:") || strings.Contains(name, "mode=memory") {
+		if isSQLiteMemoryPath(name) {
 			if ctx.AllowMemoryMode {
 				return true, nil
 			} else {
@@ -466,7 +1169,12 @@ func (ctx *GormDBCtx) FastDBCheck(name string) (bool, error) {
 			}
 		}
 
-		db, err := sql.Open("sqlite3", "file:"+url.PathEscape(name)+"?mode=ro")
+		// not created yet, rather than failing to open
+		if _, err := os.Stat(name); errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+
+		db, err := sql.Open(sqliteDriverName, "file:"+url.PathEscape(name)+"?mode=ro")
 
 		if err != nil {
 			return false, err
@@ -479,7 +1187,30 @@ func (ctx *GormDBCtx) FastDBCheck(name string) (bool, error) {
 		return true, nil
 	}
 
-	return false, errors.New("not supported db")
+	return false, ErrNotSupported
+}
+
+func (ctx *GormDBCtx) logConnected(redactedDSN string) {
+	if ctx.logDSN {
+		ctx.slogger().Info(ctx.ServicePrefix, "status", "connected", "dsn", redactedDSN)
+		return
+	}
+	ctx.slogger().Info(ctx.ServicePrefix, "status", "connected")
+}
+
+// redactDSN masks the password of a url style (postgresql://) or mysql style
+// (user:pass@tcp(host)/db) DSN
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" && u.Host != "" {
+		return u.Redacted()
+	}
+	if cfg, err := mysql.ParseDSN(dsn); err == nil {
+		if cfg.Passwd != "" {
+			cfg.Passwd = "xxxxx"
+		}
+		return cfg.FormatDSN()
+	}
+	return dsn
 }
 
 // TODO any good idea?
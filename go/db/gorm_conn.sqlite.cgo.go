@@ -2,8 +2,31 @@
 
 package db
 
-import "gorm.io/driver/sqlite"
+import (
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
 
 var SqliteDriverOpen = sqlite.Open
 
 const CgoEnabled = true
+
+// database/sql driver name
+const sqliteDriverName = "sqlite3"
+
+// dialector on an already opened pool
+func sqliteDialectorWithConn(conn gorm.ConnPool) gorm.Dialector {
+	return &sqlite.Dialector{DriverName: sqliteDriverName, Conn: conn}
+}
+
+// extended result code
+func sqliteErrorCode(err error) (int, bool) {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return int(sqliteErr.ExtendedCode), true
+	}
+	return 0, false
+}
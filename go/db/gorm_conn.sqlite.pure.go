@@ -2,8 +2,31 @@
 
 package db
 
-import "github.com/glebarez/sqlite"
+import (
+	"errors"
 
-var SqliteDriverOpen = sqlite.Open
+	"github.com/glebarez/go-sqlite"
+	gorm_sqlite_driver "github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+var SqliteDriverOpen = gorm_sqlite_driver.Open
 
 const CgoEnabled = false
+
+// database/sql driver name
+const sqliteDriverName = "sqlite"
+
+// dialector on an already opened pool
+func sqliteDialectorWithConn(conn gorm.ConnPool) gorm.Dialector {
+	return &gorm_sqlite_driver.Dialector{DriverName: sqliteDriverName, Conn: conn}
+}
+
+// extended result code
+func sqliteErrorCode(err error) (int, bool) {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code(), true
+	}
+	return 0, false
+}
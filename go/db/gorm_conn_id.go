@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// mysql/postgresql
+//
+// connID is called for every new connection of the pool with the ctx of the
+// query that opens it (request id, trace id...), the result is visible in the
+// session views of the server:
+// mysql -> connection attribute conn_id (performance_schema.session_connect_attrs)
+// postgresql -> application_name "<application_name>:<id>" (pg_stat_activity),
+// the server truncates it to 63 bytes
+//
+// taken into account on Connect
+func (ctx *GormDBCtx) SetConnectionID(connID func(stdCtx context.Context) string) *GormDBCtx {
+	ctx.connID = connID
+
+	return ctx
+}
+
+// builds a connector per connection, carrying the id of connID
+type connIDConnector struct {
+	connID       func(stdCtx context.Context) string
+	newConnector func(id string) (driver.Connector, error)
+	driver       driver.Driver
+}
+
+func (c *connIDConnector) Connect(stdCtx context.Context) (driver.Conn, error) {
+	connector, err := c.newConnector(c.connID(stdCtx))
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(stdCtx)
+}
+
+func (c *connIDConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+func (ctx *GormDBCtx) mysqlConnector(dsn *mysql.Config) (driver.Connector, error) {
+	connector, err := mysql.NewConnector(dsn)
+	if err != nil || ctx.connID == nil {
+		return connector, err
+	}
+
+	return &connIDConnector{
+		connID: ctx.connID,
+		newConnector: func(id string) (driver.Connector, error) {
+			cfg := dsn.Clone()
+			// key1:value1,key2:value2
+			attr := "conn_id:" + strings.NewReplacer(",", "_", ":", "_").Replace(id)
+			if cfg.ConnectionAttributes != "" {
+				attr = cfg.ConnectionAttributes + "," + attr
+			}
+			cfg.ConnectionAttributes = attr
+			return mysql.NewConnector(cfg)
+		},
+		driver: connector.Driver(),
+	}, nil
+}
+
+func (ctx *GormDBCtx) postgreSQLConnector(pgxConfig *pgx.ConnConfig) driver.Connector {
+	connector := stdlib.GetConnector(*pgxConfig)
+	if ctx.connID == nil {
+		return connector
+	}
+
+	return &connIDConnector{
+		connID: ctx.connID,
+		newConnector: func(id string) (driver.Connector, error) {
+			cfg := pgxConfig.Copy()
+			if appName := cfg.RuntimeParams["application_name"]; appName != "" {
+				id = appName + ":" + id
+			}
+			cfg.RuntimeParams["application_name"] = id
+			return stdlib.GetConnector(*cfg), nil
+		},
+		driver: connector.Driver(),
+	}
+}
@@ -0,0 +1,166 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"slices"
+	"strconv"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+)
+
+// mysql/postgresql/sqlite
+//
+// statements run on every new connection of the pool (SET time_zone, SET
+// NAMES, PRAGMA...), not only on the first one; taken into account on
+// Connect, a failing statement fails the connection
+func (ctx *GormDBCtx) SetConnInitSQL(statements []string) *GormDBCtx {
+	ctx.connInitSQL = slices.Clone(statements)
+
+	return ctx
+}
+
+// runs the init statements right after the driver opened a connection
+type initSQLConnector struct {
+	driver.Connector
+	statements func() []string
+}
+
+func withConnInitSQL(connector driver.Connector, statements []string) driver.Connector {
+	if len(statements) == 0 {
+		return connector
+	}
+	return withConnInitSQLFunc(connector, func() []string { return statements })
+}
+
+// statements evaluated for each new connection
+func withConnInitSQLFunc(connector driver.Connector, statements func() []string) driver.Connector {
+	return &initSQLConnector{Connector: connector, statements: statements}
+}
+
+func (c *initSQLConnector) Connect(stdCtx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(stdCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, statement := range c.statements() {
+		if err := execDriverConn(stdCtx, conn, statement); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func execDriverConn(stdCtx context.Context, conn driver.Conn, statement string) error {
+	if execer, ok := conn.(driver.ExecerContext); ok {
+		_, err := execer.ExecContext(stdCtx, statement, nil)
+		return err
+	}
+
+	stmt, err := conn.Prepare(statement)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(nil)
+	return err
+}
+
+// for drivers registered by name only
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c *dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// mysql/postgresql: the read-only session of EnforceReadOnly, then
+// SetConnInitSQL
+func (ctx *GormDBCtx) serverConnInitSQL() []string {
+	if !ctx.readOnly {
+		return ctx.connInitSQL
+	}
+
+	statement := "SET SESSION TRANSACTION READ ONLY"
+	if ctx.DBMode == DBModePostgreSQL {
+		statement = "SET SESSION default_transaction_read_only = on"
+	}
+	return append([]string{statement}, ctx.connInitSQL...)
+}
+
+// connection level defaults, lost when the pool replaces a connection unless
+// set on each one
+var sqliteDefaultPragmas = [][2]string{
+	{"busy_timeout", "5000"},
+	{"synchronous", "NORMAL"},
+	{"cache_size", "100000"},
+	{"foreign_keys", "true"},
+	{"temp_store", "memory"},
+}
+
+// per connection pragmas (not the ones set in the path by the driver
+// options), then SetConnInitSQL
+func (ctx *GormDBCtx) sqliteConnInitSQL(path string) []string {
+	dsnPragmas := sqliteDSNPragmas(path)
+
+	var statements []string
+	if ctx.readOnly {
+		statements = append(statements, "PRAGMA query_only = ON")
+	}
+	for _, pragma := range sqliteDefaultPragmas {
+		if !dsnPragmas.has(pragma[0]) {
+			statements = append(statements, "PRAGMA "+pragma[0]+" = "+pragma[1])
+		}
+	}
+	if ctx.sqliteMmapSize > 0 && !dsnPragmas.has("mmap_size") {
+		statements = append(statements, "PRAGMA mmap_size = "+strconv.FormatInt(ctx.sqliteMmapSize, 10))
+	}
+	if ctx.sqliteSecureDelete != "" && !dsnPragmas.has("secure_delete") {
+		statements = append(statements, "PRAGMA secure_delete = "+ctx.sqliteSecureDelete)
+	}
+	return append(statements, ctx.connInitSQL...)
+}
+
+// busy -> the handler of SetSQLiteBusyHandler (W), busy_timeout = 0 on the
+// connections opened while one is set
+func (ctx *GormDBCtx) openSQLiteDialector(path string, busy *atomic.Pointer[func(attempts int) bool]) (gorm.Dialector, error) {
+	statements := ctx.sqliteConnInitSQL(path)
+	if len(statements) == 0 && busy == nil {
+		return SqliteDriverOpen(path), nil
+	}
+
+	// only to get hold of the registered driver
+	probe, err := sql.Open(sqliteDriverName, path)
+	if err != nil {
+		return nil, err
+	}
+	sqliteDriver := probe.Driver()
+	_ = probe.Close()
+
+	var connector driver.Connector = &dsnConnector{dsn: path, driver: sqliteDriver}
+	if driverCtx, ok := sqliteDriver.(driver.DriverContext); ok {
+		if connector, err = driverCtx.OpenConnector(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if busy == nil {
+		return sqliteDialectorWithConn(sql.OpenDB(withConnInitSQL(connector, statements))), nil
+	}
+	return sqliteDialectorWithConn(sql.OpenDB(withConnInitSQLFunc(connector, func() []string {
+		if busy.Load() == nil {
+			return statements
+		}
+		return append(slices.Clip(statements), "PRAGMA busy_timeout = 0")
+	}))), nil
+}
@@ -1,14 +1,34 @@
 package db_test
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/kdnetwork/code-snippet/go/db"
 	"golang.org/x/mod/semver"
+	"gorm.io/gorm"
 )
 
 // Please fill in your own credentials here
@@ -111,6 +131,132 @@ func TestSQLiteConn(t *testing.T) {
 			t.Errorf("Too low version: Current %s, Target >= %s", vStr, targetV)
 		}
 	})
+
+	t.Run("CloneTest", func(t *testing.T) {
+		tempDir := t.TempDir()
+
+		base := new(db.GormDBCtx).SetDBPath(filepath.Join(tempDir, "clone_base.db"))
+		base.WALMode = true
+		if err := base.Connect(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+		defer base.Close()
+
+		clone := base.Clone().SetDBPath(filepath.Join(tempDir, "clone_copy.db"))
+		if clone.R != nil || clone.W != nil {
+			t.Fatal("clone should not share live handles")
+		}
+		if !clone.WALMode || clone.DBMode != db.DBModeSQLite {
+			t.Errorf("clone lost configuration: WALMode=%v, DBMode=%s", clone.WALMode, clone.DBMode)
+		}
+
+		if err := clone.Connect(); err != nil {
+			t.Fatalf("Conn to cloned db failed: %v", err)
+		}
+		defer clone.Close()
+
+		if clone.W == base.W {
+			t.Error("clone should connect independently")
+		}
+		for _, name := range []string{"clone_base.db", "clone_copy.db"} {
+			if _, err := os.Stat(filepath.Join(tempDir, name)); err != nil {
+				t.Errorf("%s should exist: %v", name, err)
+			}
+		}
+	})
+
+	t.Run("CloseWithTimeoutTest", func(t *testing.T) {
+		tempDir := t.TempDir()
+
+		ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(tempDir, "close_test.db"))
+		if err := ctx.Connect(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+		if err := ctx.CloseWithTimeout(time.Second); err != nil {
+			t.Errorf("CloseWithTimeout failed: %v", err)
+		}
+		if ctx.R != nil || ctx.W != nil {
+			t.Error("handles should be reset after close")
+		}
+
+		// artificially hung driver
+		release := make(chan struct{})
+		restore := db.SetCloseSQLDB(func(sqlDB *sql.DB) error {
+			<-release
+			return sqlDB.Close()
+		})
+		defer restore()
+		defer close(release)
+
+		ctxHung := new(db.GormDBCtx).SetDBPath(filepath.Join(tempDir, "close_hung_test.db"))
+		if err := ctxHung.Connect(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+
+		start := time.Now()
+		if err := ctxHung.CloseWithTimeout(50 * time.Millisecond); err == nil {
+			t.Error("CloseWithTimeout should fail on a hung close")
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("CloseWithTimeout did not return promptly: %v", elapsed)
+		}
+		if ctxHung.R != nil || ctxHung.W != nil {
+			t.Error("handles should be reset even on timeout")
+		}
+	})
+
+	t.Run("PageSizeAndAutoVacuumTest", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "page_size_test.db")).SetSQLitePageSize(8192).SetSQLiteAutoVacuum("incremental")
+		ctx.WALMode = true
+		if err := ctx.Connect(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+		defer ctx.Close()
+
+		if err := ctx.W.Exec("CREATE TABLE page_size_test (id INTEGER PRIMARY KEY);").Error; err != nil {
+			t.Fatalf("Create table failed: %v", err)
+		}
+
+		// R may report its own default until it reads the file
+		var pageSize, autoVacuum int
+		if err := ctx.W.Raw("PRAGMA page_size;").Scan(&pageSize).Error; err != nil {
+			t.Fatalf("PRAGMA page_size failed: %v", err)
+		}
+		if pageSize != 8192 {
+			t.Errorf("Expected page_size 8192, got %d", pageSize)
+		}
+
+		// 2 -> incremental
+		if err := ctx.W.Raw("PRAGMA auto_vacuum;").Scan(&autoVacuum).Error; err != nil {
+			t.Fatalf("PRAGMA auto_vacuum failed: %v", err)
+		}
+		if autoVacuum != 2 {
+			t.Errorf("Expected auto_vacuum 2 (incremental), got %d", autoVacuum)
+		}
+	})
+
+	t.Run("SeparateReadWriteTest", func(t *testing.T) {
+		dbFile := filepath.Join(t.TempDir(), "separate_rw_test.db")
+
+		ctx := new(db.GormDBCtx).SetDBPath(dbFile)
+		if ctx.HasSeparateReadWrite() {
+			t.Error("unconnected ctx should not report separate handles")
+		}
+		if err := ctx.Connect(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+		defer ctx.Close()
+
+		if !ctx.HasSeparateReadWrite() {
+			t.Error("sqlite should use separate R/W handles")
+		}
+
+		// mysql/postgresql share one handle
+		single := &db.GormDBCtx{R: ctx.W, W: ctx.W}
+		if single.HasSeparateReadWrite() {
+			t.Error("single-handle ctx should not report separate handles")
+		}
+	})
 }
 
 func TestMySQLConn(t *testing.T) {
@@ -153,6 +299,30 @@ func TestMySQLConn(t *testing.T) {
 		}
 	})
 
+	t.Run("StatementTimeout", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModeMySQL).SetStatementTimeout(1500 * time.Millisecond)
+
+		dsn, err := db.MySQLConfig(ctx, mysqlUser, mysqlPassword, mysqlHost, "mysql", "")
+		if err != nil {
+			t.Fatalf("Failed to build MySQL config: %v", err)
+		}
+		if v := dsn.Params["max_execution_time"]; v != "1500" {
+			t.Errorf("Expected max_execution_time=1500, got %q", v)
+		}
+	})
+
+	t.Run("InterpolateParams", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModeMySQL).SetMySQLInterpolateParams(true)
+
+		dsn, err := db.MySQLConfig(ctx, mysqlUser, mysqlPassword, mysqlHost, "mysql", "")
+		if err != nil {
+			t.Fatalf("Failed to build MySQL config: %v", err)
+		}
+		if !strings.Contains(dsn.FormatDSN(), "interpolateParams=true") {
+			t.Errorf("interpolateParams missing in DSN: %s", dsn.FormatDSN())
+		}
+	})
+
 	t.Run("TLSWithManualCertPool", func(t *testing.T) {
 		ctx := new(db.GormDBCtx).SetDBMode(db.DBModeMySQL).SetDBAuth(mysqlUser, mysqlPassword, mysqlHost, "mysql", "").SetCertPool(mysqlCertPool)
 
@@ -192,6 +362,28 @@ func TestPostgreSQLConn(t *testing.T) {
 		}
 	})
 
+	t.Run("StatementTimeout", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModePostgreSQL).SetDBAuth(pgUser, pgPassword, pgHost, "postgres", "disable").SetStatementTimeout(100 * time.Millisecond)
+
+		pgxConfig, err := db.PostgreSQLConfig(ctx, pgUser, pgPassword, pgHost, "postgres", "disable")
+		if err != nil {
+			t.Fatalf("Failed to build PostgreSQL config: %v", err)
+		}
+		if v := pgxConfig.RuntimeParams["statement_timeout"]; v != "100" {
+			t.Errorf("Expected statement_timeout=100, got %q", v)
+		}
+
+		// integration: the server aborts the slow query by itself
+		if err := ctx.Connect(); err != nil {
+			t.Skipf("Skipping statement timeout check as server is unavailable: %v", err)
+		}
+		defer ctx.Close()
+
+		if err := ctx.W.Exec("SELECT pg_sleep(2);").Error; err == nil {
+			t.Error("slow query should be aborted by statement_timeout")
+		}
+	})
+
 	t.Run("ConnectToDefault", func(t *testing.T) {
 		ctx := new(db.GormDBCtx).SetDBMode(db.DBModePostgreSQL).SetDBAuth(pgUser, pgPassword, pgHost, "", "disable")
 		if err := ctx.ConnectToDefault(); err != nil {
@@ -199,3 +391,887 @@ func TestPostgreSQLConn(t *testing.T) {
 		}
 	})
 }
+
+func TestDialContext(t *testing.T) {
+	newFakeDialer := func() (func(ctx context.Context, network, addr string) (net.Conn, error), func() []string) {
+		var mu sync.Mutex
+		var dialed []string
+
+		dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+			mu.Lock()
+			dialed = append(dialed, network+"://"+addr)
+			mu.Unlock()
+
+			// the server side hangs up immediately, so the handshake fails
+			client, server := net.Pipe()
+			_ = server.Close()
+			return client, nil
+		}
+
+		return dial, func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return slices.Clone(dialed)
+		}
+	}
+
+	t.Run("MySQL", func(t *testing.T) {
+		dial, dialed := newFakeDialer()
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModeMySQL).SetDBAuth("user", "pass", "db.internal:3306", "mysql", "").SetDialContext(dial)
+
+		if err := ctx.Connect(); err == nil {
+			t.Fatal("connect through fake conn should fail")
+		}
+
+		if got := dialed(); len(got) == 0 || got[0] != "tcp://db.internal:3306" {
+			t.Errorf("dialer not invoked with the expected address: %v", got)
+		}
+	})
+
+	t.Run("PostgreSQL", func(t *testing.T) {
+		dial, dialed := newFakeDialer()
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModePostgreSQL).SetDBAuth("user", "pass", "db.internal:5432", "postgres", "disable").SetDialContext(dial)
+
+		if err := ctx.Connect(); err == nil {
+			t.Fatal("connect through fake conn should fail")
+		}
+
+		if got := dialed(); len(got) == 0 || got[0] != "tcp://db.internal:5432" {
+			t.Errorf("dialer not invoked with the expected address: %v", got)
+		}
+	})
+}
+
+func TestLogDSN(t *testing.T) {
+	t.Run("Redact", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModeMySQL)
+		mysqlDSN, err := db.MySQLConfig(ctx, "user", "s3cr3t-pw", "db.internal:3306", "app", "")
+		if err != nil {
+			t.Fatalf("Failed to build MySQL config: %v", err)
+		}
+		pgxConfig, err := db.PostgreSQLConfig(ctx, "user", "s3cr3t-pw", "db.internal:5432", "app", "disable")
+		if err != nil {
+			t.Fatalf("Failed to build PostgreSQL config: %v", err)
+		}
+
+		for _, dsn := range []string{mysqlDSN.FormatDSN(), pgxConfig.ConnString()} {
+			redacted := db.RedactDSN(dsn)
+			if strings.Contains(redacted, "s3cr3t-pw") {
+				t.Errorf("password leaked: %s", redacted)
+			}
+			if !strings.Contains(redacted, "db.internal") || !strings.Contains(redacted, "user") {
+				t.Errorf("host/user missing: %s", redacted)
+			}
+		}
+	})
+
+	t.Run("ConnectLog", func(t *testing.T) {
+		var buf bytes.Buffer
+		prev := slog.Default()
+		slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+		defer slog.SetDefault(prev)
+
+		dbFile := filepath.Join(t.TempDir(), "log_dsn_test.db")
+		ctx := new(db.GormDBCtx).SetDBPath(dbFile).SetLogDSN(true)
+		if err := ctx.Connect(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+		defer ctx.Close()
+
+		var record struct {
+			Status string `json:"status"`
+			DSN    string `json:"dsn"`
+		}
+		for line := range strings.Lines(buf.String()) {
+			if err := json.Unmarshal([]byte(line), &record); err == nil && record.Status == "connected" {
+				break
+			}
+		}
+		if record.DSN != dbFile {
+			t.Errorf("connected log should carry the dsn, got %q", record.DSN)
+		}
+	})
+}
+
+func TestDBAuthParts(t *testing.T) {
+	t.Run("MySQL", func(t *testing.T) {
+		for _, c := range []struct {
+			name string
+			host string
+			port int
+			want string
+		}{
+			{"ExplicitPort", "db.internal", 13306, "db.internal:13306"},
+			{"DefaultPort", "db.internal", 0, "db.internal:3306"},
+			{"IPv6", "::1", 13306, "[::1]:13306"},
+			{"UnixSocket", "/run/db.sock", 13306, "/run/db.sock"},
+		} {
+			ctx := new(db.GormDBCtx).SetDBMode(db.DBModeMySQL).SetDBAuthParts("user", "pw", c.host, c.port, "app", "")
+			dsn, err := db.AuthMySQLConfig(ctx)
+			if err != nil {
+				t.Fatalf("%s: failed to build MySQL config: %v", c.name, err)
+			}
+			if dsn.Addr != c.want {
+				t.Errorf("%s: expected addr %s, got %s", c.name, c.want, dsn.Addr)
+			}
+		}
+	})
+
+	t.Run("PostgreSQL", func(t *testing.T) {
+		for _, c := range []struct {
+			name string
+			host string
+			port int
+			want uint16
+		}{
+			{"ExplicitPort", "db.internal", 15432, 15432},
+			{"DefaultPort", "db.internal", 0, 5432},
+		} {
+			ctx := new(db.GormDBCtx).SetDBMode(db.DBModePostgreSQL).SetDBAuthParts("user", "pw", c.host, c.port, "app", "disable")
+			pgxConfig, err := db.AuthPostgreSQLConfig(ctx)
+			if err != nil {
+				t.Fatalf("%s: failed to build PostgreSQL config: %v", c.name, err)
+			}
+			if pgxConfig.Host != c.host || pgxConfig.Port != c.want {
+				t.Errorf("%s: expected %s:%d, got %s:%d", c.name, c.host, c.want, pgxConfig.Host, pgxConfig.Port)
+			}
+		}
+	})
+}
+
+func TestFailoverHosts(t *testing.T) {
+	t.Run("DeadHostSkipped", func(t *testing.T) {
+		var mu sync.Mutex
+		var dialed []string
+		dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+			mu.Lock()
+			dialed = append(dialed, addr)
+			mu.Unlock()
+
+			if addr == "dead.internal:3306" {
+				return nil, errors.New("connection refused")
+			}
+			client, server := net.Pipe()
+			_ = server.Close()
+			return client, nil
+		}
+
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModeMySQL).SetDBAuth("user", "pass", "dead.internal:3306", "mysql", "").
+			SetFailoverHosts([]string{"good.internal:3306"}).SetDialContext(dial)
+		if err := ctx.Connect(); err == nil {
+			t.Fatal("connect through fake conn should fail")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if len(dialed) < 2 || dialed[0] != "dead.internal:3306" || dialed[1] != "good.internal:3306" {
+			t.Errorf("Expected the dead host then the failover host, got %v", dialed)
+		}
+	})
+
+	t.Run("MySQL", func(t *testing.T) {
+		direct := new(db.GormDBCtx).SetDBMode(db.DBModeMySQL).SetDBAuth(mysqlUser, mysqlPassword, mysqlHost, "mysql", "").SetCertPool(mysqlCertPool)
+		if err := direct.Connect(); err != nil {
+			t.Skipf("Skipping failover test as server is unavailable: %v", err)
+		}
+		direct.Close()
+
+		timeout := time.Second
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModeMySQL).SetDBAuth(mysqlUser, mysqlPassword, "127.0.0.1:1", "mysql", "").
+			SetFailoverHosts([]string{mysqlHost}).SetDialTimeout(&timeout).SetCertPool(mysqlCertPool)
+		if err := ctx.Connect(); err != nil {
+			t.Fatalf("Expected to connect through the failover host: %v", err)
+		}
+		defer ctx.Close()
+
+		if ctx.Version() == "" {
+			t.Error("Expected a version from the failover host")
+		}
+	})
+}
+
+func TestMaxConnsPerHost(t *testing.T) {
+	var mu sync.Mutex
+	var dialed []string
+	var servers []net.Conn
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		mu.Lock()
+		dialed = append(dialed, addr)
+		servers = append(servers, server)
+		mu.Unlock()
+		return client, nil
+	}
+	defer func() {
+		for _, server := range servers {
+			_ = server.Close()
+		}
+	}()
+
+	t.Run("MySQL", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModeMySQL).SetDBAuth("user", "pass", "a.internal:3306", "mysql", "").
+			SetFailoverHosts([]string{"b.internal:3306", "c.internal:3306"}).SetDialContext(dial).SetMaxConnsPerHost(2)
+		cfg, err := db.AuthMySQLConfig(ctx)
+		if err != nil {
+			t.Fatalf("mysqlConfig failed: %v", err)
+		}
+
+		mu.Lock()
+		dialed = nil
+		mu.Unlock()
+
+		var conns []net.Conn
+		for i := range 6 {
+			conn, err := cfg.DialFunc(context.Background(), "tcp", "a.internal:3306")
+			if err != nil {
+				t.Fatalf("dial %d failed: %v", i, err)
+			}
+			conns = append(conns, conn)
+		}
+
+		mu.Lock()
+		want := []string{"a.internal:3306", "a.internal:3306", "b.internal:3306", "b.internal:3306", "c.internal:3306", "c.internal:3306"}
+		if !slices.Equal(dialed, want) {
+			t.Errorf("Expected 2 connections per host, got %v", dialed)
+		}
+		mu.Unlock()
+
+		if _, err := cfg.DialFunc(context.Background(), "tcp", "a.internal:3306"); !errors.Is(err, db.ErrMaxConnsPerHost) {
+			t.Errorf("every host full should fail with ErrMaxConnsPerHost, got %v", err)
+		}
+
+		// a closed connection frees its slot
+		_ = conns[0].Close()
+		conn, err := cfg.DialFunc(context.Background(), "tcp", "a.internal:3306")
+		if err != nil {
+			t.Fatalf("dial after close failed: %v", err)
+		}
+		conns = append(conns, conn)
+
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	})
+
+	t.Run("PostgreSQL", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModePostgreSQL).SetDBAuth("user", "pass", "a.internal:5432", "postgres", "").
+			SetDialContext(dial).SetMaxConnsPerHost(1)
+		cfg, err := db.AuthPostgreSQLConfig(ctx)
+		if err != nil {
+			t.Fatalf("postgreSQLConfig failed: %v", err)
+		}
+
+		conn, err := cfg.DialFunc(context.Background(), "tcp", "a.internal:5432")
+		if err != nil {
+			t.Fatalf("first dial failed: %v", err)
+		}
+		defer conn.Close()
+
+		if _, err := cfg.DialFunc(context.Background(), "tcp", "a.internal:5432"); !errors.Is(err, db.ErrMaxConnsPerHost) {
+			t.Errorf("full host should fail with ErrMaxConnsPerHost, got %v", err)
+		}
+		other, err := cfg.DialFunc(context.Background(), "tcp", "b.internal:5432")
+		if err != nil {
+			t.Fatalf("other host should accept: %v", err)
+		}
+		_ = other.Close()
+	})
+}
+
+func TestFastDBCheckRetry(t *testing.T) {
+	// results returned by the fake check, in order
+	fake := func(results ...error) (calls *int) {
+		calls = new(int)
+		restore := db.SetFastDBCheck(func(ctx *db.GormDBCtx, name string) (bool, error) {
+			result := results[min(*calls, len(results)-1)]
+			*calls++
+			if result == errFound {
+				return true, nil
+			}
+			return false, result
+		})
+		t.Cleanup(restore)
+		return calls
+	}
+
+	t.Run("NotFoundThenFound", func(t *testing.T) {
+		calls := fake(nil, errFound)
+		ctx := new(db.GormDBCtx).SetFastDBCheckRetry(2, time.Millisecond)
+
+		exists, err := ctx.FastDBCheck("fresh")
+		if !exists || err != nil {
+			t.Errorf("Expected the retried check to find it, got %v (%v)", exists, err)
+		}
+		if *calls != 2 {
+			t.Errorf("Expected 2 checks, got %d", *calls)
+		}
+	})
+
+	t.Run("NotFoundEveryTry", func(t *testing.T) {
+		calls := fake(nil)
+		ctx := new(db.GormDBCtx).SetFastDBCheckRetry(2, time.Millisecond)
+
+		exists, err := ctx.FastDBCheck("missing")
+		if exists || err != nil {
+			t.Errorf("Expected not found without error, got %v (%v)", exists, err)
+		}
+		if *calls != 3 {
+			t.Errorf("Expected 3 checks, got %d", *calls)
+		}
+	})
+
+	t.Run("TransientError", func(t *testing.T) {
+		calls := fake(driver.ErrBadConn, errFound)
+		ctx := new(db.GormDBCtx).SetFastDBCheckRetry(1, time.Millisecond)
+
+		if exists, err := ctx.FastDBCheck("fresh"); !exists || err != nil {
+			t.Errorf("Expected a transient error to be retried, got %v (%v)", exists, err)
+		}
+		if *calls != 2 {
+			t.Errorf("Expected 2 checks, got %d", *calls)
+		}
+	})
+
+	t.Run("PermanentError", func(t *testing.T) {
+		calls := fake(db.ErrNotSupported)
+		ctx := new(db.GormDBCtx).SetFastDBCheckRetry(2, time.Millisecond)
+
+		if _, err := ctx.FastDBCheck("any"); !errors.Is(err, db.ErrNotSupported) {
+			t.Errorf("Expected ErrNotSupported, got %v", err)
+		}
+		if *calls != 1 {
+			t.Errorf("Expected a permanent error not to be retried, got %d checks", *calls)
+		}
+	})
+
+	t.Run("NoRetryByDefault", func(t *testing.T) {
+		calls := fake(nil, errFound)
+
+		if exists, _ := new(db.GormDBCtx).FastDBCheck("fresh"); exists || *calls != 1 {
+			t.Errorf("Expected a single check, got %v after %d checks", exists, *calls)
+		}
+	})
+}
+
+// marks a found database in the results of the fake check
+var errFound = errors.New("found")
+
+func TestConnInitSQL(t *testing.T) {
+	t.Run("EveryConnection", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "conn_init_test.db")).
+			SetConnInitSQL([]string{"PRAGMA cache_size = -1234"})
+		if err := ctx.Connect(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+		defer ctx.Close()
+
+		sqlDB, err := ctx.R.DB()
+		if err != nil {
+			t.Fatalf("DB failed: %v", err)
+		}
+		sqlDB.SetMaxOpenConns(3)
+
+		// hold the connections so that each one is a new one
+		for i := range 3 {
+			conn, err := sqlDB.Conn(context.Background())
+			if err != nil {
+				t.Fatalf("Conn %d failed: %v", i, err)
+			}
+			defer conn.Close()
+
+			var cacheSize int
+			if err := conn.QueryRowContext(context.Background(), "PRAGMA cache_size").Scan(&cacheSize); err != nil {
+				t.Fatalf("Query on conn %d failed: %v", i, err)
+			}
+			if cacheSize != -1234 {
+				t.Errorf("Expected cache_size -1234 on conn %d, got %d", i, cacheSize)
+			}
+		}
+	})
+
+	t.Run("Failing", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "conn_init_fail_test.db")).
+			SetConnInitSQL([]string{"SELECT * FROM missing_table"})
+		if err := ctx.Connect(); err == nil {
+			ctx.Close()
+			t.Fatal("Expected Connect to fail")
+		}
+	})
+}
+
+func TestIsInMemory(t *testing.T) {
+	for _, c := range []struct {
+		path string
+		want bool
+	}{
+		{":memory:", true},
+		{"file::memory:?cache=shared", true},
+		{"file:memdb1?mode=memory&cache=shared", true},
+		{filepath.Join(t.TempDir(), "in_memory_test.db"), false},
+		{"file:" + filepath.Join(t.TempDir(), "in_memory_test.db") + "?cache=shared", false},
+	} {
+		t.Run(c.path, func(t *testing.T) {
+			ctx := new(db.GormDBCtx).SetDBPath(c.path)
+			if got := ctx.IsInMemory(); got != c.want {
+				t.Errorf("IsInMemory() = %v, want %v", got, c.want)
+			}
+		})
+	}
+
+	t.Run("ConnectToDefault", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "in_memory_test.db"))
+		if err := ctx.ConnectToDefault(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+		defer ctx.Close()
+
+		if !ctx.IsInMemory() {
+			t.Error("Expected ConnectToDefault to connect to :memory:")
+		}
+	})
+
+	t.Run("NotSQLite", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModeMySQL)
+		if ctx.IsInMemory() {
+			t.Error("Expected false for mysql")
+		}
+	})
+}
+
+func TestPostgresParams(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBMode(db.DBModePostgreSQL).SetPostgresParams(map[string]string{
+		"application_name": "reporting",
+		"options":          "-c search_path=app",
+		"keepalives_idle":  "30",
+		"tcp_user_timeout": "5000",
+		"not_a_libpq_opt":  "1",
+	})
+
+	pgxConfig, err := db.PostgreSQLConfig(ctx, "user", "pw", "127.0.0.1:5432", "app", "disable")
+	if err != nil {
+		t.Fatalf("PostgreSQLConfig failed: %v", err)
+	}
+
+	dsn := pgxConfig.ConnString()
+	for _, param := range []string{"application_name=reporting", "options=-c+search_path%3Dapp", "keepalives_idle=30", "tcp_user_timeout=5000", "sslmode=disable"} {
+		if !strings.Contains(dsn, param) {
+			t.Errorf("Expected %s in the DSN, got %s", param, dsn)
+		}
+	}
+	if strings.Contains(dsn, "not_a_libpq_opt") {
+		t.Errorf("Expected unknown options to be dropped, got %s", dsn)
+	}
+
+	if got := pgxConfig.RuntimeParams["application_name"]; got != "reporting" {
+		t.Errorf("Expected application_name reporting, got %q", got)
+	}
+	if got := pgxConfig.RuntimeParams["options"]; got != "-c search_path=app" {
+		t.Errorf("Expected options to be sent to the server, got %q", got)
+	}
+	// applied to the socket, the server would reject them
+	for _, key := range []string{"keepalives_idle", "tcp_user_timeout"} {
+		if _, ok := pgxConfig.RuntimeParams[key]; ok {
+			t.Errorf("Expected %s not to be sent as a runtime param", key)
+		}
+	}
+
+	t.Run("Dial", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen failed: %v", err)
+		}
+		defer ln.Close()
+
+		conn, err := pgxConfig.DialFunc(context.Background(), "tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial with socket options failed: %v", err)
+		}
+		conn.Close()
+	})
+
+	t.Run("InvalidValue", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModePostgreSQL).SetPostgresParams(map[string]string{"keepalives_idle": "soon"})
+		if _, err := db.PostgreSQLConfig(ctx, "user", "pw", "127.0.0.1:5432", "app", "disable"); err == nil {
+			t.Error("Expected an error for a non-numeric keepalives_idle")
+		}
+	})
+}
+
+func TestInstanceLabel(t *testing.T) {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	defer slog.SetDefault(prev)
+
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "instance_label_test.db")).
+		SetLogDSN(true).SetInstanceLabel("analytics")
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	if ctx.InstanceLabel() != "analytics" {
+		t.Errorf("Expected InstanceLabel analytics, got %q", ctx.InstanceLabel())
+	}
+
+	records := 0
+	for line := range strings.Lines(buf.String()) {
+		var record struct {
+			DBMode   string `json:"dbmode"`
+			Instance string `json:"instance"`
+		}
+		if err := json.Unmarshal([]byte(line), &record); err != nil || record.DBMode == "" {
+			continue
+		}
+		records++
+		if record.Instance != "analytics" {
+			t.Errorf("Expected instance=analytics on every record, got %s", line)
+		}
+	}
+	if records == 0 {
+		t.Fatal("Expected connect logs")
+	}
+
+	t.Run("Unset", func(t *testing.T) {
+		buf.Reset()
+		ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "instance_label_unset_test.db")).SetLogDSN(true)
+		if err := ctx.Connect(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+		defer ctx.Close()
+
+		if strings.Contains(buf.String(), `"instance"`) {
+			t.Errorf("Expected no instance attribute without a label, got %s", buf.String())
+		}
+	})
+}
+
+func TestSession(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "session_test.db"))
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	type sessionItem struct {
+		ID   int
+		Name string
+	}
+	if err := ctx.W.AutoMigrate(&sessionItem{}); err != nil {
+		t.Fatalf("AutoMigrate failed: %v", err)
+	}
+
+	// without the default transaction, Create runs no BEGIN
+	var began bool
+	if err := ctx.W.Callback().Create().Before("gorm:begin_transaction").Register("test:session_begin", func(tx *gorm.DB) {
+		if !tx.SkipDefaultTransaction {
+			began = true
+		}
+	}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	session := ctx.Session(&gorm.Session{SkipDefaultTransaction: true})
+	if err := session.Create(&sessionItem{Name: "a"}).Error; err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if began {
+		t.Error("Expected the session to skip the default transaction")
+	}
+	if ctx.W.SkipDefaultTransaction {
+		t.Error("Session must not change the shared W")
+	}
+
+	if err := ctx.W.Create(&sessionItem{Name: "b"}).Error; err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if !began {
+		t.Error("Expected W to keep the default transaction")
+	}
+
+	var count int64
+	if err := ctx.ReadSession(&gorm.Session{}).Model(&sessionItem{}).Count(&count).Error; err != nil {
+		t.Fatalf("Count on ReadSession failed: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 rows through ReadSession, got %d", count)
+	}
+}
+
+// integration subtests need the servers configured at the top of the file,
+// they are skipped otherwise
+func TestConnectionID(t *testing.T) {
+	connID := func(stdCtx context.Context) string { return "req-42" }
+
+	// the startup message of the fake server carries the runtime params
+	t.Run("PostgreSQLStartupMessage", func(t *testing.T) {
+		startup := make(chan []byte, 1)
+		dial := func(_ context.Context, network, addr string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go func() {
+				defer server.Close()
+				header := make([]byte, 4)
+				if _, err := io.ReadFull(server, header); err != nil {
+					return
+				}
+				body := make([]byte, binary.BigEndian.Uint32(header)-4)
+				if _, err := io.ReadFull(server, body); err != nil {
+					return
+				}
+				select {
+				case startup <- body:
+				default:
+				}
+			}()
+			return client, nil
+		}
+
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModePostgreSQL).SetDBAuth("user", "pw", "fake.internal:5432", "app", "disable").
+			SetPostgresParams(map[string]string{"application_name": "billing"}).
+			SetDialContext(dial).SetConnectionID(connID)
+		if err := ctx.Connect(); err == nil {
+			ctx.Close()
+			t.Fatal("Expected Connect to the fake server to fail")
+		}
+
+		select {
+		case body := <-startup:
+			if !bytes.Contains(body, []byte("application_name\x00billing:req-42\x00")) {
+				t.Errorf("Expected application_name billing:req-42 in the startup message, got %q", body)
+			}
+		default:
+			t.Fatal("No startup message received")
+		}
+	})
+
+	t.Run("MySQL", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModeMySQL).SetDBAuth(mysqlUser, mysqlPassword, mysqlHost, "mysql", "").
+			SetCertPool(mysqlCertPool).SetConnectionID(connID)
+		if err := ctx.Connect(); err != nil {
+			t.Skipf("Skipping as server is unavailable: %v", err)
+		}
+		defer ctx.Close()
+
+		var value string
+		if err := ctx.R.Raw("SELECT ATTR_VALUE FROM performance_schema.session_connect_attrs WHERE PROCESSLIST_ID = CONNECTION_ID() AND ATTR_NAME = 'conn_id';").Scan(&value).Error; err != nil {
+			t.Fatalf("Query session_connect_attrs failed: %v", err)
+		}
+		if value != "req-42" {
+			t.Errorf("Expected conn_id req-42, got %q", value)
+		}
+	})
+
+	t.Run("PostgreSQL", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModePostgreSQL).SetDBAuth(pgUser, pgPassword, pgHost, "postgres", "disable").
+			SetConnectionID(connID)
+		if err := ctx.Connect(); err != nil {
+			t.Skipf("Skipping as server is unavailable: %v", err)
+		}
+		defer ctx.Close()
+
+		var appName string
+		if err := ctx.R.Raw("SELECT application_name FROM pg_stat_activity WHERE pid = pg_backend_pid();").Scan(&appName).Error; err != nil {
+			t.Fatalf("Query pg_stat_activity failed: %v", err)
+		}
+		if appName != "req-42" {
+			t.Errorf("Expected application_name req-42, got %q", appName)
+		}
+	})
+}
+
+func TestNotConnected(t *testing.T) {
+	bg := context.Background()
+
+	for _, mode := range []string{db.DBModeSQLite, db.DBModeMySQL, db.DBModePostgreSQL} {
+		t.Run(mode, func(t *testing.T) {
+			ctx := new(db.GormDBCtx).SetDBMode(mode)
+
+			if v := ctx.Version(); v != "" {
+				t.Errorf("Expected an empty version, got %q", v)
+			}
+
+			calls := map[string]func() error{
+				"CountRows":         func() error { _, err := ctx.CountRows(bg, "t"); return err },
+				"RawNamed":          func() error { return ctx.RawNamed(bg, "SELECT 1;", nil, nil) },
+				"InsertReturningID": func() error { _, err := ctx.InsertReturningID(bg, "INSERT INTO t DEFAULT VALUES;"); return err },
+				"Explain":           func() error { _, err := ctx.Explain(bg, "SELECT 1;"); return err },
+				"CopyFrom":          func() error { _, err := ctx.CopyFrom(bg, "t", []string{"id"}, [][]any{{1}}); return err },
+				"HealthCheckWrite":  func() error { return ctx.HealthCheckWrite(bg) },
+				"WarmUp":            func() error { _, err := ctx.WarmUp(bg, 1); return err },
+				"WithRawConn":       func() error { return ctx.WithRawConn(bg, func(any) error { return nil }) },
+				"DumpSchema":        func() error { _, err := ctx.DumpSchema(); return err },
+				"MigrationDiff":     func() error { _, err := ctx.MigrationDiff(); return err },
+				"ListIndexes":       func() error { _, err := ctx.ListIndexes("t"); return err },
+				"ReplicaLag":        func() error { _, err := ctx.ReplicaLag(); return err },
+				"WithTransaction":   func() error { return ctx.WithTransaction(bg, func(*gorm.DB) error { return nil }) },
+				"WithRetryableTx":   func() error { return ctx.WithRetryableTx(bg, 3, func(*gorm.DB) error { return nil }) },
+			}
+			switch mode {
+			case db.DBModeSQLite:
+				calls["JournalMode"] = func() error { _, err := ctx.JournalMode(); return err }
+			case db.DBModeMySQL:
+				calls["FastDBCheck"] = func() error { _, err := ctx.FastDBCheck("x"); return err }
+				calls["MaxAllowedPacket"] = func() error { _, err := ctx.MaxAllowedPacket(); return err }
+			case db.DBModePostgreSQL:
+				calls["FastDBCheck"] = func() error { _, err := ctx.FastDBCheck("x"); return err }
+				calls["Listen"] = func() error { _, err := ctx.Listen(bg, "x"); return err }
+				calls["Notify"] = func() error { return ctx.Notify("x", "y") }
+			}
+
+			// the error rides on the returned handle
+			var n int64
+			calls["Session"] = func() error { return ctx.Session(&gorm.Session{}).Raw("SELECT 1;").Scan(&n).Error }
+			calls["ReadSession"] = func() error { return ctx.ReadSession(&gorm.Session{}).Table("t").Count(&n).Error }
+			calls["SessionTransaction"] = func() error {
+				return ctx.Session(&gorm.Session{}).Transaction(func(tx *gorm.DB) error { return tx.Exec("DELETE FROM t;").Error })
+			}
+
+			for name, call := range calls {
+				if err := call(); !errors.Is(err, db.ErrNotConnected) {
+					t.Errorf("%s: expected ErrNotConnected, got %v", name, err)
+				}
+			}
+		})
+	}
+
+	t.Run("AfterClose", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "not_connected_test.db"))
+		if err := ctx.Connect(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+		ctx.Close()
+
+		if _, err := ctx.CountRows(bg, "t"); !errors.Is(err, db.ErrNotConnected) {
+			t.Errorf("Expected ErrNotConnected, got %v", err)
+		}
+		if err := ctx.Session(&gorm.Session{}).Exec("DELETE FROM t;").Error; !errors.Is(err, db.ErrNotConnected) {
+			t.Errorf("Expected ErrNotConnected from Session, got %v", err)
+		}
+	})
+}
+
+// self-signed CA written as PEM, for the tlsOption file
+func writeTestCA(t *testing.T) string {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTLSServerName(t *testing.T) {
+	caFile := writeTestCA(t)
+
+	t.Run("MySQL", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetTLSServerName("db.internal")
+		dsn, err := db.MySQLConfig(ctx, "user", "password", "10.0.0.5:3306", "app", caFile)
+		if err != nil {
+			t.Fatalf("MySQLConfig failed: %v", err)
+		}
+		if dsn.TLS == nil {
+			t.Fatal("Expected a TLS config")
+		}
+		if dsn.TLS.ServerName != "db.internal" {
+			t.Errorf("Expected ServerName db.internal, got %q", dsn.TLS.ServerName)
+		}
+		if dsn.TLS.RootCAs == nil || dsn.TLS.InsecureSkipVerify {
+			t.Error("Expected the CA to still be verified")
+		}
+
+		// without the override the host is checked
+		dsn, err = db.MySQLConfig(new(db.GormDBCtx), "user", "password", "10.0.0.5:3306", "app", caFile)
+		if err != nil {
+			t.Fatalf("MySQLConfig failed: %v", err)
+		}
+		if dsn.TLS == nil || dsn.TLS.ServerName != "10.0.0.5" {
+			t.Errorf("Expected ServerName 10.0.0.5, got %+v", dsn.TLS)
+		}
+	})
+
+	t.Run("PostgreSQL", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetTLSServerName("db.internal")
+		pgxConfig, err := db.PostgreSQLConfig(ctx, "user", "password", "10.0.0.5:5432", "app", caFile)
+		if err != nil {
+			t.Fatalf("PostgreSQLConfig failed: %v", err)
+		}
+		if pgxConfig.TLSConfig == nil {
+			t.Fatal("Expected a TLS config")
+		}
+		if pgxConfig.TLSConfig.ServerName != "db.internal" {
+			t.Errorf("Expected ServerName db.internal, got %q", pgxConfig.TLSConfig.ServerName)
+		}
+		if pgxConfig.TLSConfig.RootCAs == nil || pgxConfig.TLSConfig.InsecureSkipVerify {
+			t.Error("Expected the CA to still be verified")
+		}
+	})
+}
+
+func TestTimeZone(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetTimeZone("Asia/Tokyo")
+
+	t.Run("PostgreSQLDSN", func(t *testing.T) {
+		pgxConfig, err := db.PostgreSQLConfig(ctx, pgUser, pgPassword, "localhost:5432", "postgres", "disable")
+		if err != nil {
+			t.Fatalf("PostgreSQLConfig failed: %v", err)
+		}
+		if got := pgxConfig.RuntimeParams["TimeZone"]; got != "Asia/Tokyo" {
+			t.Errorf("Expected TimeZone Asia/Tokyo, got %q", got)
+		}
+	})
+
+	t.Run("MySQLLoc", func(t *testing.T) {
+		dsn, err := db.MySQLConfig(ctx, mysqlUser, mysqlPassword, "localhost:3306", "mysql", "")
+		if err != nil {
+			t.Fatalf("MySQLConfig failed: %v", err)
+		}
+		if dsn.Loc == nil || dsn.Loc.String() != "Asia/Tokyo" || !dsn.ParseTime {
+			t.Errorf("Expected parseTime with loc Asia/Tokyo, got %v (parseTime %v)", dsn.Loc, dsn.ParseTime)
+		}
+		if _, ok := dsn.Params["loc"]; ok {
+			t.Error("loc must not be sent as a session variable")
+		}
+
+		invalid := new(db.GormDBCtx).SetTimeZone("Mars/Olympus")
+		if _, err := db.MySQLConfig(invalid, mysqlUser, mysqlPassword, "localhost:3306", "mysql", ""); err == nil {
+			t.Error("Expected an error for an unknown zone")
+		}
+	})
+
+	// integration check: needs the postgresql server configured above,
+	// skipped otherwise
+	t.Run("PostgreSQLShowTimezone", func(t *testing.T) {
+		pg := ctx.Clone().SetDBMode(db.DBModePostgreSQL).SetDBAuth(pgUser, pgPassword, pgHost, "postgres", "disable")
+		if err := pg.Connect(); err != nil {
+			t.Skipf("Skipping SHOW timezone as server is unavailable: %v", err)
+		}
+		defer pg.Close()
+
+		var tz string
+		if err := pg.R.Raw("SHOW timezone;").Scan(&tz).Error; err != nil {
+			t.Fatalf("SHOW timezone failed: %v", err)
+		}
+		if tz != "Asia/Tokyo" {
+			t.Errorf("Expected the session timezone Asia/Tokyo, got %q", tz)
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/kdnetwork/code-snippet/go/utils"
+	"gorm.io/gorm"
+)
+
+// placeholders per statement: mysql 65535, sqlite 32766 (3.32+)
+const copyFromMaxParams = 32766
+
+// CopyFrom bulk-loads rows into table on W, every row holds one value per
+// column; returns the number of rows loaded
+//
+// postgresql -> COPY FROM STDIN (pgx CopyFrom)
+// mysql/sqlite -> multi-row INSERTs in a single transaction
+func (ctx *GormDBCtx) CopyFrom(stdCtx context.Context, table string, columns []string, rows [][]any) (int64, error) {
+	if err := ctx.ensureConnected(); err != nil {
+		return 0, err
+	}
+	if len(columns) == 0 {
+		return 0, errors.New("copy from: no columns")
+	}
+	for i, row := range rows {
+		if len(row) != len(columns) {
+			return 0, fmt.Errorf("copy from: row %d has %d values, expected %d", i, len(row), len(columns))
+		}
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	switch ctx.DBMode {
+	case DBModePostgreSQL:
+		var count int64
+		err := ctx.WithRawConn(stdCtx, func(driverConn any) error {
+			conn, ok := driverConn.(*stdlib.Conn)
+			if !ok {
+				return fmt.Errorf("copy from: unexpected driver conn %T", driverConn)
+			}
+
+			var err error
+			count, err = conn.Conn().CopyFrom(stdCtx, pgx.Identifier{table}, columns, pgx.CopyFromRows(rows))
+			return err
+		})
+		return count, err
+	case DBModeMySQL, DBModeSQLite:
+		return ctx.copyFromInsert(stdCtx, table, columns, rows)
+	default:
+		return 0, ErrNotSupported
+	}
+}
+
+func (ctx *GormDBCtx) copyFromInsert(stdCtx context.Context, table string, columns []string, rows [][]any) (int64, error) {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = ctx.QuoteIdentifier(column)
+	}
+	prefix := "INSERT INTO " + ctx.QuoteIdentifier(table) + " (" + strings.Join(quoted, ", ") + ") VALUES "
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+
+	batchSize := max(copyFromMaxParams/len(columns), 1)
+
+	var count int64
+	err := ctx.W.WithContext(stdCtx).Transaction(func(tx *gorm.DB) error {
+		for _, batch := range utils.Chunk(rows, batchSize) {
+			args := make([]any, 0, len(batch)*len(columns))
+			for _, row := range batch {
+				args = append(args, row...)
+			}
+
+			query := prefix + strings.TrimSuffix(strings.Repeat(placeholders+", ", len(batch)), ", ") + ";"
+			result := tx.Exec(query, args...)
+			if result.Error != nil {
+				return result.Error
+			}
+			count += result.RowsAffected
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
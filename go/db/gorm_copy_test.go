@@ -0,0 +1,89 @@
+package db_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/kdnetwork/code-snippet/go/db"
+)
+
+func copyFromRows(n int) [][]any {
+	rows := make([][]any, n)
+	for i := range rows {
+		rows[i] = []any{i + 1, "name"}
+	}
+	return rows
+}
+
+// integration test: needs the postgresql server configured in
+// gorm_conn_test.go, skipped otherwise; loads 100k rows with COPY
+func TestCopyFromPostgreSQL(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBMode(db.DBModePostgreSQL).SetDBAuth(pgUser, pgPassword, pgHost, "postgres", "disable")
+	if err := ctx.Connect(); err != nil {
+		t.Skipf("Skipping COPY as server is unavailable: %v", err)
+	}
+	defer ctx.Close()
+
+	ctx.W.Exec("DROP TABLE IF EXISTS copy_from_test;")
+	if err := ctx.W.Exec("CREATE TABLE copy_from_test (id BIGINT PRIMARY KEY, name TEXT);").Error; err != nil {
+		t.Fatalf("Create table failed: %v", err)
+	}
+	defer ctx.W.Exec("DROP TABLE IF EXISTS copy_from_test;")
+
+	const n = 100_000
+	count, err := ctx.CopyFrom(context.Background(), "copy_from_test", []string{"id", "name"}, copyFromRows(n))
+	if err != nil {
+		t.Fatalf("CopyFrom failed: %v", err)
+	}
+	if count != n {
+		t.Errorf("Expected %d rows loaded, got %d", n, count)
+	}
+
+	var total int64
+	if err := ctx.W.Raw("SELECT COUNT(*) FROM copy_from_test;").Scan(&total).Error; err != nil || total != n {
+		t.Errorf("Expected %d rows in the table, got %d (err %v)", n, total, err)
+	}
+}
+
+func TestCopyFromSQLite(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "copy_from_test.db"))
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	if err := ctx.W.Exec("CREATE TABLE copy_from_test (id INTEGER PRIMARY KEY, name TEXT);").Error; err != nil {
+		t.Fatalf("Create table failed: %v", err)
+	}
+
+	// several batches, the last one partial
+	const n = 40_000
+	count, err := ctx.CopyFrom(context.Background(), "copy_from_test", []string{"id", "name"}, copyFromRows(n))
+	if err != nil {
+		t.Fatalf("CopyFrom failed: %v", err)
+	}
+	if count != n {
+		t.Errorf("Expected %d rows loaded, got %d", n, count)
+	}
+	if total, err := ctx.CountRows(context.Background(), "copy_from_test"); err != nil || total != n {
+		t.Errorf("Expected %d rows in the table, got %d (err %v)", n, total, err)
+	}
+
+	t.Run("RollbackOnError", func(t *testing.T) {
+		// id 1 already exists, the whole load is rolled back
+		rows := [][]any{{n + 1, "new"}, {1, "duplicate"}}
+		if _, err := ctx.CopyFrom(context.Background(), "copy_from_test", []string{"id", "name"}, rows); !db.IsUniqueViolation(err) {
+			t.Errorf("Expected a unique violation, got %v", err)
+		}
+		if total, _ := ctx.CountRows(context.Background(), "copy_from_test"); total != n {
+			t.Errorf("Expected %d rows after the rollback, got %d", n, total)
+		}
+	})
+
+	t.Run("RowLength", func(t *testing.T) {
+		if _, err := ctx.CopyFrom(context.Background(), "copy_from_test", []string{"id", "name"}, [][]any{{1}}); err == nil {
+			t.Error("Expected an error for a short row")
+		}
+	})
+}
@@ -0,0 +1,299 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// mysql -> https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html
+const (
+	mysqlErrTooManyConnections = 1040
+	mysqlErrDuplicateIndex     = 1061
+	mysqlErrNotNull            = 1048
+	mysqlErrDuplicateEntry     = 1062
+	mysqlErrSyntax             = 1064
+	mysqlErrLockWaitTimeout    = 1205
+	mysqlErrDeadlock           = 1213
+	mysqlErrRowIsReferenced    = 1451
+	mysqlErrNoReferencedRow    = 1452
+	mysqlErrDuplicateKeyName   = 1586
+	mysqlErrServerGone         = 2006
+	mysqlErrServerLost         = 2013
+	mysqlErrCheckViolation     = 3819
+)
+
+// postgresql -> https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgErrTooManyConnections   = "53300"
+	pgErrNotNullViolation     = "23502"
+	pgErrForeignKeyViolation  = "23503"
+	pgErrUniqueViolation      = "23505"
+	pgErrCheckViolation       = "23514"
+	pgErrSerializationFailure = "40001"
+	pgErrDeadlockDetected     = "40P01"
+	pgErrSyntax               = "42601"
+	pgErrLockNotAvailable     = "55P03"
+	pgErrAdminShutdown        = "57P01"
+	pgErrCrashShutdown        = "57P02"
+	pgErrCannotConnectNow     = "57P03"
+)
+
+// sqlite -> https://www.sqlite.org/rescode.html (primary codes)
+const (
+	sqliteErrBusy   = 5
+	sqliteErrLocked = 6
+
+	// extended codes
+	sqliteErrConstraintCheck      = 275
+	sqliteErrConstraintForeignKey = 787
+	sqliteErrConstraintNotNull    = 1299
+	sqliteErrConstraintPrimaryKey = 1555
+	sqliteErrConstraintUnique     = 2067
+)
+
+var ErrTooManyConnections = errors.New("too many connections: lower MaxOpenConns (SetMaxOpenConns) of every instance or raise the server limit (max_connections)")
+
+// Connect errors caused by the server connection limit (mysql 1040,
+// postgresql 53300) are wrapped with ErrTooManyConnections
+func wrapConnectError(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrTooManyConnections {
+		return fmt.Errorf("%w: %w", ErrTooManyConnections, err)
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgErrTooManyConnections {
+		return fmt.Errorf("%w: %w", ErrTooManyConnections, err)
+	}
+
+	return err
+}
+
+// IsRetryable reports whether err is transient (deadlock, lock wait,
+// serialization failure, lost connection...) and the operation is worth
+// retrying, permanent errors (auth, syntax, constraint...) return false;
+// so does a cancelled or expired context, retrying can't outlive the caller
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case mysqlErrLockWaitTimeout, mysqlErrDeadlock, mysqlErrServerGone, mysqlErrServerLost:
+			return true
+		}
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgErrSerializationFailure, pgErrDeadlockDetected, pgErrAdminShutdown, pgErrCrashShutdown, pgErrCannotConnectNow:
+			return true
+		}
+		// class 08 -> connection exception
+		return strings.HasPrefix(pgErr.Code, "08")
+	}
+
+	if code, ok := sqliteErrorCode(err); ok {
+		switch code & 0xff {
+		case sqliteErrBusy, sqliteErrLocked:
+			return true
+		}
+		return false
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, io.ErrUnexpectedEOF) || pgconn.SafeToRetry(err) {
+		return true
+	}
+
+	// dns errors (no such host...) won't go away by themselves
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET)
+}
+
+type ErrorKind string
+
+const (
+	ErrorKindUnknown             ErrorKind = "unknown"
+	ErrorKindUniqueViolation     ErrorKind = "unique_violation"
+	ErrorKindForeignKeyViolation ErrorKind = "foreign_key_violation"
+	ErrorKindNotNullViolation    ErrorKind = "not_null_violation"
+	ErrorKindCheckViolation      ErrorKind = "check_violation"
+	ErrorKindDeadlock            ErrorKind = "deadlock"
+	ErrorKindLockTimeout         ErrorKind = "lock_timeout"
+	ErrorKindSerialization       ErrorKind = "serialization_failure"
+	ErrorKindTooManyConnections  ErrorKind = "too_many_connections"
+	ErrorKindConnection          ErrorKind = "connection"
+	ErrorKindSyntax              ErrorKind = "syntax"
+)
+
+// DBError is the common shape of a driver error, see WrapError
+type DBError struct {
+	// DBModeMySQL/DBModePostgreSQL/DBModeSQLite, "" -> not a driver error
+	Dialect string
+	// mysql error number, postgresql SQLSTATE, sqlite extended result code
+	Code    string
+	Kind    ErrorKind
+	Message string
+
+	err error
+}
+
+func (e *DBError) Error() string {
+	if e.Dialect == "" {
+		return e.Message
+	}
+	return e.Dialect + " error " + e.Code + " (" + string(e.Kind) + "): " + e.Message
+}
+
+func (e *DBError) Unwrap() error {
+	return e.err
+}
+
+// WrapError classifies err (*mysql.MySQLError, *pgconn.PgError, sqlite
+// errors, anywhere in the chain), other errors get ErrorKindUnknown,
+// nil -> nil
+func WrapError(err error) *DBError {
+	if err == nil {
+		return nil
+	}
+
+	var dbErr *DBError
+	if errors.As(err, &dbErr) {
+		return dbErr
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return &DBError{
+			Dialect: DBModeMySQL,
+			Code:    strconv.Itoa(int(mysqlErr.Number)),
+			Kind:    mysqlErrorKind(mysqlErr.Number),
+			Message: mysqlErr.Message,
+			err:     err,
+		}
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return &DBError{
+			Dialect: DBModePostgreSQL,
+			Code:    pgErr.Code,
+			Kind:    pgErrorKind(pgErr.Code),
+			Message: pgErr.Message,
+			err:     err,
+		}
+	}
+
+	if code, ok := sqliteErrorCode(err); ok {
+		return &DBError{
+			Dialect: DBModeSQLite,
+			Code:    strconv.Itoa(code),
+			Kind:    sqliteErrorKind(code),
+			Message: err.Error(),
+			err:     err,
+		}
+	}
+
+	return &DBError{Kind: ErrorKindUnknown, Message: err.Error(), err: err}
+}
+
+func mysqlErrorKind(number uint16) ErrorKind {
+	switch number {
+	case mysqlErrDuplicateEntry, mysqlErrDuplicateKeyName:
+		return ErrorKindUniqueViolation
+	case mysqlErrRowIsReferenced, mysqlErrNoReferencedRow:
+		return ErrorKindForeignKeyViolation
+	case mysqlErrNotNull:
+		return ErrorKindNotNullViolation
+	case mysqlErrCheckViolation:
+		return ErrorKindCheckViolation
+	case mysqlErrDeadlock:
+		return ErrorKindDeadlock
+	case mysqlErrLockWaitTimeout:
+		return ErrorKindLockTimeout
+	case mysqlErrTooManyConnections:
+		return ErrorKindTooManyConnections
+	case mysqlErrServerGone, mysqlErrServerLost:
+		return ErrorKindConnection
+	case mysqlErrSyntax:
+		return ErrorKindSyntax
+	}
+	return ErrorKindUnknown
+}
+
+func pgErrorKind(code string) ErrorKind {
+	switch code {
+	case pgErrUniqueViolation:
+		return ErrorKindUniqueViolation
+	case pgErrForeignKeyViolation:
+		return ErrorKindForeignKeyViolation
+	case pgErrNotNullViolation:
+		return ErrorKindNotNullViolation
+	case pgErrCheckViolation:
+		return ErrorKindCheckViolation
+	case pgErrDeadlockDetected:
+		return ErrorKindDeadlock
+	case pgErrLockNotAvailable:
+		return ErrorKindLockTimeout
+	case pgErrSerializationFailure:
+		return ErrorKindSerialization
+	case pgErrTooManyConnections:
+		return ErrorKindTooManyConnections
+	case pgErrAdminShutdown, pgErrCrashShutdown, pgErrCannotConnectNow:
+		return ErrorKindConnection
+	case pgErrSyntax:
+		return ErrorKindSyntax
+	}
+	// class 08 -> connection exception
+	if strings.HasPrefix(code, "08") {
+		return ErrorKindConnection
+	}
+	return ErrorKindUnknown
+}
+
+func sqliteErrorKind(code int) ErrorKind {
+	switch code {
+	case sqliteErrConstraintUnique, sqliteErrConstraintPrimaryKey:
+		return ErrorKindUniqueViolation
+	case sqliteErrConstraintForeignKey:
+		return ErrorKindForeignKeyViolation
+	case sqliteErrConstraintNotNull:
+		return ErrorKindNotNullViolation
+	case sqliteErrConstraintCheck:
+		return ErrorKindCheckViolation
+	}
+	switch code & 0xff {
+	case sqliteErrBusy, sqliteErrLocked:
+		return ErrorKindLockTimeout
+	}
+	return ErrorKindUnknown
+}
+
+// IsUniqueViolation -> mysql 1062/1586, postgresql 23505, sqlite UNIQUE or
+// PRIMARY KEY constraint
+func IsUniqueViolation(err error) bool {
+	return err != nil && WrapError(err).Kind == ErrorKindUniqueViolation
+}
+
+// IsForeignKeyViolation -> mysql 1451/1452, postgresql 23503, sqlite FOREIGN
+// KEY constraint
+func IsForeignKeyViolation(err error) bool {
+	return err != nil && WrapError(err).Kind == ErrorKindForeignKeyViolation
+}
@@ -0,0 +1,275 @@
+package db_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/kdnetwork/code-snippet/go/db"
+	"gorm.io/gorm"
+)
+
+// newSQLiteBusyError holds the write lock on one handle and writes from a
+// second one without busy_timeout
+func newSQLiteBusyError(t *testing.T) error {
+	t.Helper()
+
+	dbFile := filepath.Join(t.TempDir(), "busy_test.db")
+	ctx := new(db.GormDBCtx).SetDBPath(dbFile)
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	if err := ctx.W.Exec("CREATE TABLE busy (id INTEGER PRIMARY KEY);").Error; err != nil {
+		t.Fatalf("Create table failed: %v", err)
+	}
+
+	other, err := gorm.Open(db.SqliteDriverOpen(dbFile), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Open second handle failed: %v", err)
+	}
+	otherDB, _ := other.DB()
+	defer otherDB.Close()
+	otherDB.SetMaxOpenConns(1)
+	if err := other.Exec("PRAGMA busy_timeout = 0;").Error; err != nil {
+		t.Fatalf("Set busy_timeout failed: %v", err)
+	}
+
+	var busyErr error
+	_ = ctx.W.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("INSERT INTO busy (id) VALUES (1);").Error; err != nil {
+			return err
+		}
+		busyErr = other.Exec("INSERT INTO busy (id) VALUES (2);").Error
+		return nil
+	})
+
+	if busyErr == nil {
+		t.Fatal("concurrent write should fail with SQLITE_BUSY")
+	}
+	return busyErr
+}
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"Nil", nil, false},
+		{"PlainError", errors.New("boom"), false},
+		{"BadConn", driver.ErrBadConn, true},
+
+		{"MySQLDeadlock", &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}, true},
+		{"MySQLLockWaitTimeout", &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}, true},
+		{"MySQLServerGone", &mysql.MySQLError{Number: 2006, Message: "MySQL server has gone away"}, true},
+		{"MySQLServerLost", &mysql.MySQLError{Number: 2013, Message: "Lost connection to MySQL server during query"}, true},
+		{"MySQLInvalidConn", mysql.ErrInvalidConn, true},
+		{"MySQLAccessDenied", &mysql.MySQLError{Number: 1045, Message: "Access denied"}, false},
+		{"MySQLSyntax", &mysql.MySQLError{Number: 1064, Message: "You have an error in your SQL syntax"}, false},
+
+		{"PostgreSQLSerialization", &pgconn.PgError{Code: "40001"}, true},
+		{"PostgreSQLDeadlock", &pgconn.PgError{Code: "40P01"}, true},
+		{"PostgreSQLConnectionFailure", &pgconn.PgError{Code: "08006"}, true},
+		{"PostgreSQLAdminShutdown", &pgconn.PgError{Code: "57P01"}, true},
+		{"PostgreSQLAuth", &pgconn.PgError{Code: "28P01"}, false},
+		{"PostgreSQLSyntax", &pgconn.PgError{Code: "42601"}, false},
+
+		{"Wrapped", fmt.Errorf("query failed: %w", &pgconn.PgError{Code: "40001"}), true},
+
+		{"ConnRefused", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, true},
+		{"ConnReset", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{"NetTimeout", &net.DNSError{Err: "i/o timeout", Name: "db.internal", IsTimeout: true}, true},
+		{"NoSuchHost", &net.DNSError{Err: "no such host", Name: "db.internal", IsNotFound: true}, false},
+		{"ContextCanceled", fmt.Errorf("query failed: %w", context.Canceled), false},
+		{"ContextDeadline", &net.OpError{Op: "dial", Net: "tcp", Err: context.DeadlineExceeded}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := db.IsRetryable(c.err); got != c.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", c.err, got, c.want)
+			}
+		})
+	}
+
+	t.Run("SQLiteBusy", func(t *testing.T) {
+		if err := newSQLiteBusyError(t); !db.IsRetryable(err) {
+			t.Errorf("SQLITE_BUSY should be retryable: %v", err)
+		}
+	})
+}
+
+func TestWrapConnectError(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"MySQLTooManyConnections", &mysql.MySQLError{Number: 1040, Message: "Too many connections"}, true},
+		{"PostgreSQLTooManyConnections", &pgconn.PgError{Code: "53300", Message: "sorry, too many clients already"}, true},
+		{"Wrapped", fmt.Errorf("connect: %w", &mysql.MySQLError{Number: 1040}), true},
+		{"MySQLAccessDenied", &mysql.MySQLError{Number: 1045}, false},
+		{"PostgreSQLAuthFailed", &pgconn.PgError{Code: "28P01"}, false},
+		{"Other", errors.New("dial tcp: connection refused"), false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			err := db.WrapConnectError(c.err)
+			if got := errors.Is(err, db.ErrTooManyConnections); got != c.want {
+				t.Errorf("errors.Is(ErrTooManyConnections) = %v, want %v (%v)", got, c.want, err)
+			}
+			if !errors.Is(err, c.err) {
+				t.Errorf("the original error should stay in the chain: %v", err)
+			}
+		})
+	}
+}
+
+// runs stmts on a fresh sqlite db with foreign keys on, returns the error of
+// the last one
+func newSQLiteConstraintError(t *testing.T, stmts ...string) error {
+	t.Helper()
+
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "constraint_test.db"))
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	for _, stmt := range stmts[:len(stmts)-1] {
+		if err := ctx.W.Exec(stmt).Error; err != nil {
+			t.Fatalf("%s failed: %v", stmt, err)
+		}
+	}
+	return ctx.W.Exec(stmts[len(stmts)-1]).Error
+}
+
+func TestWrapError(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		err     error
+		dialect string
+		code    string
+		kind    db.ErrorKind
+	}{
+		{"MySQLDuplicateEntry", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry '1' for key 'PRIMARY'"}, db.DBModeMySQL, "1062", db.ErrorKindUniqueViolation},
+		{"MySQLDeadlock", &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}, db.DBModeMySQL, "1213", db.ErrorKindDeadlock},
+		{"MySQLForeignKey", &mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row"}, db.DBModeMySQL, "1452", db.ErrorKindForeignKeyViolation},
+		{"MySQLOther", &mysql.MySQLError{Number: 1045, Message: "Access denied"}, db.DBModeMySQL, "1045", db.ErrorKindUnknown},
+
+		{"PostgreSQLUniqueViolation", &pgconn.PgError{Code: "23505", Message: "duplicate key value violates unique constraint"}, db.DBModePostgreSQL, "23505", db.ErrorKindUniqueViolation},
+		{"PostgreSQLDeadlock", &pgconn.PgError{Code: "40P01", Message: "deadlock detected"}, db.DBModePostgreSQL, "40P01", db.ErrorKindDeadlock},
+		{"PostgreSQLConnection", &pgconn.PgError{Code: "08006"}, db.DBModePostgreSQL, "08006", db.ErrorKindConnection},
+
+		{"Wrapped", fmt.Errorf("insert: %w", &pgconn.PgError{Code: "23505"}), db.DBModePostgreSQL, "23505", db.ErrorKindUniqueViolation},
+		{"Plain", errors.New("boom"), "", "", db.ErrorKindUnknown},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			dbErr := db.WrapError(c.err)
+			if dbErr.Dialect != c.dialect || dbErr.Code != c.code || dbErr.Kind != c.kind {
+				t.Errorf("Expected %s/%s/%s, got %s/%s/%s", c.dialect, c.code, c.kind, dbErr.Dialect, dbErr.Code, dbErr.Kind)
+			}
+			if !errors.Is(dbErr, c.err) {
+				t.Errorf("the original error should stay in the chain: %v", dbErr)
+			}
+		})
+	}
+
+	t.Run("Nil", func(t *testing.T) {
+		if dbErr := db.WrapError(nil); dbErr != nil {
+			t.Errorf("Expected nil, got %v", dbErr)
+		}
+	})
+
+	t.Run("SQLiteUnique", func(t *testing.T) {
+		err := newSQLiteConstraintError(t,
+			"CREATE TABLE users (email TEXT UNIQUE);",
+			"INSERT INTO users (email) VALUES ('a@example.com');",
+			"INSERT INTO users (email) VALUES ('a@example.com');",
+		)
+		if dbErr := db.WrapError(err); dbErr.Dialect != db.DBModeSQLite || dbErr.Kind != db.ErrorKindUniqueViolation {
+			t.Errorf("Expected a sqlite unique violation, got %s/%s/%s: %v", dbErr.Dialect, dbErr.Code, dbErr.Kind, err)
+		}
+	})
+
+	t.Run("SQLiteBusy", func(t *testing.T) {
+		if dbErr := db.WrapError(newSQLiteBusyError(t)); dbErr.Kind != db.ErrorKindLockTimeout {
+			t.Errorf("Expected %s, got %s", db.ErrorKindLockTimeout, dbErr.Kind)
+		}
+	})
+}
+
+func TestConstraintViolation(t *testing.T) {
+	for _, c := range []struct {
+		name       string
+		err        error
+		unique     bool
+		foreignKey bool
+	}{
+		{"Nil", nil, false, false},
+		{"Plain", errors.New("duplicate key"), false, false},
+
+		{"MySQLDuplicateEntry", &mysql.MySQLError{Number: 1062}, true, false},
+		{"MySQLDuplicateKeyName", &mysql.MySQLError{Number: 1586}, true, false},
+		{"MySQLRowIsReferenced", &mysql.MySQLError{Number: 1451}, false, true},
+		{"MySQLNoReferencedRow", &mysql.MySQLError{Number: 1452}, false, true},
+		{"MySQLDeadlock", &mysql.MySQLError{Number: 1213}, false, false},
+
+		{"PostgreSQLUniqueViolation", &pgconn.PgError{Code: "23505"}, true, false},
+		{"PostgreSQLForeignKeyViolation", &pgconn.PgError{Code: "23503"}, false, true},
+		{"PostgreSQLNotNullViolation", &pgconn.PgError{Code: "23502"}, false, false},
+
+		{"Wrapped", fmt.Errorf("insert: %w", &mysql.MySQLError{Number: 1062}), true, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := db.IsUniqueViolation(c.err); got != c.unique {
+				t.Errorf("IsUniqueViolation(%v) = %v, want %v", c.err, got, c.unique)
+			}
+			if got := db.IsForeignKeyViolation(c.err); got != c.foreignKey {
+				t.Errorf("IsForeignKeyViolation(%v) = %v, want %v", c.err, got, c.foreignKey)
+			}
+		})
+	}
+
+	t.Run("SQLiteUnique", func(t *testing.T) {
+		err := newSQLiteConstraintError(t,
+			"CREATE TABLE users (email TEXT UNIQUE);",
+			"INSERT INTO users (email) VALUES ('a@example.com');",
+			"INSERT INTO users (email) VALUES ('a@example.com');",
+		)
+		if !db.IsUniqueViolation(err) || db.IsForeignKeyViolation(err) {
+			t.Errorf("Expected a unique violation only: %v", err)
+		}
+	})
+
+	t.Run("SQLitePrimaryKey", func(t *testing.T) {
+		err := newSQLiteConstraintError(t,
+			"CREATE TABLE users (id INTEGER PRIMARY KEY);",
+			"INSERT INTO users (id) VALUES (1);",
+			"INSERT INTO users (id) VALUES (1);",
+		)
+		if !db.IsUniqueViolation(err) {
+			t.Errorf("Expected a unique violation: %v", err)
+		}
+	})
+
+	t.Run("SQLiteForeignKey", func(t *testing.T) {
+		err := newSQLiteConstraintError(t,
+			"CREATE TABLE users (id INTEGER PRIMARY KEY);",
+			"CREATE TABLE posts (user_id INTEGER REFERENCES users (id));",
+			"INSERT INTO posts (user_id) VALUES (42);",
+		)
+		if !db.IsForeignKeyViolation(err) || db.IsUniqueViolation(err) {
+			t.Errorf("Expected a foreign key violation only: %v", err)
+		}
+	})
+}
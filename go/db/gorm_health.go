@@ -0,0 +1,222 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrReadOnly = errors.New("database is read-only")
+
+var ErrPoolInUse = errors.New("pool has connections in use")
+
+var errHealthCheckRollback = errors.New("health check rollback")
+
+// HealthCheckWrite proves W accepts writes, Ping passes on replicas and
+// read-only files
+//
+// sqlite/postgresql -> CREATE TABLE in a transaction that is rolled back
+// mysql -> DDL commits implicitly, so read_only/innodb_read_only are checked
+// instead
+func (ctx *GormDBCtx) HealthCheckWrite(stdCtx context.Context) error {
+	if err := ctx.ensureConnected(); err != nil {
+		return err
+	}
+
+	switch ctx.DBMode {
+	case DBModeMySQL:
+		var readOnly bool
+		if err := ctx.W.WithContext(stdCtx).Raw("SELECT @@GLOBAL.read_only OR @@GLOBAL.innodb_read_only;").Scan(&readOnly).Error; err != nil {
+			return err
+		}
+		if readOnly {
+			return ErrReadOnly
+		}
+		return nil
+	case DBModeSQLite, DBModePostgreSQL:
+		// a TEMP table is still writable on a read-only sqlite file
+		createSQL := "CREATE TABLE __health_check_write (id INTEGER);"
+		if ctx.DBMode == DBModePostgreSQL {
+			createSQL = "CREATE TEMP TABLE __health_check_write (id INTEGER);"
+		}
+
+		err := ctx.W.WithContext(stdCtx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(createSQL).Error; err != nil {
+				return err
+			}
+			return errHealthCheckRollback
+		})
+		if errors.Is(err, errHealthCheckRollback) {
+			return nil
+		}
+		return err
+	}
+
+	return ErrNotSupported
+}
+
+// EnforceReadOnly makes every connection of the pool read-only at the
+// session level, writes are rejected by the server/driver whatever the code
+// does; later Connects stay read-only
+//
+// new R/W pools are opened with the arguments of the last Connect* call, then
+// take the place of the current ones inside R/W (which stay the same
+// *gorm.DB) and the old pools are closed: handles derived from R/W before
+// (Session, WithContext...) fail with "sql: database is closed", a failing
+// open leaves everything as it was
+//
+// mysql -> SET SESSION TRANSACTION READ ONLY
+// postgresql -> default_transaction_read_only = on, temp tables stay
+// writable
+// sqlite -> PRAGMA query_only = ON on R and W, the layout pragmas
+// (SetSQLitePageSize, SetSQLiteAutoVacuum) are skipped; not for in-memory
+// databases, a new pool would be empty
+//
+// a transaction explicitly started READ WRITE (mysql/postgresql) still writes
+//
+// R/W are replaced without a lock: call it before serving traffic, it
+// returns ErrPoolInUse while R or W has connections in use
+func (ctx *GormDBCtx) EnforceReadOnly() error {
+	if err := ctx.ensureConnected(); err != nil {
+		return err
+	}
+	if ctx.IsInMemory() {
+		return ErrNotSupported
+	}
+	for _, handle := range []*gorm.DB{ctx.R, ctx.W} {
+		if sqlDB, err := handle.DB(); err == nil && sqlDB.Stats().InUse > 0 {
+			return ErrPoolInUse
+		}
+	}
+
+	next := ctx.Clone()
+	next.readOnly = true
+
+	var err error
+	args := ctx.serverConnArgs
+	switch ctx.DBMode {
+	case DBModeSQLite:
+		err = next.ConnectToSQLite(ctx.sqlitePath)
+	case DBModeMySQL:
+		err = next.ConnectToMySQL(args.username, args.password, args.host, args.dbName, args.tlsOption)
+	case DBModePostgreSQL:
+		err = next.ConnectToPostgreSQL(args.username, args.password, args.host, args.dbName, args.tlsOption)
+	default:
+		return ErrNotSupported
+	}
+	if err != nil {
+		return err
+	}
+	// ctx runs its own
+	next.stopConnReapers()
+	next.stopConnStats()
+
+	ctx.stopConnReapers()
+	ctx.stopConnStats()
+
+	oldR, _ := ctx.R.DB()
+	oldW, _ := ctx.W.DB()
+	swapConnPool(ctx.R, next.R)
+	if ctx.W != ctx.R {
+		swapConnPool(ctx.W, next.W)
+	}
+
+	ctx.readOnly = true
+	ctx.pgxConfig = next.pgxConfig
+	ctx.sqliteBusy = next.sqliteBusy
+	ctx.startConnReapers()
+	ctx.startConnStats()
+
+	for _, sqlDB := range []*sql.DB{oldR, oldW} {
+		if sqlDB == nil || (sqlDB == oldW && oldW == oldR) {
+			continue
+		}
+		if err := closeSQLDB(sqlDB); err != nil {
+			ctx.slogger().Warn(ctx.ServicePrefix, "method", "enforce_read_only", "err", err)
+		}
+	}
+	return nil
+}
+
+// the callbacks and settings of dst stay, the pool (with its wrappers) and
+// the dialector holding it come from src
+func swapConnPool(dst, src *gorm.DB) {
+	dst.Dialector = src.Dialector
+	dst.ConnPool = src.ConnPool
+	dst.Statement.ConnPool = src.Statement.ConnPool
+}
+
+// PingLatency returns the round-trip time of a SELECT 1 on R, the connection
+// is taken from the pool before the clock starts so pool waits and dials
+// aren't counted
+func (ctx *GormDBCtx) PingLatency(stdCtx context.Context) (time.Duration, error) {
+	if err := ctx.ensureConnected(); err != nil {
+		return 0, err
+	}
+
+	sqlDB, err := ctx.R.DB()
+	if err != nil {
+		return 0, err
+	}
+	conn, err := sqlDB.Conn(stdCtx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var one int
+	start := time.Now()
+	if err := conn.QueryRowContext(stdCtx, "SELECT 1;").Scan(&one); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// WaitForDatabase polls FastDBCheck every interval until name exists; a
+// failing check is returned as is, running out of stdCtx returns an error
+// wrapping stdCtx.Err()
+func (ctx *GormDBCtx) WaitForDatabase(stdCtx context.Context, name string, interval time.Duration) error {
+	ticker := time.NewTicker(max(interval, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		exists, err := ctx.FastDBCheck(name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+
+		select {
+		case <-stdCtx.Done():
+			return fmt.Errorf("database %s not found: %w", name, stdCtx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// postgresql
+//
+// PreparedStatementCount returns the rows of pg_prepared_statements, which
+// only lists the statements of the session the query runs on (one connection
+// of W), PREPARE and the statement cache of pgx both count; a number that
+// keeps growing on a long-lived connection points to a leak
+func (ctx *GormDBCtx) PreparedStatementCount() (int, error) {
+	if err := ctx.ensureConnected(); err != nil {
+		return 0, err
+	}
+	if ctx.DBMode != DBModePostgreSQL {
+		return 0, ErrNotSupported
+	}
+
+	var count int
+	if err := ctx.W.Raw("SELECT COUNT(*) FROM pg_prepared_statements;").Scan(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,342 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kdnetwork/code-snippet/go/db"
+	"gorm.io/gorm"
+)
+
+func TestHealthCheckWrite(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "health_check_test.db")
+
+	ctx := new(db.GormDBCtx).SetDBPath(dbFile)
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	if err := ctx.HealthCheckWrite(context.Background()); err != nil {
+		t.Errorf("HealthCheckWrite on a writable db failed: %v", err)
+	}
+	var count int
+	if err := ctx.R.Raw("SELECT COUNT(*) FROM sqlite_master WHERE name = '__health_check_write';").Scan(&count).Error; err != nil || count != 0 {
+		t.Errorf("health check table should be rolled back, count %d, err %v", count, err)
+	}
+	ctx.Close()
+
+	t.Run("ReadOnly", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBPath("file:" + dbFile + "?mode=ro")
+		if err := ctx.Connect(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+		defer ctx.Close()
+
+		sqlDB, _ := ctx.W.DB()
+		if err := sqlDB.Ping(); err != nil {
+			t.Fatalf("Ping on a read-only db should succeed: %v", err)
+		}
+		if err := ctx.HealthCheckWrite(context.Background()); err == nil {
+			t.Error("HealthCheckWrite on a read-only db should fail")
+		}
+	})
+}
+
+func TestWaitForDatabase(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "wait_for_database_test.db")
+	ctx := new(db.GormDBCtx).SetDBMode(db.DBModeSQLite)
+
+	t.Run("NotFound", func(t *testing.T) {
+		waitCtx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		err := ctx.WaitForDatabase(waitCtx, dbFile, 10*time.Millisecond)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected DeadlineExceeded, got %v", err)
+		}
+	})
+
+	t.Run("CreatedLater", func(t *testing.T) {
+		done := make(chan struct{})
+		defer func() { <-done }()
+		go func() {
+			defer close(done)
+			time.Sleep(100 * time.Millisecond)
+			provisioner := new(db.GormDBCtx).SetDBPath(dbFile)
+			if err := provisioner.Connect(); err != nil {
+				t.Errorf("Conn to db failed: %v", err)
+				return
+			}
+			provisioner.Close()
+		}()
+
+		waitCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := ctx.WaitForDatabase(waitCtx, dbFile, 10*time.Millisecond); err != nil {
+			t.Errorf("WaitForDatabase failed: %v", err)
+		}
+	})
+
+	t.Run("CheckError", func(t *testing.T) {
+		err := ctx.WaitForDatabase(context.Background(), ":memory:", 10*time.Millisecond)
+		if err == nil || errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected the FastDBCheck error, got %v", err)
+		}
+	})
+}
+
+func TestPingLatency(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "ping_latency_test.db"))
+	if _, err := ctx.PingLatency(context.Background()); !errors.Is(err, db.ErrNotConnected) {
+		t.Errorf("Expected ErrNotConnected before Connect, got %v", err)
+	}
+
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	latency, err := ctx.PingLatency(context.Background())
+	if err != nil {
+		t.Fatalf("PingLatency failed: %v", err)
+	}
+	if latency < 0 || latency > time.Second {
+		t.Errorf("Expected a small non-negative latency, got %v", latency)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ctx.PingLatency(cancelled); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestEnforceReadOnly(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "enforce_read_only_test.db")).SetSQLiteAutoVacuum("incremental")
+	if err := ctx.EnforceReadOnly(); !errors.Is(err, db.ErrNotConnected) {
+		t.Errorf("Expected ErrNotConnected before Connect, got %v", err)
+	}
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	if err := ctx.W.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)").Error; err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if err := ctx.W.Exec("INSERT INTO items (name) VALUES ('a')").Error; err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	// a transaction still running
+	tx := ctx.W.Begin()
+	if err := ctx.EnforceReadOnly(); !errors.Is(err, db.ErrPoolInUse) {
+		t.Errorf("Expected ErrPoolInUse with a connection in use, got %v", err)
+	}
+	tx.Rollback()
+
+	w := ctx.W
+	session := ctx.Session(&gorm.Session{})
+	if err := ctx.EnforceReadOnly(); err != nil {
+		t.Fatalf("EnforceReadOnly failed: %v", err)
+	}
+
+	if err := ctx.W.Exec("INSERT INTO items (name) VALUES ('b')").Error; err == nil {
+		t.Error("Expected a write on W to fail")
+	}
+	// same handle, new pool
+	if ctx.W != w {
+		t.Error("Expected W to stay the same *gorm.DB")
+	}
+	if err := session.Exec("INSERT INTO items (name) VALUES ('b')").Error; err == nil {
+		t.Error("Expected a write through a session taken before to fail")
+	}
+	if err := ctx.R.Exec("DELETE FROM items").Error; err == nil {
+		t.Error("Expected a write on R to fail")
+	}
+	if n, err := ctx.CountRows(context.Background(), "items"); err != nil || n != 1 {
+		t.Errorf("Expected reads to work and the row to stay, got %d (%v)", n, err)
+	}
+	if err := ctx.HealthCheckWrite(context.Background()); err == nil {
+		t.Error("Expected HealthCheckWrite to fail")
+	}
+
+	// query_only stays on after a read-only transaction
+	readOnlyTx := &sql.TxOptions{ReadOnly: true}
+	if err := ctx.WithTransaction(context.Background(), func(tx *gorm.DB) error {
+		return tx.Raw("SELECT COUNT(*) FROM items").Scan(new(int)).Error
+	}, readOnlyTx); err != nil {
+		t.Errorf("Read-only transaction failed: %v", err)
+	}
+	if err := ctx.W.Exec("INSERT INTO items (name) VALUES ('b')").Error; err == nil {
+		t.Error("Expected a write on W to fail after a read-only transaction")
+	}
+
+	t.Run("Clone", func(t *testing.T) {
+		clone := ctx.Clone()
+		if err := clone.Connect(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+		defer clone.Close()
+
+		if err := clone.W.Exec("INSERT INTO items (name) VALUES ('c')").Error; err == nil {
+			t.Error("Expected a clone to stay read-only")
+		}
+	})
+}
+
+func TestEnforceReadOnlyReopenFails(t *testing.T) {
+	// init statements writing, fine until the connections are read-only
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "enforce_read_only_fails_test.db")).
+		SetConnInitSQL([]string{"CREATE TABLE IF NOT EXISTS init_marker (id INTEGER)", "INSERT INTO init_marker DEFAULT VALUES"})
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	if err := ctx.W.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY)").Error; err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	if err := ctx.EnforceReadOnly(); err == nil {
+		t.Fatal("Expected EnforceReadOnly to fail")
+	}
+	if err := ctx.W.Exec("INSERT INTO items (id) VALUES (1)").Error; err != nil {
+		t.Errorf("Expected the previous pool to stay writable, got %v", err)
+	}
+
+	// not read-only either for the next Connect
+	clone := ctx.Clone()
+	if err := clone.Connect(); err != nil {
+		t.Errorf("Expected a clone to connect, got %v", err)
+	} else {
+		clone.Close()
+	}
+}
+
+// connected without SetDBAuth, the new pools reuse the arguments of
+// ConnectToMySQL/ConnectToPostgreSQL; needs the servers of gorm_conn_test.go,
+// skipped otherwise
+func TestEnforceReadOnlyServers(t *testing.T) {
+	connects := map[string]func(ctx *db.GormDBCtx) error{
+		db.DBModeMySQL: func(ctx *db.GormDBCtx) error {
+			return ctx.SetCertPool(mysqlCertPool).ConnectToMySQL(mysqlUser, mysqlPassword, mysqlHost, "mysql", "")
+		},
+		db.DBModePostgreSQL: func(ctx *db.GormDBCtx) error {
+			return ctx.ConnectToPostgreSQL(pgUser, pgPassword, pgHost, "postgres", "disable")
+		},
+	}
+
+	for mode, connect := range connects {
+		t.Run(mode, func(t *testing.T) {
+			ctx := new(db.GormDBCtx)
+			if err := connect(ctx); err != nil {
+				t.Skipf("Skipping read-only test as server is unavailable: %v", err)
+			}
+			defer ctx.Close()
+
+			var before string
+			if err := ctx.R.Raw("SELECT current_user;").Scan(&before).Error; err != nil {
+				t.Fatalf("Query current_user failed: %v", err)
+			}
+
+			w := ctx.W
+			if err := ctx.EnforceReadOnly(); err != nil {
+				t.Fatalf("EnforceReadOnly failed: %v", err)
+			}
+			if ctx.W != w {
+				t.Error("Expected W to stay the same *gorm.DB")
+			}
+
+			var after string
+			if err := ctx.R.Raw("SELECT current_user;").Scan(&after).Error; err != nil || after != before {
+				t.Errorf("Expected to reconnect as %q, got %q (%v)", before, after, err)
+			}
+			var readOnly string
+			query := "SELECT @@SESSION.transaction_read_only;"
+			if mode == db.DBModePostgreSQL {
+				query = "SHOW default_transaction_read_only;"
+			}
+			if err := ctx.R.Raw(query).Scan(&readOnly).Error; err != nil || (readOnly != "1" && readOnly != "on") {
+				t.Errorf("Expected a read-only session, got %q (%v)", readOnly, err)
+			}
+		})
+	}
+}
+
+// needs the server of gorm_conn_test.go, skipped otherwise
+func TestEnforceReadOnlyPostgreSQL(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBMode(db.DBModePostgreSQL).SetDBAuth(pgUser, pgPassword, pgHost, "postgres", "disable")
+	if err := ctx.Connect(); err != nil {
+		t.Skipf("Skipping read-only test as server is unavailable: %v", err)
+	}
+	defer ctx.Close()
+
+	ctx.W.Exec("DROP TABLE IF EXISTS enforce_read_only_test;")
+	if err := ctx.W.Exec("CREATE TABLE enforce_read_only_test (id INT PRIMARY KEY);").Error; err != nil {
+		t.Fatalf("Create table failed: %v", err)
+	}
+	defer func() {
+		// the cleanup needs a writable connection
+		cleanup := new(db.GormDBCtx).SetDBMode(db.DBModePostgreSQL).SetDBAuth(pgUser, pgPassword, pgHost, "postgres", "disable")
+		if cleanup.Connect() == nil {
+			cleanup.W.Exec("DROP TABLE IF EXISTS enforce_read_only_test;")
+			cleanup.Close()
+		}
+	}()
+
+	if err := ctx.EnforceReadOnly(); err != nil {
+		t.Fatalf("EnforceReadOnly failed: %v", err)
+	}
+	if err := ctx.W.Exec("INSERT INTO enforce_read_only_test (id) VALUES (1);").Error; err == nil {
+		t.Error("Expected a write to fail in a read-only session")
+	}
+	if _, err := ctx.CountRows(context.Background(), "enforce_read_only_test"); err != nil {
+		t.Errorf("Expected reads to work: %v", err)
+	}
+}
+
+// needs the postgresql server of TestPostgreSQLConn, skipped without it; the
+// pool is cut to one connection so PREPARE and the count share a session
+func TestPreparedStatementCount(t *testing.T) {
+	t.Run("NotSupported", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "prepared_count_test.db"))
+		if err := ctx.Connect(); err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+		defer ctx.Close()
+
+		if _, err := ctx.PreparedStatementCount(); !errors.Is(err, db.ErrNotSupported) {
+			t.Errorf("Expected ErrNotSupported on sqlite, got %v", err)
+		}
+	})
+
+	t.Run("PostgreSQL", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModePostgreSQL).SetDBAuth(pgUser, pgPassword, pgHost, "postgres", "disable").SetMaxOpenConns(1)
+		if err := ctx.Connect(); err != nil {
+			t.Skipf("Skipping prepared statement test as server is unavailable: %v", err)
+		}
+		defer ctx.Close()
+
+		before, err := ctx.PreparedStatementCount()
+		if err != nil {
+			t.Fatalf("PreparedStatementCount failed: %v", err)
+		}
+		if err := ctx.W.Exec("PREPARE prepared_count_test AS SELECT 1;").Error; err != nil {
+			t.Fatalf("PREPARE failed: %v", err)
+		}
+		defer ctx.W.Exec("DEALLOCATE prepared_count_test;")
+
+		after, err := ctx.PreparedStatementCount()
+		if err != nil {
+			t.Fatalf("PreparedStatementCount failed: %v", err)
+		}
+		if after <= before {
+			t.Errorf("Expected the count to grow after PREPARE, got %d then %d", before, after)
+		}
+	})
+}
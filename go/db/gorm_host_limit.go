@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// returned by the dialer when a host already has SetMaxConnsPerHost
+// connections open (wrapped with the host)
+var ErrMaxConnsPerHost = errors.New("host reached its max conns")
+
+// mysql/postgresql
+//
+// n > 0 -> at most n open connections per host, a full host is skipped like
+// an unreachable one so new connections spill over to the next (mysql:
+// SetFailoverHosts, postgresql: host=a,b in the dsn); counted per Connect, a
+// new connection fails when every host is full, keep SetMaxOpenConns <= n *
+// hosts
+func (ctx *GormDBCtx) SetMaxConnsPerHost(n int) *GormDBCtx {
+	ctx.maxConnsPerHost = max(n, 0)
+
+	return ctx
+}
+
+// counts the open connections per addr, closing one frees its slot
+func hostLimitDial(dial func(ctx context.Context, network, addr string) (net.Conn, error), n int) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	var mu sync.Mutex
+	counts := make(map[string]int)
+
+	return func(dialCtx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		if counts[addr] >= n {
+			mu.Unlock()
+			return nil, fmt.Errorf("%w: %s", ErrMaxConnsPerHost, addr)
+		}
+		counts[addr]++
+		mu.Unlock()
+
+		release := sync.OnceFunc(func() {
+			mu.Lock()
+			defer mu.Unlock()
+
+			counts[addr]--
+		})
+
+		conn, err := dial(dialCtx, network, addr)
+		if err != nil {
+			release()
+			return nil, err
+		}
+
+		// the mysql driver checks idle connections through syscall.Conn
+		if rawConn, ok := conn.(syscall.Conn); ok {
+			return &hostLimitSyscallConn{hostLimitConn{Conn: conn, release: release}, rawConn}, nil
+		}
+		return &hostLimitConn{Conn: conn, release: release}, nil
+	}
+}
+
+type hostLimitConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *hostLimitConn) Close() error {
+	c.release()
+	return c.Conn.Close()
+}
+
+type hostLimitSyscallConn struct {
+	hostLimitConn
+	rawConn syscall.Conn
+}
+
+func (c *hostLimitSyscallConn) SyscallConn() (syscall.RawConn, error) {
+	return c.rawConn.SyscallConn()
+}
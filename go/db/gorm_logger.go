@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// the logger R/W are opened with, SetLogLevelLive swaps what it forwards to
+// without touching the handles (queries may be running)
+type liveLogger struct {
+	current atomic.Pointer[logger.Interface]
+}
+
+func newLiveLogger(l logger.Interface) *liveLogger {
+	live := new(liveLogger)
+	live.store(l)
+	return live
+}
+
+func (l *liveLogger) store(current logger.Interface) {
+	l.current.Store(&current)
+}
+
+func (l *liveLogger) load() logger.Interface {
+	return *l.current.Load()
+}
+
+// Session/Debug -> detached from later swaps
+func (l *liveLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return l.load().LogMode(level)
+}
+
+func (l *liveLogger) Info(stdCtx context.Context, msg string, data ...any) {
+	l.load().Info(stdCtx, msg, data...)
+}
+
+func (l *liveLogger) Warn(stdCtx context.Context, msg string, data ...any) {
+	l.load().Warn(stdCtx, msg, data...)
+}
+
+func (l *liveLogger) Error(stdCtx context.Context, msg string, data ...any) {
+	l.load().Error(stdCtx, msg, data...)
+}
+
+func (l *liveLogger) Trace(stdCtx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	l.load().Trace(stdCtx, begin, fc, err)
+}
+
+func (l *liveLogger) ParamsFilter(stdCtx context.Context, sql string, params ...any) (string, []any) {
+	if filter, ok := l.load().(gorm.ParamsFilter); ok {
+		return filter.ParamsFilter(stdCtx, sql, params...)
+	}
+	return sql, params
+}
+
+// logger of the handles opened by Connect
+func (ctx *GormDBCtx) connLogger() logger.Interface {
+	ctx.liveLogger = newLiveLogger(ctx.Logger())
+	return ctx.liveLogger
+}
+
+// SetLogLevelLive changes LogLevel of the connected R/W without reconnecting
+// (a logger from SetLogger gets LogMode(level)), sessions created before keep
+// their logger
+func (ctx *GormDBCtx) SetLogLevelLive(level logger.LogLevel) {
+	ctx.LogLevel = level
+	if ctx.liveLogger != nil {
+		ctx.liveLogger.store(ctx.Logger().LogMode(level))
+	}
+}
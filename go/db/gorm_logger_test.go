@@ -0,0 +1,44 @@
+package db_test
+
+import (
+	"bytes"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kdnetwork/code-snippet/go/db"
+	"gorm.io/gorm/logger"
+)
+
+func TestSetLogLevelLive(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "log_level_test.db")).
+		SetLogger(logger.New(log.New(&buf, "", 0), logger.Config{LogLevel: logger.Silent}))
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	var n int
+	if err := ctx.R.Raw("SELECT 1 AS silent_query").Scan(&n).Error; err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if strings.Contains(buf.String(), "silent_query") {
+		t.Errorf("Expected no query log at Silent, got %q", buf.String())
+	}
+
+	ctx.SetLogLevelLive(logger.Info)
+	if err := ctx.R.Raw("SELECT 1 AS info_query").Scan(&n).Error; err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if err := ctx.W.Exec("CREATE TABLE IF NOT EXISTS info_table (id INTEGER)").Error; err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "info_query") || !strings.Contains(buf.String(), "info_table") {
+		t.Errorf("Expected the queries of R and W to be logged at Info, got %q", buf.String())
+	}
+	if ctx.LogLevel != logger.Info {
+		t.Errorf("Expected LogLevel Info, got %v", ctx.LogLevel)
+	}
+}
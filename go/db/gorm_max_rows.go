@@ -0,0 +1,61 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrTooManyRows = errors.New("query exceeds max rows")
+
+const maxRowsGuardKey = "kd:max_rows_guard"
+
+// mysql/postgresql/sqlite
+//
+// n > 0 -> a Find into a slice without its own Limit gets LIMIT n+1 and fails
+// with ErrTooManyRows (the n+1 rows are still loaded) when the table had more
+// than n rows, Raw/Row/Count aren't guarded; 0 -> off
+func (ctx *GormDBCtx) SetMaxRows(n int) *GormDBCtx {
+	ctx.maxRows.Store(int64(max(n, 0)))
+	ctx.installMaxRows()
+
+	return ctx
+}
+
+func (ctx *GormDBCtx) installMaxRows() {
+	if ctx.maxRows.Load() <= 0 {
+		return
+	}
+
+	for _, db := range []*gorm.DB{ctx.R, ctx.W} {
+		if db == nil || db.Callback().Query().Get("kd:max_rows_before") != nil {
+			continue
+		}
+
+		_ = db.Callback().Query().Before("gorm:query").Register("kd:max_rows_before", func(tx *gorm.DB) {
+			maxRows := int(ctx.maxRows.Load())
+			if maxRows <= 0 || tx.Statement.ReflectValue.Kind() != reflect.Slice {
+				return
+			}
+			if _, ok := tx.Statement.Clauses["LIMIT"]; ok {
+				return
+			}
+
+			limit := maxRows + 1
+			tx.Statement.AddClause(clause.Limit{Limit: &limit})
+			tx.InstanceSet(maxRowsGuardKey, maxRows)
+		})
+
+		_ = db.Callback().Query().After("gorm:query").Register("kd:max_rows_after", func(tx *gorm.DB) {
+			maxRows, ok := tx.InstanceGet(maxRowsGuardKey)
+			if !ok || tx.Error != nil || tx.RowsAffected <= int64(maxRows.(int)) {
+				return
+			}
+
+			ctx.slogger().Warn(ctx.ServicePrefix, "method", "max_rows", "table", tx.Statement.Table, "max_rows", maxRows, "err", ErrTooManyRows)
+			_ = tx.AddError(ErrTooManyRows)
+		})
+	}
+}
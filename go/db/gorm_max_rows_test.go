@@ -0,0 +1,79 @@
+package db_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/kdnetwork/code-snippet/go/db"
+)
+
+type maxRowsTestItem struct {
+	ID   int
+	Name string
+}
+
+func TestMaxRows(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "max_rows_test.db")).SetMaxRows(5)
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	if err := ctx.W.AutoMigrate(&maxRowsTestItem{}); err != nil {
+		t.Fatalf("AutoMigrate failed: %v", err)
+	}
+	items := make([]maxRowsTestItem, 10)
+	for i := range items {
+		items[i].Name = string(rune('a' + i))
+	}
+	if err := ctx.W.Create(&items).Error; err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	t.Run("Exceeded", func(t *testing.T) {
+		var got []maxRowsTestItem
+		err := ctx.R.Find(&got).Error
+		if !errors.Is(err, db.ErrTooManyRows) {
+			t.Errorf("Expected ErrTooManyRows, got %v", err)
+		}
+		if len(got) != 6 {
+			t.Errorf("Expected the query to be capped at 6 rows, got %d", len(got))
+		}
+	})
+
+	t.Run("WithinLimit", func(t *testing.T) {
+		var got []maxRowsTestItem
+		if err := ctx.R.Where("id <= ?", 5).Find(&got).Error; err != nil || len(got) != 5 {
+			t.Errorf("Expected 5 rows, got %d (%v)", len(got), err)
+		}
+	})
+
+	t.Run("ExplicitLimit", func(t *testing.T) {
+		var got []maxRowsTestItem
+		if err := ctx.R.Limit(8).Find(&got).Error; err != nil || len(got) != 8 {
+			t.Errorf("Expected 8 rows, got %d (%v)", len(got), err)
+		}
+	})
+
+	t.Run("SingleRowAndCount", func(t *testing.T) {
+		var item maxRowsTestItem
+		if err := ctx.R.First(&item).Error; err != nil {
+			t.Errorf("First failed: %v", err)
+		}
+		var count int64
+		if err := ctx.R.Model(&maxRowsTestItem{}).Count(&count).Error; err != nil || count != 10 {
+			t.Errorf("Expected count 10, got %d (%v)", count, err)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		ctx.SetMaxRows(0)
+		defer ctx.SetMaxRows(5)
+
+		var got []maxRowsTestItem
+		if err := ctx.R.Find(&got).Error; err != nil || len(got) != 10 {
+			t.Errorf("Expected 10 rows, got %d (%v)", len(got), err)
+		}
+	})
+}
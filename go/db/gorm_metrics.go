@@ -0,0 +1,181 @@
+package db
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// upper bounds of the latency histogram, the last bucket is unbounded
+var queryLatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+const queryMetricsStartKey = "kd:query_metrics_start"
+
+type OperationMetrics struct {
+	Count  int64
+	Errors int64
+	Total  time.Duration
+	Max    time.Duration
+
+	// estimated from Buckets, the upper bound of the bucket the percentile
+	// falls into (Max for the unbounded bucket)
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+
+	// Buckets[i] -> queries <= QueryLatencyBuckets()[i], the extra last
+	// bucket counts the slower ones
+	Buckets []int64
+}
+
+// QueryMetricsSnapshot -> operation (create, query, update, delete, row, raw)
+// -> metrics
+type QueryMetricsSnapshot map[string]OperationMetrics
+
+type queryMetrics struct {
+	mu  sync.Mutex
+	ops map[string]*OperationMetrics
+}
+
+func QueryLatencyBuckets() []time.Duration {
+	return append([]time.Duration(nil), queryLatencyBuckets...)
+}
+
+// mysql/postgresql/sqlite
+//
+// counts and latencies per operation for the lifetime of ctx (reconnects
+// included), read them with QueryMetrics
+func (ctx *GormDBCtx) SetQueryMetrics(enabled bool) *GormDBCtx {
+	ctx.queryMetricsEnabled.Store(enabled)
+	ctx.installQueryMetrics()
+
+	return ctx
+}
+
+// QueryMetrics returns a copy of the counters, empty when SetQueryMetrics was
+// never enabled
+func (ctx *GormDBCtx) QueryMetrics() QueryMetricsSnapshot {
+	snapshot := make(QueryMetricsSnapshot)
+	if ctx.queryMetrics == nil {
+		return snapshot
+	}
+
+	ctx.queryMetrics.mu.Lock()
+	defer ctx.queryMetrics.mu.Unlock()
+
+	for op, m := range ctx.queryMetrics.ops {
+		c := *m
+		c.Buckets = append([]int64(nil), m.Buckets...)
+		c.P50 = c.percentile(0.50)
+		c.P90 = c.percentile(0.90)
+		c.P99 = c.percentile(0.99)
+		snapshot[op] = c
+	}
+
+	return snapshot
+}
+
+func (m *OperationMetrics) percentile(q float64) time.Duration {
+	if m.Count == 0 {
+		return 0
+	}
+
+	rank := int64(q*float64(m.Count) + 0.5)
+	rank = max(rank, 1)
+
+	var cumulative int64
+	for i, n := range m.Buckets {
+		cumulative += n
+		if cumulative >= rank {
+			if i < len(queryLatencyBuckets) {
+				return min(queryLatencyBuckets[i], m.Max)
+			}
+			break
+		}
+	}
+	return m.Max
+}
+
+func (qm *queryMetrics) record(op string, elapsed time.Duration, failed bool) {
+	bucket := sort.Search(len(queryLatencyBuckets), func(i int) bool { return elapsed <= queryLatencyBuckets[i] })
+
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	m, ok := qm.ops[op]
+	if !ok {
+		m = &OperationMetrics{Buckets: make([]int64, len(queryLatencyBuckets)+1)}
+		qm.ops[op] = m
+	}
+	m.Count++
+	if failed {
+		m.Errors++
+	}
+	m.Total += elapsed
+	m.Max = max(m.Max, elapsed)
+	m.Buckets[bucket]++
+}
+
+// registers the callbacks on R and W once, they are no-ops while disabled
+func (ctx *GormDBCtx) installQueryMetrics() {
+	if !ctx.queryMetricsEnabled.Load() {
+		return
+	}
+	if ctx.queryMetrics == nil {
+		ctx.queryMetrics = &queryMetrics{ops: make(map[string]*OperationMetrics)}
+	}
+
+	for _, db := range []*gorm.DB{ctx.R, ctx.W} {
+		if db == nil || db.Callback().Query().Get("kd:query_metrics_before") != nil {
+			continue
+		}
+
+		before := func(tx *gorm.DB) {
+			if ctx.queryMetricsEnabled.Load() {
+				tx.InstanceSet(queryMetricsStartKey, time.Now())
+			}
+		}
+		after := func(op string) func(tx *gorm.DB) {
+			return func(tx *gorm.DB) {
+				start, ok := tx.InstanceGet(queryMetricsStartKey)
+				if !ok {
+					return
+				}
+				ctx.queryMetrics.record(op, time.Since(start.(time.Time)), tx.Error != nil)
+			}
+		}
+
+		callback := db.Callback()
+		_ = callback.Create().Before("*").Register("kd:query_metrics_before", before)
+		_ = callback.Create().After("*").Register("kd:query_metrics_after", after("create"))
+		_ = callback.Query().Before("*").Register("kd:query_metrics_before", before)
+		_ = callback.Query().After("*").Register("kd:query_metrics_after", after("query"))
+		_ = callback.Update().Before("*").Register("kd:query_metrics_before", before)
+		_ = callback.Update().After("*").Register("kd:query_metrics_after", after("update"))
+		_ = callback.Delete().Before("*").Register("kd:query_metrics_before", before)
+		_ = callback.Delete().After("*").Register("kd:query_metrics_after", after("delete"))
+		_ = callback.Row().Before("*").Register("kd:query_metrics_before", before)
+		_ = callback.Row().After("*").Register("kd:query_metrics_after", after("row"))
+		_ = callback.Raw().Before("*").Register("kd:query_metrics_before", before)
+		_ = callback.Raw().After("*").Register("kd:query_metrics_after", after("raw"))
+	}
+}
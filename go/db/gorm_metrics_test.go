@@ -0,0 +1,70 @@
+package db_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/kdnetwork/code-snippet/go/db"
+)
+
+type metricsTestItem struct {
+	ID   int
+	Name string
+}
+
+func TestQueryMetrics(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "query_metrics_test.db")).SetQueryMetrics(true)
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	if err := ctx.W.AutoMigrate(&metricsTestItem{}); err != nil {
+		t.Fatalf("AutoMigrate failed: %v", err)
+	}
+	before := ctx.QueryMetrics()
+
+	for i := range 3 {
+		if err := ctx.W.Create(&metricsTestItem{Name: string(rune('a' + i))}).Error; err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+	var items []metricsTestItem
+	ctx.R.Find(&items)
+	ctx.R.Find(&items)
+	ctx.W.Model(&metricsTestItem{}).Where("id = ?", 1).Update("name", "z")
+	ctx.W.Delete(&metricsTestItem{}, 2)
+	ctx.W.Exec("INSERT INTO not_exists VALUES (1);")
+
+	snapshot := ctx.QueryMetrics()
+	for op, want := range map[string]int64{"create": 3, "query": 2, "update": 1, "delete": 1} {
+		if got := snapshot[op].Count - before[op].Count; got != want {
+			t.Errorf("%s: expected %d queries, got %d", op, want, got)
+		}
+	}
+
+	raw := snapshot["raw"]
+	if raw.Errors-before["raw"].Errors != 1 {
+		t.Errorf("raw: expected 1 error, got %d", raw.Errors-before["raw"].Errors)
+	}
+
+	create := snapshot["create"]
+	var bucketTotal int64
+	for _, n := range create.Buckets {
+		bucketTotal += n
+	}
+	if bucketTotal != create.Count {
+		t.Errorf("create: histogram holds %d queries, count is %d", bucketTotal, create.Count)
+	}
+	if create.P50 <= 0 || create.P50 > create.P99 || create.P99 > create.Max {
+		t.Errorf("create: invalid percentiles p50=%v p99=%v max=%v", create.P50, create.P99, create.Max)
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		ctx.SetQueryMetrics(false)
+		ctx.R.Find(&items)
+		if got := ctx.QueryMetrics()["query"].Count; got != snapshot["query"].Count {
+			t.Errorf("query recorded while disabled: %d -> %d", snapshot["query"].Count, got)
+		}
+	})
+}
@@ -0,0 +1,33 @@
+package db
+
+import "fmt"
+
+// Must* are for CLI tools, tests and init(): they panic instead of returning
+// the error, services should use Connect/NewFromConfig
+
+// MustConnect is Connect, panics on failure
+func (ctx *GormDBCtx) MustConnect() *GormDBCtx {
+	if err := ctx.Connect(); err != nil {
+		panic(fmt.Sprintf("db: connect to %s failed: %v", ctx.describe(), err))
+	}
+	return ctx
+}
+
+// Must panics if err != nil, e.g. Must(NewFromConfig(cfg)).MustConnect()
+func Must(ctx *GormDBCtx, err error) *GormDBCtx {
+	if err != nil {
+		panic(fmt.Sprintf("db: %v", err))
+	}
+	return ctx
+}
+
+// dbmode + target, without credentials
+func (ctx *GormDBCtx) describe() string {
+	switch ctx.DBMode {
+	case DBModeSQLite:
+		return "sqlite `" + ctx.dbPath + "`"
+	case DBModeMySQL, DBModePostgreSQL:
+		return ctx.DBMode + " `" + ctx.host + "/" + ctx.dbName + "`"
+	}
+	return "invalid db mode `" + ctx.DBMode + "`"
+}
@@ -0,0 +1,62 @@
+package db_test
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kdnetwork/code-snippet/go/db"
+)
+
+// returns the value passed to panic, nil if fn didn't panic
+func recoverPanic(fn func()) (v any) {
+	defer func() { v = recover() }()
+	fn()
+	return nil
+}
+
+func TestMustConnect(t *testing.T) {
+	t.Run("SQLite", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "must_connect_test.db"))
+		if v := recoverPanic(func() { ctx.MustConnect() }); v != nil {
+			t.Fatalf("MustConnect panicked: %v", v)
+		}
+		defer ctx.Close()
+
+		if ctx.W == nil || ctx.R == nil {
+			t.Error("Expected connected handles")
+		}
+	})
+
+	t.Run("BadConfig", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBPath(t.TempDir()) // a directory
+		v := recoverPanic(func() { ctx.MustConnect() })
+		if v == nil {
+			ctx.Close()
+			t.Fatal("Expected MustConnect to panic")
+		}
+		if msg := fmt.Sprint(v); !strings.Contains(msg, "connect to sqlite") {
+			t.Errorf("Expected a descriptive panic, got %q", msg)
+		}
+	})
+
+	t.Run("InvalidMode", func(t *testing.T) {
+		if v := recoverPanic(func() { new(db.GormDBCtx).MustConnect() }); v == nil {
+			t.Error("Expected MustConnect to panic without a db mode")
+		}
+	})
+}
+
+func TestMust(t *testing.T) {
+	ctx := new(db.GormDBCtx)
+	if got := db.Must(ctx, nil); got != ctx {
+		t.Error("Expected Must to return ctx")
+	}
+
+	v := recoverPanic(func() { db.Must(nil, errors.New("invalid log level")) })
+	if msg := fmt.Sprint(v); !strings.Contains(msg, "invalid log level") {
+		t.Errorf("Expected Must to panic with the error, got %v", v)
+	}
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/migrator"
+	"gorm.io/gorm/schema"
+)
+
+// notConnectedDB is what the accessors returning a *gorm.DB hand out before
+// Connect (or after Close): the handle carries ErrNotConnected, the default
+// callbacks skip every statement and the chain ends with it
+func notConnectedDB() *gorm.DB {
+	db, _ := gorm.Open(notConnectedDialector{}, &gorm.Config{Logger: logger.Discard, SkipDefaultTransaction: true})
+	_ = db.AddError(ErrNotConnected)
+	return db
+}
+
+type notConnectedDialector struct{}
+
+func (notConnectedDialector) Name() string {
+	return "not_connected"
+}
+
+func (notConnectedDialector) Initialize(db *gorm.DB) error {
+	callbacks.RegisterDefaultCallbacks(db, &callbacks.Config{})
+	db.ConnPool = notConnectedConnPool{}
+	return nil
+}
+
+func (d notConnectedDialector) Migrator(db *gorm.DB) gorm.Migrator {
+	return migrator.Migrator{Config: migrator.Config{DB: db, Dialector: d}}
+}
+
+func (notConnectedDialector) DataTypeOf(*schema.Field) string {
+	return ""
+}
+
+func (notConnectedDialector) DefaultValueOf(*schema.Field) clause.Expression {
+	return clause.Expr{SQL: "DEFAULT"}
+}
+
+func (notConnectedDialector) BindVarTo(writer clause.Writer, _ *gorm.Statement, _ any) {
+	_ = writer.WriteByte('?')
+}
+
+func (notConnectedDialector) QuoteTo(writer clause.Writer, str string) {
+	_, _ = writer.WriteString(`"` + str + `"`)
+}
+
+func (notConnectedDialector) Explain(sql string, vars ...any) string {
+	return logger.ExplainSQL(sql, nil, `'`, vars...)
+}
+
+// only reached by Begin/Transaction, the callbacks never get to it
+type notConnectedConnPool struct{}
+
+func (notConnectedConnPool) PrepareContext(context.Context, string) (*sql.Stmt, error) {
+	return nil, ErrNotConnected
+}
+
+func (notConnectedConnPool) ExecContext(context.Context, string, ...any) (sql.Result, error) {
+	return nil, ErrNotConnected
+}
+
+func (notConnectedConnPool) QueryContext(context.Context, string, ...any) (*sql.Rows, error) {
+	return nil, ErrNotConnected
+}
+
+func (notConnectedConnPool) QueryRowContext(context.Context, string, ...any) *sql.Row {
+	return nil
+}
+
+func (notConnectedConnPool) BeginTx(context.Context, *sql.TxOptions) (gorm.ConnPool, error) {
+	return nil, ErrNotConnected
+}
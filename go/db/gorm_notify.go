@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// postgresql
+//
+// Listen opens a dedicated connection (outside the pool of R/W), runs LISTEN
+// channel and sends the payload of every notification on the returned
+// channel; the connection is closed and the channel closed once stdCtx ends
+// (or the connection breaks, logged)
+func (ctx *GormDBCtx) Listen(stdCtx context.Context, channel string) (<-chan string, error) {
+	if ctx.DBMode != DBModePostgreSQL {
+		return nil, ErrNotSupported
+	}
+	if ctx.pgxConfig == nil {
+		return nil, ErrNotConnected
+	}
+
+	conn, err := pgx.ConnectConfig(stdCtx, ctx.pgxConfig.Copy())
+	if err != nil {
+		ctx.slogger().Error(ctx.ServicePrefix, "method", "listen", "channel", channel, "err", err)
+		return nil, err
+	}
+	if _, err := conn.Exec(stdCtx, "LISTEN "+pgx.Identifier{channel}.Sanitize()+";"); err != nil {
+		_ = conn.Close(context.Background())
+		ctx.slogger().Error(ctx.ServicePrefix, "method", "listen", "channel", channel, "err", err)
+		return nil, err
+	}
+
+	payloads := make(chan string)
+	go func() {
+		defer close(payloads)
+		defer conn.Close(context.Background())
+
+		for {
+			notification, err := conn.WaitForNotification(stdCtx)
+			if err != nil {
+				if stdCtx.Err() == nil {
+					ctx.slogger().Error(ctx.ServicePrefix, "method", "listen", "channel", channel, "err", err)
+				}
+				return
+			}
+
+			select {
+			case payloads <- notification.Payload:
+			case <-stdCtx.Done():
+				return
+			}
+		}
+	}()
+
+	return payloads, nil
+}
+
+// postgresql
+//
+// Notify -> pg_notify(channel, payload) on W, delivered on commit when W is
+// in a transaction
+func (ctx *GormDBCtx) Notify(channel, payload string) error {
+	if ctx.DBMode != DBModePostgreSQL {
+		return ErrNotSupported
+	}
+	if err := ctx.ensureConnected(); err != nil {
+		return err
+	}
+	return ctx.W.Exec("SELECT pg_notify(?, ?);", channel, payload).Error
+}
@@ -0,0 +1,68 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kdnetwork/code-snippet/go/db"
+)
+
+// integration test: needs the postgresql server configured in
+// gorm_conn_test.go, skipped otherwise
+func TestListenNotify(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBMode(db.DBModePostgreSQL).SetDBAuth(pgUser, pgPassword, pgHost, "postgres", "disable")
+	if err := ctx.Connect(); err != nil {
+		t.Skipf("Skipping LISTEN/NOTIFY as server is unavailable: %v", err)
+	}
+	defer ctx.Close()
+
+	listenCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	payloads, err := ctx.Listen(listenCtx, "Cache Invalidation")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	for _, payload := range []string{"users:1", "users:2"} {
+		if err := ctx.Notify("Cache Invalidation", payload); err != nil {
+			t.Fatalf("Notify failed: %v", err)
+		}
+		select {
+		case got := <-payloads:
+			if got != payload {
+				t.Errorf("Expected payload %q, got %q", payload, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No notification received for %q", payload)
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-payloads:
+		if ok {
+			t.Error("Expected no more notifications")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the channel to be closed once ctx is cancelled")
+	}
+}
+
+func TestListenNotSupported(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "listen_test.db"))
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	if _, err := ctx.Listen(context.Background(), "events"); !errors.Is(err, db.ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported from Listen, got %v", err)
+	}
+	if err := ctx.Notify("events", "payload"); !errors.Is(err, db.ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported from Notify, got %v", err)
+	}
+}
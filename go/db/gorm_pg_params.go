@@ -0,0 +1,117 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// libpq connection options -> https://www.postgresql.org/docs/current/libpq-connect.html#LIBPQ-PARAMKEYWORDS
+// host/port/dbname/user/password come from SetDBAuth
+var postgresParamsAllowlist = map[string]bool{
+	"application_name":     true,
+	"channel_binding":      true,
+	"client_encoding":      true,
+	"connect_timeout":      true,
+	"keepalives":           true,
+	"keepalives_count":     true,
+	"keepalives_idle":      true,
+	"keepalives_interval":  true,
+	"krbspn":               true,
+	"krbsrvname":           true,
+	"max_protocol_version": true,
+	"min_protocol_version": true,
+	"options":              true,
+	"passfile":             true,
+	"sslcert":              true,
+	"sslkey":               true,
+	"sslmode":              true,
+	"sslnegotiation":       true,
+	"sslpassword":          true,
+	"sslrootcert":          true,
+	"sslsni":               true,
+	"target_session_attrs": true,
+	"tcp_user_timeout":     true,
+}
+
+// libpq applies them to the socket, pgx would send them to the server as
+// runtime params (and fail) -> set on the dialed conn instead
+var postgresSocketParams = []string{"keepalives", "keepalives_count", "keepalives_idle", "keepalives_interval", "tcp_user_timeout"}
+
+func applyPostgresSocketParams(pgxConfig *pgx.ConnConfig, params map[string]string) error {
+	keepAlive := net.KeepAliveConfig{Enable: true}
+	var userTimeout time.Duration
+	configured := false
+
+	for _, key := range postgresSocketParams {
+		value, ok := params[key]
+		if !ok {
+			continue
+		}
+		delete(pgxConfig.RuntimeParams, key)
+		configured = true
+
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid %s `%s`", key, value)
+		}
+		switch key {
+		case "keepalives":
+			keepAlive.Enable = n != 0
+		case "keepalives_count":
+			keepAlive.Count = n
+		case "keepalives_idle":
+			keepAlive.Idle = time.Duration(n) * time.Second
+		case "keepalives_interval":
+			keepAlive.Interval = time.Duration(n) * time.Second
+		case "tcp_user_timeout":
+			userTimeout = time.Duration(n) * time.Millisecond
+		}
+	}
+
+	if !configured {
+		return nil
+	}
+
+	// 0 -> os default, same as libpq
+	for _, d := range []*time.Duration{&keepAlive.Idle, &keepAlive.Interval} {
+		if *d == 0 {
+			*d = -1
+		}
+	}
+	if keepAlive.Count == 0 {
+		keepAlive.Count = -1
+	}
+
+	dial := pgxConfig.DialFunc
+	pgxConfig.DialFunc = func(stdCtx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(stdCtx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		// unix sockets, proxies...
+		tcpConn, ok := conn.(*net.TCPConn)
+		if !ok {
+			return conn, nil
+		}
+
+		if err := tcpConn.SetKeepAliveConfig(keepAlive); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		if userTimeout > 0 {
+			if err := setTCPUserTimeout(tcpConn, userTimeout); err != nil {
+				_ = conn.Close()
+				return nil, err
+			}
+		}
+		return conn, nil
+	}
+
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package db
+
+import (
+	"net"
+	"time"
+)
+
+// TCP_USER_TIMEOUT is linux only, ignored elsewhere as libpq does
+const tcpUserTimeoutSupported = false
+
+func setTCPUserTimeout(conn *net.TCPConn, timeout time.Duration) error {
+	return nil
+}
@@ -0,0 +1,36 @@
+//go:build !linux
+
+package db_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/kdnetwork/code-snippet/go/db"
+)
+
+// no TCP_USER_TIMEOUT outside linux, the dial must still go through
+func TestPostgresParamsTCPUserTimeoutIgnored(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBMode(db.DBModePostgreSQL).SetPostgresParams(map[string]string{"tcp_user_timeout": "5000"})
+
+	pgxConfig, err := db.PostgreSQLConfig(ctx, "user", "pw", "127.0.0.1:5432", "app", "disable")
+	if err != nil {
+		t.Fatalf("PostgreSQLConfig failed: %v", err)
+	}
+	if _, ok := pgxConfig.RuntimeParams["tcp_user_timeout"]; ok {
+		t.Error("Expected tcp_user_timeout not to be sent as a runtime param")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+
+	conn, err := pgxConfig.DialFunc(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial with tcp_user_timeout failed: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,641 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"math/rand/v2"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kdnetwork/code-snippet/go/utils"
+	"gorm.io/gorm"
+)
+
+// pool settings are applied on Connect, or right away when already connected
+// sqlite: W always keeps MaxOpenConns(1)
+
+func (ctx *GormDBCtx) SetMaxOpenConns(n int) *GormDBCtx {
+	ctx.maxOpenConns = max(n, 0)
+	ctx.applyPoolConfig()
+	return ctx
+}
+
+func (ctx *GormDBCtx) SetMaxIdleConns(n int) *GormDBCtx {
+	ctx.maxIdleConns = max(n, 0)
+	ctx.applyPoolConfig()
+	return ctx
+}
+
+func (ctx *GormDBCtx) SetConnMaxLifetime(d time.Duration) *GormDBCtx {
+	ctx.connMaxLifetime = max(d, 0)
+	ctx.applyPoolConfig()
+	if ctx.R != nil {
+		ctx.startConnReapers()
+	}
+	return ctx
+}
+
+func (ctx *GormDBCtx) SetConnMaxIdleTime(d time.Duration) *GormDBCtx {
+	ctx.connMaxIdleTime = max(d, 0)
+	ctx.applyPoolConfig()
+	return ctx
+}
+
+var ErrAcquireTimeout = errors.New("timed out waiting for a free connection")
+
+const acquiredConnKey = "kd:acquired_conn"
+
+// mysql/postgresql/sqlite
+//
+// d > 0 -> statements outside a transaction (Create/Find/Update/Delete/Exec,
+// not Rows/Row) wait at most d for a free connection of the pool and fail
+// with ErrAcquireTimeout, the statement itself isn't bounded; 0 -> off
+func (ctx *GormDBCtx) SetAcquireTimeout(d time.Duration) *GormDBCtx {
+	ctx.acquireTimeout.Store(int64(max(d, 0)))
+	ctx.installAcquireTimeout()
+	return ctx
+}
+
+func (ctx *GormDBCtx) installAcquireTimeout() {
+	if ctx.acquireTimeout.Load() <= 0 {
+		return
+	}
+
+	acquire := func(tx *gorm.DB) {
+		timeout := time.Duration(ctx.acquireTimeout.Load())
+		// SetSlowQueryWatchdog may have wrapped the pool already
+		watchdog, wrapped := tx.Statement.ConnPool.(*watchdogConnPool)
+		pool := tx.Statement.ConnPool
+		if wrapped {
+			pool = watchdog.ConnPool
+		}
+		// so may SetSQLiteBusyHandler, kept around the acquired connection
+		busy, busyWrapped := pool.(*busyRetryConnPool)
+		if busyWrapped {
+			pool = busy.db
+		}
+		sqlDB, ok := pool.(*sql.DB)
+		if timeout <= 0 || !ok || tx.Error != nil {
+			return
+		}
+
+		stdCtx := tx.Statement.Context
+		acquireCtx, cancel := context.WithTimeout(stdCtx, timeout)
+		defer cancel()
+
+		conn, err := sqlDB.Conn(acquireCtx)
+		if err != nil {
+			if stdCtx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+				err = ErrAcquireTimeout
+			}
+			_ = tx.AddError(err)
+			return
+		}
+
+		// the statement runs on the connection just acquired
+		var acquired gorm.ConnPool = conn
+		if busyWrapped {
+			acquired = busy.on(conn)
+		}
+		if wrapped {
+			watchdog.ConnPool = acquired
+		} else {
+			tx.Statement.ConnPool = acquired
+		}
+		tx.InstanceSet(acquiredConnKey, conn)
+	}
+	release := func(tx *gorm.DB) {
+		if conn, ok := tx.InstanceGet(acquiredConnKey); ok {
+			_ = conn.(*sql.Conn).Close()
+		}
+	}
+
+	for _, db := range []*gorm.DB{ctx.R, ctx.W} {
+		if db == nil || db.Callback().Query().Get("kd:acquire_timeout_before") != nil {
+			continue
+		}
+
+		callback := db.Callback()
+		_ = callback.Create().Before("gorm:begin_transaction").Register("kd:acquire_timeout_before", acquire)
+		_ = callback.Create().After("*").Register("kd:acquire_timeout_after", release)
+		_ = callback.Query().Before("gorm:query").Register("kd:acquire_timeout_before", acquire)
+		_ = callback.Query().After("*").Register("kd:acquire_timeout_after", release)
+		_ = callback.Update().Before("gorm:begin_transaction").Register("kd:acquire_timeout_before", acquire)
+		_ = callback.Update().After("*").Register("kd:acquire_timeout_after", release)
+		_ = callback.Delete().Before("gorm:begin_transaction").Register("kd:acquire_timeout_before", acquire)
+		_ = callback.Delete().After("*").Register("kd:acquire_timeout_after", release)
+		_ = callback.Raw().Before("gorm:raw").Register("kd:acquire_timeout_before", acquire)
+		_ = callback.Raw().After("*").Register("kd:acquire_timeout_after", release)
+	}
+}
+
+var numCPU = runtime.NumCPU
+
+const autoTuneConnMaxLifetime = 30 * time.Minute
+
+// NumCPU * 2 open, NumCPU idle (open 4 ~ 64, idle 2 ~ 32)
+func autoTunePoolSize(cpus int) (maxOpenConns, maxIdleConns int) {
+	return utils.Clamp(cpus*2, 4, 64), utils.Clamp(cpus, 2, 32)
+}
+
+// AutoTunePool sets MaxOpenConns/MaxIdleConns from runtime.NumCPU() and
+// ConnMaxLifetime to 30m (well below the usual 8h wait_timeout), sqlite W
+// keeps MaxOpenConns(1)
+func (ctx *GormDBCtx) AutoTunePool() *GormDBCtx {
+	maxOpenConns, maxIdleConns := autoTunePoolSize(numCPU())
+
+	return ctx.SetMaxOpenConns(maxOpenConns).
+		SetMaxIdleConns(maxIdleConns).
+		SetConnMaxLifetime(autoTuneConnMaxLifetime)
+}
+
+type cloudPreset struct {
+	connMaxLifetime time.Duration
+	connMaxIdleTime time.Duration
+	// seconds, postgresql keepalives_idle/keepalives_interval
+	keepalives int
+}
+
+var cloudPresets = map[string]cloudPreset{
+	"rds":      {connMaxLifetime: 15 * time.Minute, connMaxIdleTime: 5 * time.Minute, keepalives: 60},
+	"cloudsql": {connMaxLifetime: 30 * time.Minute, connMaxIdleTime: 9 * time.Minute, keepalives: 60},
+	"azure":    {connMaxLifetime: 15 * time.Minute, connMaxIdleTime: 3 * time.Minute, keepalives: 30},
+}
+
+// CloudPreset applies pool settings for managed databases, whose network path
+// silently drops idle connections ("invalid connection", "unexpected EOF"):
+//
+//	rds      -> ConnMaxLifetime 15m, ConnMaxIdleTime 5m, keepalives 60s (NAT gateway/NLB idle timeout 350s)
+//	cloudsql -> ConnMaxLifetime 30m, ConnMaxIdleTime 9m, keepalives 60s (VPC idle timeout 10m)
+//	azure    -> ConnMaxLifetime 15m, ConnMaxIdleTime 3m, keepalives 30s (load balancer idle timeout 4m)
+//
+// keepalives -> postgresql keepalives_idle/keepalives_interval unless already
+// set by SetPostgresParams (call it before, it replaces the params), mysql
+// keeps the 15s keepalive of its dialer; unknown provider -> warning, nothing
+// changes
+func (ctx *GormDBCtx) CloudPreset(provider string) *GormDBCtx {
+	preset, ok := cloudPresets[strings.ToLower(provider)]
+	if !ok {
+		ctx.slogger().Warn(ctx.ServicePrefix, "method", "cloud_preset", "err", "unknown provider `"+provider+"`")
+		return ctx
+	}
+
+	if ctx.postgresParams == nil {
+		ctx.postgresParams = make(map[string]string)
+	}
+	for _, key := range []string{"keepalives_idle", "keepalives_interval"} {
+		if _, ok := ctx.postgresParams[key]; !ok {
+			ctx.postgresParams[key] = strconv.Itoa(preset.keepalives)
+		}
+	}
+
+	return ctx.SetConnMaxLifetime(preset.connMaxLifetime).
+		SetConnMaxIdleTime(preset.connMaxIdleTime)
+}
+
+type poolPreset struct {
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+	connMaxIdleTime time.Duration
+}
+
+var poolPresets = map[string]poolPreset{
+	"web":        {maxOpenConns: 25, maxIdleConns: 10, connMaxLifetime: 5 * time.Minute, connMaxIdleTime: 2 * time.Minute},
+	"batch":      {maxOpenConns: 64, maxIdleConns: 64, connMaxLifetime: time.Hour, connMaxIdleTime: 30 * time.Minute},
+	"serverless": {maxOpenConns: 5, maxIdleConns: 1, connMaxLifetime: time.Minute, connMaxIdleTime: 10 * time.Second},
+}
+
+// PoolPreset applies pool settings for a workload shape:
+//
+//	web        -> MaxOpenConns 25, MaxIdleConns 10, ConnMaxLifetime 5m, ConnMaxIdleTime 2m (many short requests, connections follow failovers and rebalancing quickly)
+//	batch      -> MaxOpenConns 64, MaxIdleConns 64, ConnMaxLifetime 1h, ConnMaxIdleTime 30m (few long jobs saturating the pool, reconnecting is wasted time)
+//	serverless -> MaxOpenConns 5, MaxIdleConns 1, ConnMaxLifetime 1m, ConnMaxIdleTime 10s (many instances sharing the server limit, nothing kept across scale-to-zero)
+//
+// sqlite W keeps MaxOpenConns(1); unknown shape -> warning, nothing changes
+func (ctx *GormDBCtx) PoolPreset(shape string) *GormDBCtx {
+	preset, ok := poolPresets[strings.ToLower(shape)]
+	if !ok {
+		ctx.slogger().Warn(ctx.ServicePrefix, "method", "pool_preset", "err", "unknown shape `"+shape+"`")
+		return ctx
+	}
+
+	return ctx.SetMaxOpenConns(preset.maxOpenConns).
+		SetMaxIdleConns(preset.maxIdleConns).
+		SetConnMaxLifetime(preset.connMaxLifetime).
+		SetConnMaxIdleTime(preset.connMaxIdleTime)
+}
+
+// SetConnMaxLifetimeJitter spreads connection recycling over
+// ConnMaxLifetime * (1 ± fraction) instead of expiring every connection at
+// the same age, fraction 0 ~ 1 (0 -> off)
+//
+// database/sql has no per-connection lifetime, so a reaper gives every idle
+// connection it comes across its own jittered lifetime (counted from then)
+// and discards it once past it; ConnMaxLifetime * (1 + fraction) stays as the
+// hard limit, connections that are never idle reach it
+func (ctx *GormDBCtx) SetConnMaxLifetimeJitter(fraction float64) *GormDBCtx {
+	ctx.connMaxLifetimeJitter = utils.Clamp(fraction, 0, 1)
+	ctx.applyPoolConfig()
+	if ctx.R != nil {
+		ctx.startConnReapers()
+	}
+	return ctx
+}
+
+func (ctx *GormDBCtx) applyPoolConfig() {
+	apply := func(db *gorm.DB, isSQLiteW bool) {
+		if db == nil {
+			return
+		}
+		sqlDB, err := db.DB()
+		if err != nil {
+			return
+		}
+
+		if ctx.maxOpenConns > 0 && !isSQLiteW {
+			sqlDB.SetMaxOpenConns(ctx.maxOpenConns)
+		}
+		if ctx.maxIdleConns > 0 {
+			sqlDB.SetMaxIdleConns(ctx.maxIdleConns)
+		}
+		if ctx.connMaxLifetime > 0 {
+			sqlDB.SetConnMaxLifetime(ctx.connMaxLifetime + time.Duration(float64(ctx.connMaxLifetime)*ctx.connMaxLifetimeJitter))
+		}
+		if ctx.connMaxIdleTime > 0 {
+			sqlDB.SetConnMaxIdleTime(ctx.connMaxIdleTime)
+		}
+	}
+
+	apply(ctx.R, false)
+	if ctx.W != ctx.R {
+		apply(ctx.W, ctx.DBMode == DBModeSQLite)
+	}
+}
+
+// checks per ConnMaxLifetime
+const connReaperTicks = 16
+
+// an idle connection is handed out at once, past this the reaper skips the
+// pass
+const connReaperAcquireTimeout = 10 * time.Millisecond
+
+func (ctx *GormDBCtx) startConnReapers() {
+	ctx.stopConnReapers()
+	// the reaper keeps a copy, the setters restart it
+	lifetime, jitter := ctx.connMaxLifetime, ctx.connMaxLifetimeJitter
+	if lifetime <= 0 || jitter <= 0 {
+		return
+	}
+
+	var reapers []*connReaper
+	for _, db := range []*gorm.DB{ctx.R, ctx.W} {
+		if db == nil || (db == ctx.W && ctx.W == ctx.R) {
+			continue
+		}
+		if sqlDB, err := db.DB(); err == nil {
+			reapers = append(reapers, &connReaper{sqlDB: sqlDB, lifetime: lifetime, jitter: jitter, expires: make(map[any]time.Time)})
+		}
+	}
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	ctx.connReaperStop, ctx.connReaperDone = stop, done
+
+	// unblocks a reap in progress
+	stdCtx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(max(lifetime/connReaperTicks, time.Millisecond))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			for _, reaper := range reapers {
+				reaper.reap(stdCtx)
+			}
+		}
+	}()
+}
+
+func (ctx *GormDBCtx) stopConnReapers() {
+	if ctx.connReaperStop == nil {
+		return
+	}
+	close(ctx.connReaperStop)
+	<-ctx.connReaperDone
+	ctx.connReaperStop = nil
+}
+
+type connReaper struct {
+	sqlDB    *sql.DB
+	lifetime time.Duration
+	jitter   float64
+	// driver conn -> when to discard it
+	expires map[any]time.Time
+}
+
+// takes the idle connections out of the pool one at a time: an expired one
+// is closed right away (returned with driver.ErrBadConn), a live one is held
+// until the end of the pass so database/sql hands out the next one
+//
+// the reaper never waits on the pool, taking a connection gives up after
+// connReaperAcquireTimeout or once stdCtx (cancelled by stopConnReapers) is
+// done
+func (r *connReaper) reap(stdCtx context.Context) {
+	now := time.Now()
+
+	var live []*sql.Conn
+	defer func() {
+		for _, conn := range live {
+			_ = conn.Close()
+		}
+	}()
+
+	for range r.sqlDB.Stats().Idle {
+		// taken by a query meanwhile, Conn would wait or dial
+		if r.sqlDB.Stats().Idle == 0 {
+			break
+		}
+		conn, err := r.acquire(stdCtx)
+		if err != nil {
+			break
+		}
+
+		err = conn.Raw(func(driverConn any) error {
+			expires, ok := r.expires[driverConn]
+			if !ok {
+				expires = now.Add(time.Duration(float64(r.lifetime) * (1 + r.jitter*(2*rand.Float64()-1))))
+				r.expires[driverConn] = expires
+			}
+			if now.Before(expires) {
+				return nil
+			}
+			delete(r.expires, driverConn)
+			return driver.ErrBadConn
+		})
+		if err != nil {
+			_ = conn.Close()
+			continue
+		}
+		live = append(live, conn)
+	}
+
+	// past the hard limit, database/sql closed them already
+	hardLimit := time.Duration(float64(r.lifetime) * r.jitter * 2)
+	for driverConn, expires := range r.expires {
+		if now.Sub(expires) > hardLimit {
+			delete(r.expires, driverConn)
+		}
+	}
+}
+
+func (r *connReaper) acquire(stdCtx context.Context) (*sql.Conn, error) {
+	stdCtx, cancel := context.WithTimeout(stdCtx, connReaperAcquireTimeout)
+	defer cancel()
+	return r.sqlDB.Conn(stdCtx)
+}
+
+// EnableConnReaper logs the pool stats (and what changed) of R/W every
+// interval, and makes database/sql close the connections idle longer than
+// ConnMaxIdleTime right away instead of on its own schedule; started on
+// Connect (or right away when connected), stopped by Close; 0 -> off
+func (ctx *GormDBCtx) EnableConnReaper(interval time.Duration) *GormDBCtx {
+	ctx.connStatsInterval = max(interval, 0)
+	if ctx.R != nil {
+		ctx.startConnStats()
+	}
+	return ctx
+}
+
+func (ctx *GormDBCtx) startConnStats() {
+	ctx.stopConnStats()
+	if ctx.connStatsInterval <= 0 {
+		return
+	}
+
+	type pool struct {
+		connType string
+		sqlDB    *sql.DB
+		last     sql.DBStats
+	}
+	var pools []*pool
+	for connType, db := range map[string]*gorm.DB{"r": ctx.R, "w": ctx.W} {
+		if db == nil || (connType == "w" && ctx.W == ctx.R) {
+			continue
+		}
+		if sqlDB, err := db.DB(); err == nil {
+			pools = append(pools, &pool{connType: connType, sqlDB: sqlDB, last: sqlDB.Stats()})
+		}
+	}
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	ctx.connStatsStop, ctx.connStatsDone = stop, done
+	interval, maxIdleTime := ctx.connStatsInterval, ctx.connMaxIdleTime
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			for _, p := range pools {
+				// lowering it wakes up the cleaner of database/sql, which
+				// closes what is idle longer than the restored value
+				if maxIdleTime > time.Nanosecond {
+					p.sqlDB.SetConnMaxIdleTime(maxIdleTime - time.Nanosecond)
+					p.sqlDB.SetConnMaxIdleTime(maxIdleTime)
+				}
+
+				stats := p.sqlDB.Stats()
+				ctx.slogger().Info(ctx.ServicePrefix, "method", "conn_reaper", "conn_type", p.connType,
+					"open", stats.OpenConnections, "in_use", stats.InUse, "idle", stats.Idle,
+					"wait_count", stats.WaitCount-p.last.WaitCount,
+					"wait_duration", stats.WaitDuration-p.last.WaitDuration,
+					"max_idle_closed", stats.MaxIdleClosed-p.last.MaxIdleClosed,
+					"max_idle_time_closed", stats.MaxIdleTimeClosed-p.last.MaxIdleTimeClosed,
+					"max_lifetime_closed", stats.MaxLifetimeClosed-p.last.MaxLifetimeClosed)
+				p.last = stats
+			}
+		}
+	}()
+}
+
+func (ctx *GormDBCtx) stopConnStats() {
+	if ctx.connStatsStop == nil {
+		return
+	}
+	close(ctx.connStatsStop)
+	<-ctx.connStatsDone
+	ctx.connStatsStop = nil
+}
+
+// checkConnLifetime warns when pooled connections may stay idle longer than
+// the server keeps them (mysql wait_timeout, postgresql idle_session_timeout),
+// the server then closes connections the pool still thinks are good.
+//
+// postgresql idle_in_transaction_session_timeout only covers sessions idle
+// inside a transaction, pooled connections are never in that state.
+func (ctx *GormDBCtx) checkConnLifetime() {
+	var serverTimeout time.Duration
+	var variable string
+
+	switch ctx.DBMode {
+	case DBModeMySQL:
+		variable = "wait_timeout"
+		var seconds int64
+		if err := ctx.R.Raw("SELECT @@SESSION.wait_timeout;").Scan(&seconds).Error; err != nil {
+			ctx.slogger().Warn(ctx.ServicePrefix, "method", "check_conn_lifetime", "err", err)
+			return
+		}
+		serverTimeout = time.Duration(seconds) * time.Second
+	case DBModePostgreSQL:
+		variable = "idle_session_timeout"
+		// < 14 -> no row
+		var ms int64
+		if err := ctx.R.Raw("SELECT COALESCE((SELECT setting::bigint FROM pg_settings WHERE name = 'idle_session_timeout'), 0);").Scan(&ms).Error; err != nil {
+			ctx.slogger().Warn(ctx.ServicePrefix, "method", "check_conn_lifetime", "err", err)
+			return
+		}
+		serverTimeout = time.Duration(ms) * time.Millisecond
+	default:
+		return
+	}
+
+	if connLifetimeExceeds(ctx.connMaxLifetime, ctx.connMaxIdleTime, serverTimeout) {
+		ctx.slogger().Warn(ctx.ServicePrefix, "method", "check_conn_lifetime",
+			"err", "ConnMaxLifetime/ConnMaxIdleTime exceed the server "+variable+", set one of them below it",
+			variable, serverTimeout, "conn_max_lifetime", ctx.connMaxLifetime, "conn_max_idle_time", ctx.connMaxIdleTime)
+	}
+}
+
+// a pooled connection stays idle at most min(lifetime, idleTime), 0 -> unlimited
+func connLifetimeExceeds(lifetime, idleTime, serverTimeout time.Duration) bool {
+	if serverTimeout <= 0 {
+		return false
+	}
+
+	idleWindow := lifetime
+	if idleTime > 0 && (idleWindow == 0 || idleTime < idleWindow) {
+		idleWindow = idleTime
+	}
+
+	return idleWindow == 0 || idleWindow >= serverTimeout
+}
+
+// default of database/sql
+const defaultMaxIdleConns = 2
+
+// WarmUp opens up to n connections on each handle so the first requests
+// don't pay the connection-establishment latency, returns how many R keeps
+// open (R is W on mysql/postgresql, sqlite W keeps 1); n is capped by
+// MaxOpenConns and MaxIdleConns (left as is, the pool would close the surplus
+// once released, a warning says so); gives up when stdCtx or SetDialTimeout
+// runs out
+func (ctx *GormDBCtx) WarmUp(stdCtx context.Context, n int) (int, error) {
+	if n <= 0 {
+		return 0, nil
+	}
+	if err := ctx.ensureConnected(); err != nil {
+		return 0, err
+	}
+
+	warmCtx := stdCtx
+	if ctx.dialTimeout != nil {
+		var cancel context.CancelFunc
+		warmCtx, cancel = context.WithTimeout(warmCtx, *ctx.dialTimeout)
+		defer cancel()
+	}
+
+	maxIdleConns := ctx.maxIdleConns
+	if maxIdleConns <= 0 {
+		maxIdleConns = defaultMaxIdleConns
+	}
+	if n > maxIdleConns {
+		ctx.slogger().Warn(ctx.ServicePrefix, "method", "warm_up", "err", "capped by MaxIdleConns, raise it (SetMaxIdleConns) to keep more connections open", "n", n, "max_idle_conns", maxIdleConns)
+		n = maxIdleConns
+	}
+
+	warmed, err := warmUpDB(warmCtx, ctx.R, n)
+	if err != nil {
+		ctx.slogger().Error(ctx.ServicePrefix, "method", "warm_up", "conn_type", "r", "err", err)
+		return warmed, err
+	}
+
+	if ctx.W != ctx.R {
+		if _, err := warmUpDB(warmCtx, ctx.W, n); err != nil {
+			ctx.slogger().Error(ctx.ServicePrefix, "method", "warm_up", "conn_type", "w", "err", err)
+			return warmed, err
+		}
+	}
+
+	return warmed, nil
+}
+
+// the connections that answered the ping
+func warmUpDB(warmCtx context.Context, db *gorm.DB, n int) (int, error) {
+	if db == nil {
+		return 0, errors.New("db handle is nil")
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return 0, err
+	}
+
+	if maxOpen := sqlDB.Stats().MaxOpenConnections; maxOpen > 0 {
+		n = min(n, maxOpen)
+	}
+
+	// hold every connection until all of them are pinged, otherwise the pool
+	// hands the same connection out again
+	conns := make([]*sql.Conn, n)
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Go(func() {
+			conn, err := sqlDB.Conn(warmCtx)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			conns[i] = conn
+			errs[i] = conn.PingContext(warmCtx)
+		})
+	}
+	wg.Wait()
+
+	warmed := 0
+	for i, conn := range conns {
+		if conn != nil {
+			_ = conn.Close()
+		}
+		if errs[i] == nil {
+			warmed++
+		}
+	}
+
+	return warmed, errors.Join(errs...)
+}
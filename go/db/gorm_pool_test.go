@@ -0,0 +1,461 @@
+package db_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kdnetwork/code-snippet/go/db"
+)
+
+func TestWarmUp(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "warm_up_test.db")
+
+	ctx := new(db.GormDBCtx).SetDBPath(dbFile).SetMaxIdleConns(8)
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	if warmed, err := ctx.WarmUp(context.Background(), 8); err != nil || warmed != 8 {
+		t.Fatalf("Expected 8 warmed connections, got %d (%v)", warmed, err)
+	}
+
+	connr, _ := ctx.R.DB()
+	if open := connr.Stats().OpenConnections; open != 8 {
+		t.Errorf("Expected 8 open read connections, got %d", open)
+	}
+
+	// write handle is capped by MaxOpenConns(1)
+	connw, _ := ctx.W.DB()
+	if open := connw.Stats().OpenConnections; open != 1 {
+		t.Errorf("Expected 1 open write connection, got %d", open)
+	}
+
+	t.Run("CappedByMaxIdleConns", func(t *testing.T) {
+		var buf bytes.Buffer
+		var mu sync.Mutex
+		prev := slog.Default()
+		slog.SetDefault(slog.New(slog.NewTextHandler(&lockedWriter{mu: &mu, w: &buf}, nil)))
+		defer slog.SetDefault(prev)
+
+		ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "warm_up_capped_test.db"))
+		if err := ctx.Connect(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+		defer ctx.Close()
+
+		// database/sql keeps 2 idle connections by default
+		if warmed, err := ctx.WarmUp(context.Background(), 8); err != nil || warmed != 2 {
+			t.Errorf("Expected 2 warmed connections, got %d (%v)", warmed, err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if !strings.Contains(buf.String(), "capped by MaxIdleConns") {
+			t.Errorf("Expected a warning about the cap, got %q", buf.String())
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "warm_up_cancelled_test.db"))
+		if err := ctx.Connect(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+		defer ctx.Close()
+
+		cancelled, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := ctx.WarmUp(cancelled, 2); !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	})
+}
+
+func TestPoolConfig(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "pool_config_test.db")).SetMaxOpenConns(5).SetConnMaxLifetime(time.Minute)
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	connr, _ := ctx.R.DB()
+	connw, _ := ctx.W.DB()
+	if v := connr.Stats().MaxOpenConnections; v != 5 {
+		t.Errorf("Expected R MaxOpenConnections 5, got %d", v)
+	}
+	if v := connw.Stats().MaxOpenConnections; v != 1 {
+		t.Errorf("sqlite W must keep MaxOpenConnections 1, got %d", v)
+	}
+
+	// applied live
+	ctx.SetMaxOpenConns(7)
+	if v := connr.Stats().MaxOpenConnections; v != 7 {
+		t.Errorf("Expected R MaxOpenConnections 7 after live update, got %d", v)
+	}
+}
+
+func TestConnLifetimeExceeds(t *testing.T) {
+	const waitTimeout = 8 * time.Hour
+
+	cases := []struct {
+		name          string
+		lifetime      time.Duration
+		idleTime      time.Duration
+		serverTimeout time.Duration
+		want          bool
+	}{
+		{"ServerTimeoutDisabled", 0, 0, 0, false},
+		{"BothUnlimited", 0, 0, waitTimeout, true},
+		{"LifetimeBelow", time.Hour, 0, waitTimeout, false},
+		{"LifetimeAbove", 10 * time.Hour, 0, waitTimeout, true},
+		{"LifetimeEqual", waitTimeout, 0, waitTimeout, true},
+		{"IdleTimeBelow", 0, 5 * time.Minute, waitTimeout, false},
+		{"IdleTimeSavesLongLifetime", 10 * time.Hour, 5 * time.Minute, waitTimeout, false},
+		{"BothAbove", 10 * time.Hour, 9 * time.Hour, waitTimeout, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := db.ConnLifetimeExceeds(c.lifetime, c.idleTime, c.serverTimeout); got != c.want {
+				t.Errorf("ConnLifetimeExceeds(%v, %v, %v) = %v, want %v", c.lifetime, c.idleTime, c.serverTimeout, got, c.want)
+			}
+		})
+	}
+
+	// integration: the server default wait_timeout is 8h, an unlimited
+	// lifetime must be reported
+	t.Run("MySQLWarning", func(t *testing.T) {
+		var buf bytes.Buffer
+		prev := slog.Default()
+		slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+		defer slog.SetDefault(prev)
+
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModeMySQL).SetDBAuth(mysqlUser, mysqlPassword, mysqlHost, "mysql", "").SetCertPool(mysqlCertPool)
+		if err := ctx.Connect(); err != nil {
+			t.Skipf("Skipping wait_timeout check as server is unavailable: %v", err)
+		}
+		defer ctx.Close()
+
+		if !strings.Contains(buf.String(), "method=check_conn_lifetime") {
+			t.Errorf("Expected a check_conn_lifetime warning, got logs: %s", buf.String())
+		}
+	})
+}
+
+func TestConnMaxLifetimeJitter(t *testing.T) {
+	const conns = 6
+
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "lifetime_jitter_test.db")).
+		SetMaxIdleConns(conns).SetConnMaxLifetime(600 * time.Millisecond).SetConnMaxLifetimeJitter(0.5)
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	// opened at once, the herd the jitter breaks up
+	connr, _ := ctx.R.DB()
+	held := make([]*sql.Conn, 0, conns)
+	for range conns {
+		conn, err := connr.Conn(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to open conn: %v", err)
+		}
+		held = append(held, conn)
+	}
+	for _, conn := range held {
+		conn.Close()
+	}
+
+	// moments the number of open connections went down
+	var closedAt []time.Duration
+	start := time.Now()
+	open := conns
+	for time.Since(start) < 1200*time.Millisecond && open > 0 {
+		time.Sleep(10 * time.Millisecond)
+		if n := connr.Stats().OpenConnections; n < open {
+			closedAt = append(closedAt, time.Since(start))
+			open = n
+		}
+	}
+
+	if open > conns/2 {
+		t.Fatalf("Expected most connections to be recycled, %d/%d still open", open, conns)
+	}
+	if len(closedAt) < 3 || closedAt[len(closedAt)-1]-closedAt[0] < 100*time.Millisecond {
+		t.Errorf("connections recycled all at once: %v", closedAt)
+	}
+	// the reaper leaves the idle limit alone
+	if stats := connr.Stats(); stats.MaxIdleClosed != 0 {
+		t.Errorf("Expected no connection closed by the idle limit, got %d", stats.MaxIdleClosed)
+	}
+
+	t.Run("SetWhileConnected", func(t *testing.T) {
+		// restarts the reaper, no race with the running one
+		ctx.SetConnMaxLifetime(time.Second).SetConnMaxLifetimeJitter(0.2).SetConnMaxLifetimeJitter(0)
+		if err := connr.Ping(); err != nil {
+			t.Errorf("Ping failed: %v", err)
+		}
+	})
+}
+
+func TestAutoTunePool(t *testing.T) {
+	for _, c := range []struct {
+		cpus     int
+		wantOpen int
+		wantIdle int
+	}{
+		{1, 4, 2},
+		{4, 8, 4},
+		{16, 32, 16},
+		{128, 64, 32},
+	} {
+		open, idle := db.AutoTunePoolSize(c.cpus)
+		if open != c.wantOpen || idle != c.wantIdle {
+			t.Errorf("%d cpus: expected open %d idle %d, got open %d idle %d", c.cpus, c.wantOpen, c.wantIdle, open, idle)
+		}
+	}
+
+	t.Run("Applied", func(t *testing.T) {
+		defer db.SetNumCPU(6)()
+
+		ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "auto_tune_test.db")).AutoTunePool()
+		if err := ctx.Connect(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+		defer ctx.Close()
+
+		connr, _ := ctx.R.DB()
+		connw, _ := ctx.W.DB()
+		if v := connr.Stats().MaxOpenConnections; v != 12 {
+			t.Errorf("Expected R MaxOpenConnections 12, got %d", v)
+		}
+		if v := connw.Stats().MaxOpenConnections; v != 1 {
+			t.Errorf("sqlite W must keep MaxOpenConnections 1, got %d", v)
+		}
+	})
+}
+
+func TestAcquireTimeout(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "acquire_timeout_test.db")).SetAcquireTimeout(100 * time.Millisecond)
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	if err := ctx.W.AutoMigrate(&txTestItem{}); err != nil {
+		t.Fatalf("AutoMigrate failed: %v", err)
+	}
+
+	// free pool, the statements run on the acquired connection
+	if err := ctx.W.Create(&txTestItem{Name: "a"}).Error; err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	var items []txTestItem
+	if err := ctx.W.Find(&items).Error; err != nil || len(items) != 1 {
+		t.Fatalf("Expected 1 item, got %d (%v)", len(items), err)
+	}
+
+	// the only W connection is held by the transaction
+	tx := ctx.W.Begin()
+	if tx.Error != nil {
+		t.Fatalf("Begin failed: %v", tx.Error)
+	}
+
+	start := time.Now()
+	err := ctx.W.Create(&txTestItem{Name: "b"}).Error
+	elapsed := time.Since(start)
+	if !errors.Is(err, db.ErrAcquireTimeout) {
+		t.Errorf("Expected ErrAcquireTimeout, got %v", err)
+	}
+	if elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Expected to give up after ~100ms, took %v", elapsed)
+	}
+	if err := ctx.W.Exec("DELETE FROM tx_test_items;").Error; !errors.Is(err, db.ErrAcquireTimeout) {
+		t.Errorf("Expected ErrAcquireTimeout for Exec, got %v", err)
+	}
+
+	tx.Rollback()
+	if err := ctx.W.Create(&txTestItem{Name: "c"}).Error; err != nil {
+		t.Errorf("Create after the pool is free failed: %v", err)
+	}
+}
+
+func TestConnReaper(t *testing.T) {
+	var buf bytes.Buffer
+	var mu sync.Mutex
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&lockedWriter{mu: &mu, w: &buf}, nil)))
+	defer slog.SetDefault(prev)
+
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "conn_reaper_test.db")).
+		SetMaxIdleConns(3).SetConnMaxIdleTime(50 * time.Millisecond).EnableConnReaper(20 * time.Millisecond)
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+
+	done := db.ConnReaperDone(ctx)
+	if done == nil {
+		t.Fatal("reaper should start on Connect")
+	}
+
+	connr, _ := ctx.R.DB()
+	var held []*sql.Conn
+	for range 3 {
+		conn, err := connr.Conn(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to open conn: %v", err)
+		}
+		held = append(held, conn)
+	}
+	for _, conn := range held {
+		conn.Close()
+	}
+
+	// the cleaner of database/sql alone runs at most once per second
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for connr.Stats().MaxIdleTimeClosed < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := connr.Stats().MaxIdleTimeClosed; n < 3 {
+		t.Errorf("Expected the idle connections to be closed, got %d", n)
+	}
+
+	mu.Lock()
+	logs := buf.String()
+	mu.Unlock()
+	if !strings.Contains(logs, "method=conn_reaper") || !strings.Contains(logs, "conn_type=r") {
+		t.Errorf("Expected conn_reaper stats in the logs, got: %s", logs)
+	}
+
+	select {
+	case <-done:
+		t.Fatal("reaper exited before Close")
+	default:
+	}
+
+	ctx.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("reaper still running after Close")
+	}
+}
+
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  *bytes.Buffer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+func TestPoolPreset(t *testing.T) {
+	for _, c := range []struct {
+		shape    string
+		open     int
+		idle     int
+		lifetime time.Duration
+		idleTime time.Duration
+	}{
+		{"web", 25, 10, 5 * time.Minute, 2 * time.Minute},
+		{"batch", 64, 64, time.Hour, 30 * time.Minute},
+		{"Serverless", 5, 1, time.Minute, 10 * time.Second},
+	} {
+		t.Run(c.shape, func(t *testing.T) {
+			ctx := new(db.GormDBCtx).PoolPreset(c.shape)
+
+			open, idle := db.PoolSizes(ctx)
+			lifetime, idleTime := db.PoolLifetimes(ctx)
+			if open != c.open || idle != c.idle || lifetime != c.lifetime || idleTime != c.idleTime {
+				t.Errorf("Expected open %d idle %d lifetime %v idle time %v, got %d %d %v %v", c.open, c.idle, c.lifetime, c.idleTime, open, idle, lifetime, idleTime)
+			}
+		})
+	}
+
+	t.Run("Applied", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "pool_preset_test.db")).PoolPreset("serverless")
+		if err := ctx.Connect(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+		defer ctx.Close()
+
+		connr, _ := ctx.R.DB()
+		connw, _ := ctx.W.DB()
+		if v := connr.Stats().MaxOpenConnections; v != 5 {
+			t.Errorf("Expected R MaxOpenConnections 5, got %d", v)
+		}
+		if v := connw.Stats().MaxOpenConnections; v != 1 {
+			t.Errorf("sqlite W must keep MaxOpenConnections 1, got %d", v)
+		}
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetMaxOpenConns(3).PoolPreset("desktop")
+		if open, _ := db.PoolSizes(ctx); open != 3 {
+			t.Errorf("Unknown shape must not change the pool, got MaxOpenConns %d", open)
+		}
+	})
+}
+
+func TestCloudPreset(t *testing.T) {
+	for _, c := range []struct {
+		provider   string
+		lifetime   time.Duration
+		idleTime   time.Duration
+		keepalives string
+	}{
+		{"rds", 15 * time.Minute, 5 * time.Minute, "60"},
+		{"cloudsql", 30 * time.Minute, 9 * time.Minute, "60"},
+		{"Azure", 15 * time.Minute, 3 * time.Minute, "30"},
+	} {
+		t.Run(c.provider, func(t *testing.T) {
+			ctx := new(db.GormDBCtx).SetDBMode(db.DBModePostgreSQL).SetDBAuth("user", "pw", "db.internal:5432", "app", "require").CloudPreset(c.provider)
+
+			lifetime, idleTime := db.PoolLifetimes(ctx)
+			if lifetime != c.lifetime || idleTime != c.idleTime {
+				t.Errorf("Expected lifetime %v idle %v, got %v %v", c.lifetime, c.idleTime, lifetime, idleTime)
+			}
+
+			pgxConfig, err := db.AuthPostgreSQLConfig(ctx)
+			if err != nil {
+				t.Fatalf("PostgreSQLConfig failed: %v", err)
+			}
+			for _, param := range []string{"keepalives_idle=" + c.keepalives, "keepalives_interval=" + c.keepalives} {
+				if !strings.Contains(pgxConfig.ConnString(), param) {
+					t.Errorf("Expected %s in the DSN, got %s", param, pgxConfig.ConnString())
+				}
+			}
+		})
+	}
+
+	t.Run("KeepsPostgresParams", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModePostgreSQL).SetDBAuth("user", "pw", "db.internal:5432", "app", "require").
+			SetPostgresParams(map[string]string{"keepalives_idle": "10"}).CloudPreset("rds")
+
+		pgxConfig, err := db.AuthPostgreSQLConfig(ctx)
+		if err != nil {
+			t.Fatalf("PostgreSQLConfig failed: %v", err)
+		}
+		if dsn := pgxConfig.ConnString(); !strings.Contains(dsn, "keepalives_idle=10") || !strings.Contains(dsn, "keepalives_interval=60") {
+			t.Errorf("Expected keepalives_idle from SetPostgresParams and keepalives_interval from the preset, got %s", dsn)
+		}
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetConnMaxLifetime(time.Hour).CloudPreset("heroku")
+		if lifetime, _ := db.PoolLifetimes(ctx); lifetime != time.Hour {
+			t.Errorf("Unknown provider must not change the pool, got lifetime %v", lifetime)
+		}
+	})
+}
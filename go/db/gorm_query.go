@@ -0,0 +1,242 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// QuoteIdentifier quotes a single identifier (table, column, database...) for
+// the current DBMode: `name` for mysql, "name" for postgresql/sqlite, embedded
+// quote chars are doubled
+func (ctx *GormDBCtx) QuoteIdentifier(name string) string {
+	quote := `"`
+	if ctx.DBMode == DBModeMySQL {
+		quote = "`"
+	}
+
+	return quote + strings.ReplaceAll(name, quote, quote+quote) + quote
+}
+
+// CountRows runs SELECT COUNT(*) on R, table is quoted as a single
+// identifier (QuoteIdentifier) so it can come from config
+func (ctx *GormDBCtx) CountRows(stdCtx context.Context, table string) (int64, error) {
+	if err := ctx.ensureConnected(); err != nil {
+		return 0, err
+	}
+
+	var count int64
+	err := ctx.R.WithContext(stdCtx).Raw("SELECT COUNT(*) FROM " + ctx.QuoteIdentifier(table) + ";").Scan(&count).Error
+	return count, err
+}
+
+// RawNamed runs query with @name placeholders filled from params, the same
+// way on every dialect; dest != nil -> Scan on R, dest == nil -> Exec on W
+func (ctx *GormDBCtx) RawNamed(stdCtx context.Context, query string, params map[string]any, dest any) error {
+	if err := ctx.ensureConnected(); err != nil {
+		return err
+	}
+
+	// an unused map would be bound as a positional arg
+	var args []any
+	if len(params) > 0 {
+		args = append(args, params)
+	}
+
+	if dest == nil {
+		return ctx.W.WithContext(stdCtx).Exec(query, args...).Error
+	}
+	return ctx.R.WithContext(stdCtx).Raw(query, args...).Scan(dest).Error
+}
+
+// StreamRows runs query on R and calls fn for each row as it's read, so
+// memory doesn't grow with the result; row maps column -> value as returned
+// by the driver (mysql returns text as []byte), a new map every row; an error
+// from fn stops the iteration and is returned as is
+func (ctx *GormDBCtx) StreamRows(stdCtx context.Context, query string, args []any, fn func(row map[string]any) error) error {
+	if err := ctx.ensureConnected(); err != nil {
+		return err
+	}
+
+	rows, err := ctx.R.WithContext(stdCtx).Raw(query, args...).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	values := make([]any, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		row := make(map[string]any, len(columns))
+		for i, column := range columns {
+			row[column] = values[i]
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// InsertReturningID runs an INSERT on W and returns the generated id
+//
+// mysql/sqlite -> LAST_INSERT_ID()/last_insert_rowid() on the same connection
+// postgresql -> appends RETURNING id, the column must be named id
+func (ctx *GormDBCtx) InsertReturningID(stdCtx context.Context, query string, args ...any) (int64, error) {
+	if err := ctx.ensureConnected(); err != nil {
+		return 0, err
+	}
+
+	var id int64
+
+	var lastIDQuery string
+	switch ctx.DBMode {
+	case DBModePostgreSQL:
+		query = strings.TrimRight(strings.TrimSpace(query), ";") + " RETURNING id"
+		err := ctx.W.WithContext(stdCtx).Raw(query, args...).Scan(&id).Error
+		return id, err
+	case DBModeMySQL:
+		lastIDQuery = "SELECT LAST_INSERT_ID();"
+	case DBModeSQLite:
+		lastIDQuery = "SELECT last_insert_rowid();"
+	default:
+		return 0, ErrNotSupported
+	}
+
+	err := ctx.W.WithContext(stdCtx).Connection(func(tx *gorm.DB) error {
+		if err := tx.Exec(query, args...).Error; err != nil {
+			return err
+		}
+		return tx.Raw(lastIDQuery).Scan(&id).Error
+	})
+	return id, err
+}
+
+type ExplainOptions struct {
+	// runs the query (EXPLAIN ANALYZE), mysql 8.0.18+/postgresql
+	Analyze bool
+	// FORMAT JSON, mysql/postgresql
+	JSON bool
+}
+
+// Explain returns the execution plan of query on R
+//
+// sqlite -> EXPLAIN QUERY PLAN as an indented tree
+// mysql/postgresql -> one line per plan row, columns separated by tabs
+func (ctx *GormDBCtx) Explain(stdCtx context.Context, query string, args ...any) (string, error) {
+	return ctx.ExplainWith(stdCtx, ExplainOptions{}, query, args...)
+}
+
+func (ctx *GormDBCtx) ExplainWith(stdCtx context.Context, opts ExplainOptions, query string, args ...any) (string, error) {
+	if err := ctx.ensureConnected(); err != nil {
+		return "", err
+	}
+
+	var prefix string
+	switch ctx.DBMode {
+	case DBModeSQLite:
+		if opts.Analyze || opts.JSON {
+			return "", ErrNotSupported
+		}
+		return ctx.sqliteExplain(stdCtx, query, args...)
+	case DBModeMySQL:
+		switch {
+		case opts.Analyze && opts.JSON:
+			// EXPLAIN ANALYZE only supports FORMAT=TREE
+			return "", ErrNotSupported
+		case opts.Analyze:
+			prefix = "EXPLAIN ANALYZE "
+		case opts.JSON:
+			prefix = "EXPLAIN FORMAT=JSON "
+		default:
+			prefix = "EXPLAIN "
+		}
+	case DBModePostgreSQL:
+		var explainOpts []string
+		if opts.Analyze {
+			explainOpts = append(explainOpts, "ANALYZE")
+		}
+		if opts.JSON {
+			explainOpts = append(explainOpts, "FORMAT JSON")
+		}
+		prefix = "EXPLAIN "
+		if len(explainOpts) > 0 {
+			prefix += "(" + strings.Join(explainOpts, ", ") + ") "
+		}
+	default:
+		return "", ErrNotSupported
+	}
+
+	rows, err := ctx.R.WithContext(stdCtx).Raw(prefix+query, args...).Rows()
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+
+	var lines []string
+	if len(columns) > 1 {
+		lines = append(lines, strings.Join(columns, "\t"))
+	}
+
+	values := make([]sql.NullString, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return "", err
+		}
+		fields := make([]string, len(values))
+		for i, v := range values {
+			if v.Valid {
+				fields[i] = v.String
+			} else {
+				fields[i] = "NULL"
+			}
+		}
+		lines = append(lines, strings.Join(fields, "\t"))
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func (ctx *GormDBCtx) sqliteExplain(stdCtx context.Context, query string, args ...any) (string, error) {
+	var plan []struct {
+		ID     int
+		Parent int
+		Detail string
+	}
+	if err := ctx.R.WithContext(stdCtx).Raw("EXPLAIN QUERY PLAN "+query, args...).Scan(&plan).Error; err != nil {
+		return "", err
+	}
+
+	depth := map[int]int{0: -1}
+	lines := make([]string, 0, len(plan))
+	for _, row := range plan {
+		depth[row.ID] = depth[row.Parent] + 1
+		lines = append(lines, strings.Repeat("  ", max(depth[row.ID], 0))+row.Detail)
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,264 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kdnetwork/code-snippet/go/db"
+)
+
+func TestQuoteIdentifier(t *testing.T) {
+	cases := []struct {
+		mode string
+		name string
+		want string
+	}{
+		{db.DBModeMySQL, "users", "`users`"},
+		{db.DBModeMySQL, "we`ird", "`we``ird`"},
+		{db.DBModeMySQL, `dou"ble`, "`dou\"ble`"},
+		{db.DBModePostgreSQL, "users", `"users"`},
+		{db.DBModePostgreSQL, `we"ird`, `"we""ird"`},
+		{db.DBModePostgreSQL, "back`tick", "\"back`tick\""},
+		{db.DBModeSQLite, "users", `"users"`},
+		{db.DBModeSQLite, `x"; DROP TABLE users; --`, `"x""; DROP TABLE users; --"`},
+	}
+
+	for _, c := range cases {
+		ctx := new(db.GormDBCtx).SetDBMode(c.mode)
+		if got := ctx.QuoteIdentifier(c.name); got != c.want {
+			t.Errorf("[%s] QuoteIdentifier(%q) = %s, want %s", c.mode, c.name, got, c.want)
+		}
+	}
+
+	t.Run("SQLiteRoundTrip", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "quote_test.db"))
+		if err := ctx.Connect(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+		defer ctx.Close()
+
+		table := `odd "table"; name`
+		if err := ctx.W.Exec("CREATE TABLE " + ctx.QuoteIdentifier(table) + " (id INTEGER PRIMARY KEY);").Error; err != nil {
+			t.Fatalf("Create table with quoted name failed: %v", err)
+		}
+
+		var name string
+		if err := ctx.R.Raw("SELECT name FROM sqlite_master WHERE type = 'table';").Scan(&name).Error; err != nil {
+			t.Fatalf("Query sqlite_master failed: %v", err)
+		}
+		if name != table {
+			t.Errorf("Expected table %q, got %q", table, name)
+		}
+	})
+}
+
+func TestExplain(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "explain_test.db"))
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	for _, statement := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, age INTEGER)",
+		"CREATE INDEX idx_users_name ON users (name)",
+	} {
+		if err := ctx.W.Exec(statement).Error; err != nil {
+			t.Fatalf("Failed to create schema: %v", err)
+		}
+	}
+
+	plan, err := ctx.Explain(context.Background(), "SELECT * FROM users WHERE name = ?", "alice")
+	if err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	if !strings.Contains(plan, "idx_users_name") {
+		t.Errorf("Expected the plan to use idx_users_name, got %q", plan)
+	}
+
+	plan, err = ctx.Explain(context.Background(), "SELECT * FROM users WHERE age > 18 ORDER BY age")
+	if err != nil || strings.TrimSpace(plan) == "" {
+		t.Errorf("Expected a non-empty plan, got %q (%v)", plan, err)
+	}
+
+	if _, err := ctx.ExplainWith(context.Background(), db.ExplainOptions{Analyze: true}, "SELECT 1"); !errors.Is(err, db.ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported for EXPLAIN ANALYZE on sqlite, got %v", err)
+	}
+}
+
+func TestCountRows(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "count_rows_test.db"))
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	for _, statement := range []string{
+		"CREATE TABLE empty (id INTEGER PRIMARY KEY)",
+		`CREATE TABLE "odd ""name""" (id INTEGER PRIMARY KEY)`,
+		`INSERT INTO "odd ""name""" (id) VALUES (1), (2), (3)`,
+	} {
+		if err := ctx.W.Exec(statement).Error; err != nil {
+			t.Fatalf("Failed to prepare tables: %v", err)
+		}
+	}
+
+	if n, err := ctx.CountRows(context.Background(), "empty"); err != nil || n != 0 {
+		t.Errorf("Expected 0 rows, got %d (%v)", n, err)
+	}
+	if n, err := ctx.CountRows(context.Background(), `odd "name"`); err != nil || n != 3 {
+		t.Errorf("Expected 3 rows, got %d (%v)", n, err)
+	}
+
+	t.Run("Injection", func(t *testing.T) {
+		for _, table := range []string{
+			`empty; DROP TABLE empty; --`,
+			`empty" ; DROP TABLE empty; --`,
+			`empty WHERE 1=1 UNION SELECT 42`,
+		} {
+			if _, err := ctx.CountRows(context.Background(), table); err == nil {
+				t.Errorf("%q should be treated as an unknown table", table)
+			}
+		}
+
+		if n, err := ctx.CountRows(context.Background(), "empty"); err != nil || n != 0 {
+			t.Errorf("table empty should survive, got %d (%v)", n, err)
+		}
+	})
+}
+
+func TestStreamRows(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "stream_rows_test.db"))
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	const total = 10000
+	for _, statement := range []string{
+		"CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT, note TEXT)",
+		"WITH RECURSIVE seq(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM seq WHERE n < 10000) INSERT INTO items (id, name) SELECT n, 'item ' || n FROM seq",
+	} {
+		if err := ctx.W.Exec(statement).Error; err != nil {
+			t.Fatalf("Failed to prepare table: %v", err)
+		}
+	}
+
+	var calls int
+	err := ctx.StreamRows(context.Background(), "SELECT id, name, note FROM items WHERE id > ? ORDER BY id", []any{0}, func(row map[string]any) error {
+		calls++
+		if row["id"] != int64(calls) || row["name"] != "item "+strconv.Itoa(calls) || row["note"] != nil {
+			return fmt.Errorf("unexpected row %d: %v", calls, row)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamRows failed: %v", err)
+	}
+	if calls != total {
+		t.Errorf("Expected fn to be called %d times, got %d", total, calls)
+	}
+
+	t.Run("EarlyExit", func(t *testing.T) {
+		errEnough := errors.New("enough")
+
+		var calls int
+		err := ctx.StreamRows(context.Background(), "SELECT id FROM items ORDER BY id", nil, func(row map[string]any) error {
+			calls++
+			if calls == 10 {
+				return errEnough
+			}
+			return nil
+		})
+		if !errors.Is(err, errEnough) {
+			t.Errorf("Expected the error of fn, got %v", err)
+		}
+		if calls != 10 {
+			t.Errorf("Expected to stop after 10 rows, got %d", calls)
+		}
+
+		// the connection went back to the pool
+		if n, err := ctx.CountRows(context.Background(), "items"); err != nil || n != total {
+			t.Errorf("Expected %d rows, got %d (%v)", total, n, err)
+		}
+	})
+
+	t.Run("QueryError", func(t *testing.T) {
+		err := ctx.StreamRows(context.Background(), "SELECT * FROM missing", nil, func(row map[string]any) error {
+			t.Error("fn should not be called")
+			return nil
+		})
+		if err == nil {
+			t.Error("Expected an error for a missing table")
+		}
+	})
+}
+
+func TestRawNamed(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "raw_named_test.db"))
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	if err := ctx.RawNamed(context.Background(), "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, age INTEGER);", nil, nil); err != nil {
+		t.Fatalf("Create table failed: %v", err)
+	}
+	for _, u := range []map[string]any{
+		{"name": "alice", "age": 30},
+		{"name": "bob", "age": 17},
+		{"name": "carol", "age": 45},
+	} {
+		if err := ctx.RawNamed(context.Background(), "INSERT INTO users (name, age) VALUES (@name, @age);", u, nil); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	var names []string
+	err := ctx.RawNamed(context.Background(), "SELECT name FROM users WHERE age >= @min_age AND name != @excluded ORDER BY name;",
+		map[string]any{"min_age": 18, "excluded": "carol"}, &names)
+	if err != nil {
+		t.Fatalf("RawNamed failed: %v", err)
+	}
+	if !slices.Equal(names, []string{"alice"}) {
+		t.Errorf("Expected [alice], got %v", names)
+	}
+
+	// the same name used twice
+	var count int64
+	if err := ctx.RawNamed(context.Background(), "SELECT COUNT(*) FROM users WHERE age > @age OR age = @age;", map[string]any{"age": 30}, &count); err != nil || count != 2 {
+		t.Errorf("Expected 2, got %d (%v)", count, err)
+	}
+}
+
+func TestInsertReturningID(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "insert_returning_id_test.db"))
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	if err := ctx.W.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT);").Error; err != nil {
+		t.Fatalf("Create table failed: %v", err)
+	}
+
+	for want := int64(1); want <= 3; want++ {
+		id, err := ctx.InsertReturningID(context.Background(), "INSERT INTO items (name) VALUES (?);", "item")
+		if err != nil {
+			t.Fatalf("InsertReturningID failed: %v", err)
+		}
+		if id != want {
+			t.Errorf("Expected id %d, got %d", want, id)
+		}
+	}
+
+	if _, err := ctx.InsertReturningID(context.Background(), "INSERT INTO missing (name) VALUES (?);", "item"); err == nil {
+		t.Error("Expected an error for a missing table")
+	}
+}
@@ -0,0 +1,41 @@
+//go:build cgo
+
+package db_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/kdnetwork/code-snippet/go/db"
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestWithRawConnSQLiteFunc(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "raw_conn_func_test.db"))
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	// W holds a single connection, the function stays registered on it
+	err := ctx.WithRawConn(context.Background(), func(driverConn any) error {
+		conn, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver conn %T", driverConn)
+		}
+		return conn.RegisterFunc("kd_double", func(n int64) int64 { return n * 2 }, true)
+	})
+	if err != nil {
+		t.Fatalf("WithRawConn failed: %v", err)
+	}
+
+	var got int64
+	if err := ctx.W.Raw("SELECT kd_double(21);").Scan(&got).Error; err != nil {
+		t.Fatalf("Query with the custom function failed: %v", err)
+	}
+	if got != 42 {
+		t.Errorf("Expected 42, got %d", got)
+	}
+}
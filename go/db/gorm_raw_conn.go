@@ -0,0 +1,27 @@
+package db
+
+import "context"
+
+// WithRawConn hands fn the driver connection of a connection of W (via
+// sql.Conn.Raw), for what gorm doesn't expose: sqlite custom functions,
+// backup, pgx LISTEN... e.g. *sqlite3.SQLiteConn (cgo), *stdlib.Conn
+// (postgresql), the connection goes back to the pool once fn returns and
+// must not be used after
+func (ctx *GormDBCtx) WithRawConn(stdCtx context.Context, fn func(driverConn any) error) error {
+	if err := ctx.ensureConnected(); err != nil {
+		return err
+	}
+
+	sqlDB, err := ctx.W.DB()
+	if err != nil {
+		return err
+	}
+
+	conn, err := sqlDB.Conn(stdCtx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(fn)
+}
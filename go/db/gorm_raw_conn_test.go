@@ -0,0 +1,34 @@
+package db_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/kdnetwork/code-snippet/go/db"
+)
+
+func TestWithRawConn(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "raw_conn_test.db"))
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	err := ctx.WithRawConn(context.Background(), func(driverConn any) error {
+		if _, ok := driverConn.(driver.Conn); !ok {
+			t.Errorf("Expected a driver.Conn, got %T", driverConn)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithRawConn failed: %v", err)
+	}
+
+	errFn := errors.New("fn failed")
+	if err := ctx.WithRawConn(context.Background(), func(any) error { return errFn }); !errors.Is(err, errFn) {
+		t.Errorf("Expected the error of fn, got %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package db
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+type readFromWriteKey struct{}
+
+// RouteReadToWrite marks stdCtx so DB(stdCtx) returns W instead of R: reads
+// right after a write see it even when R is a lagging replica (or the
+// separate sqlite read pool)
+func RouteReadToWrite(stdCtx context.Context) context.Context {
+	return context.WithValue(stdCtx, readFromWriteKey{}, true)
+}
+
+func readsFromWrite(stdCtx context.Context) bool {
+	marked, _ := stdCtx.Value(readFromWriteKey{}).(bool)
+	return marked
+}
+
+// DB returns the handle to read with, bound to stdCtx (WithContext): R, or W
+// when stdCtx comes from RouteReadToWrite; nil when not connected
+func (ctx *GormDBCtx) DB(stdCtx context.Context) *gorm.DB {
+	if ctx.ensureConnected() != nil {
+		return nil
+	}
+	if readsFromWrite(stdCtx) {
+		return ctx.W.WithContext(stdCtx)
+	}
+	return ctx.R.WithContext(stdCtx)
+}
@@ -0,0 +1,56 @@
+package db_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/kdnetwork/code-snippet/go/db"
+)
+
+func TestRouteReadToWrite(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "route_read_to_write_test.db"))
+	if ctx.DB(context.Background()) != nil {
+		t.Error("Expected nil before Connect")
+	}
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	if !ctx.HasSeparateReadWrite() {
+		t.Fatal("Expected separate R/W handles on sqlite")
+	}
+
+	plain := context.Background()
+	if got := ctx.DB(plain); got.Statement.ConnPool != ctx.R.Statement.ConnPool {
+		t.Error("Expected an unmarked context to read from R")
+	}
+
+	marked := db.RouteReadToWrite(plain)
+	got := ctx.DB(marked)
+	if got.Statement.ConnPool != ctx.W.Statement.ConnPool {
+		t.Error("Expected a marked context to read from W")
+	}
+	if got.Statement.Context != marked {
+		t.Error("Expected the handle to carry the context")
+	}
+
+	// derived contexts keep the marker
+	child, cancel := context.WithCancel(marked)
+	defer cancel()
+	if ctx.DB(child).Statement.ConnPool != ctx.W.Statement.ConnPool {
+		t.Error("Expected a context derived from a marked one to read from W")
+	}
+
+	if err := ctx.W.Exec("CREATE TABLE route_test (id INTEGER PRIMARY KEY);").Error; err != nil {
+		t.Fatalf("Create table failed: %v", err)
+	}
+	if err := ctx.W.WithContext(marked).Exec("INSERT INTO route_test (id) VALUES (1);").Error; err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	var count int64
+	if err := ctx.DB(marked).Raw("SELECT COUNT(*) FROM route_test;").Scan(&count).Error; err != nil || count != 1 {
+		t.Errorf("Expected to read the write back, got %d (err %v)", count, err)
+	}
+}
@@ -0,0 +1,417 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+// DumpSchema returns the DDL of the current database (R), one statement per
+// object separated by a blank line
+//
+// sqlite -> sqlite_master.sql (tables, indexes, views, triggers)
+// mysql -> SHOW CREATE TABLE for each base table
+// postgresql -> rebuilt from the catalog (columns, constraints, indexes) of
+// the tables in current_schema(), not a full pg_dump
+func (ctx *GormDBCtx) DumpSchema() (string, error) {
+	if err := ctx.ensureConnected(); err != nil {
+		return "", err
+	}
+
+	var statements []string
+	var err error
+
+	switch ctx.DBMode {
+	case DBModeSQLite:
+		err = ctx.R.Raw(`SELECT sql FROM sqlite_master WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%'
+			ORDER BY CASE type WHEN 'table' THEN 0 WHEN 'index' THEN 1 WHEN 'view' THEN 2 ELSE 3 END, name;`).Scan(&statements).Error
+	case DBModeMySQL:
+		statements, err = ctx.mysqlDumpSchema()
+	case DBModePostgreSQL:
+		statements, err = ctx.postgreSQLDumpSchema()
+	default:
+		return "", ErrNotSupported
+	}
+	if err != nil {
+		return "", err
+	}
+
+	for i, statement := range statements {
+		statements[i] = strings.TrimSuffix(strings.TrimSpace(statement), ";") + ";"
+	}
+	return strings.Join(statements, "\n\n"), nil
+}
+
+func (ctx *GormDBCtx) mysqlDumpSchema() ([]string, error) {
+	var tables []string
+	if err := ctx.R.Raw("SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE' ORDER BY table_name;").Scan(&tables).Error; err != nil {
+		return nil, err
+	}
+
+	statements := make([]string, 0, len(tables))
+	for _, table := range tables {
+		var name, statement string
+		if err := ctx.R.Raw("SHOW CREATE TABLE "+ctx.QuoteIdentifier(table)+";").Row().Scan(&name, &statement); err != nil {
+			return nil, err
+		}
+		statements = append(statements, statement)
+	}
+	return statements, nil
+}
+
+func (ctx *GormDBCtx) postgreSQLDumpSchema() ([]string, error) {
+	var tables []string
+	if err := ctx.R.Raw("SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema() AND table_type = 'BASE TABLE' ORDER BY table_name;").Scan(&tables).Error; err != nil {
+		return nil, err
+	}
+
+	var statements []string
+	for _, table := range tables {
+		regclass := ctx.QuoteIdentifier(table)
+
+		var columns []struct {
+			Name    string
+			Type    string
+			NotNull bool
+			Default sql.NullString
+		}
+		if err := ctx.R.Raw(`SELECT a.attname AS name, format_type(a.atttypid, a.atttypmod) AS type, a.attnotnull AS not_null, pg_get_expr(d.adbin, d.adrelid) AS "default"
+			FROM pg_attribute a LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+			WHERE a.attrelid = ?::regclass AND a.attnum > 0 AND NOT a.attisdropped ORDER BY a.attnum;`, regclass).Scan(&columns).Error; err != nil {
+			return nil, err
+		}
+
+		var constraints []struct {
+			Name       string
+			Definition string
+		}
+		if err := ctx.R.Raw("SELECT conname AS name, pg_get_constraintdef(oid) AS definition FROM pg_constraint WHERE conrelid = ?::regclass ORDER BY contype, conname;", regclass).Scan(&constraints).Error; err != nil {
+			return nil, err
+		}
+
+		lines := make([]string, 0, len(columns)+len(constraints))
+		for _, column := range columns {
+			line := "  " + ctx.QuoteIdentifier(column.Name) + " " + column.Type
+			if column.NotNull {
+				line += " NOT NULL"
+			}
+			if column.Default.Valid {
+				line += " DEFAULT " + column.Default.String
+			}
+			lines = append(lines, line)
+		}
+		for _, constraint := range constraints {
+			lines = append(lines, "  CONSTRAINT "+ctx.QuoteIdentifier(constraint.Name)+" "+constraint.Definition)
+		}
+		statements = append(statements, "CREATE TABLE "+regclass+" (\n"+strings.Join(lines, ",\n")+"\n)")
+
+		// indexes backing a constraint are already covered
+		var indexes []string
+		if err := ctx.R.Raw(`SELECT indexdef FROM pg_indexes WHERE schemaname = current_schema() AND tablename = ?
+			AND indexname NOT IN (SELECT conname FROM pg_constraint WHERE conrelid = ?::regclass) ORDER BY indexname;`, table, regclass).Scan(&indexes).Error; err != nil {
+			return nil, err
+		}
+		statements = append(statements, indexes...)
+	}
+	return statements, nil
+}
+
+// MigrationDiff returns the statements AutoMigrate(models...) would run on W,
+// without running them: the migrator reads the live schema as usual but its
+// DDL is recorded instead of executed, nil -> the schema matches the models
+func (ctx *GormDBCtx) MigrationDiff(models ...any) ([]string, error) {
+	if err := ctx.ensureConnected(); err != nil {
+		return nil, err
+	}
+
+	pool := &dryRunConnPool{ConnPool: ctx.W.Statement.ConnPool, dialector: ctx.W.Dialector}
+
+	// a Context makes Session clone the Statement, the pool must not leak into W
+	tx := ctx.W.Session(&gorm.Session{NewDB: true, Context: context.Background()})
+	tx.Statement.ConnPool = pool
+	if err := tx.Migrator().AutoMigrate(models...); err != nil {
+		return nil, err
+	}
+	return pool.statements, nil
+}
+
+// queries go to the database, Exec is only recorded
+type dryRunConnPool struct {
+	gorm.ConnPool
+	dialector  gorm.Dialector
+	statements []string
+}
+
+func (p *dryRunConnPool) ExecContext(_ context.Context, query string, args ...any) (sql.Result, error) {
+	p.statements = append(p.statements, p.dialector.Explain(query, args...))
+	return driver.RowsAffected(0), nil
+}
+
+// the sqlite migrator recreates tables in a transaction
+func (p *dryRunConnPool) BeginTx(context.Context, *sql.TxOptions) (gorm.ConnPool, error) {
+	return &dryRunTx{p}, nil
+}
+
+type dryRunTx struct {
+	*dryRunConnPool
+}
+
+func (*dryRunTx) Commit() error   { return nil }
+func (*dryRunTx) Rollback() error { return nil }
+
+type IndexInfo struct {
+	Name string
+	// in index order, "" for an expression
+	Columns []string
+	// primary keys included
+	Unique bool
+}
+
+// ListIndexes returns the indexes of table on R, ordered by name
+//
+// sqlite -> pragma_index_list/pragma_index_info
+// mysql -> information_schema.statistics of DATABASE()
+// postgresql -> pg_index, table is resolved through the search_path
+func (ctx *GormDBCtx) ListIndexes(table string) ([]IndexInfo, error) {
+	if err := ctx.ensureConnected(); err != nil {
+		return nil, err
+	}
+
+	var query string
+	args := []any{table}
+	switch ctx.DBMode {
+	case DBModeSQLite:
+		query = `SELECT il.name AS name, il."unique" AS is_unique, ii.name AS column_name
+			FROM pragma_index_list(?) il, pragma_index_info(il.name) ii ORDER BY il.name, ii.seqno;`
+	case DBModeMySQL:
+		query = `SELECT index_name AS name, non_unique = 0 AS is_unique, column_name
+			FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = ? ORDER BY index_name, seq_in_index;`
+	case DBModePostgreSQL:
+		query = `SELECT i.relname AS name, x.indisunique AS is_unique, a.attname AS column_name
+			FROM pg_index x JOIN pg_class i ON i.oid = x.indexrelid
+			CROSS JOIN LATERAL unnest(x.indkey::int2[]) WITH ORDINALITY AS k(attnum, ord)
+			LEFT JOIN pg_attribute a ON a.attrelid = x.indrelid AND a.attnum = k.attnum
+			WHERE x.indrelid = ?::regclass ORDER BY i.relname, k.ord;`
+		args = []any{ctx.QuoteIdentifier(table)}
+	default:
+		return nil, ErrNotSupported
+	}
+
+	var rows []struct {
+		Name       string
+		IsUnique   bool
+		ColumnName sql.NullString
+	}
+	if err := ctx.R.Raw(query, args...).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	var indexes []IndexInfo
+	for _, row := range rows {
+		if len(indexes) == 0 || indexes[len(indexes)-1].Name != row.Name {
+			indexes = append(indexes, IndexInfo{Name: row.Name, Unique: row.IsUnique})
+		}
+		last := &indexes[len(indexes)-1]
+		last.Columns = append(last.Columns, row.ColumnName.String)
+	}
+	return indexes, nil
+}
+
+// CreateIndexIfNotExists creates the index name on table (columns) on W, a
+// no-op when an index with that name already exists (its definition isn't
+// compared); identifiers are quoted (QuoteIdentifier)
+//
+// sqlite/postgresql -> CREATE INDEX IF NOT EXISTS
+// mysql -> ListIndexes first, losing a race to another creation (1061) is a
+// no-op too
+func (ctx *GormDBCtx) CreateIndexIfNotExists(table, name string, columns []string, unique bool) error {
+	if err := ctx.ensureConnected(); err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		return errors.New("create index: no columns")
+	}
+
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = ctx.QuoteIdentifier(column)
+	}
+
+	statement := "CREATE INDEX "
+	if unique {
+		statement = "CREATE UNIQUE INDEX "
+	}
+
+	switch ctx.DBMode {
+	case DBModeSQLite, DBModePostgreSQL:
+		statement += "IF NOT EXISTS "
+	case DBModeMySQL:
+		indexes, err := ctx.ListIndexes(table)
+		if err != nil {
+			return err
+		}
+		if slices.ContainsFunc(indexes, func(index IndexInfo) bool { return index.Name == name }) {
+			return nil
+		}
+	default:
+		return ErrNotSupported
+	}
+
+	statement += ctx.QuoteIdentifier(name) + " ON " + ctx.QuoteIdentifier(table) + " (" + strings.Join(quoted, ", ") + ");"
+	err := ctx.W.Exec(statement).Error
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateIndex {
+		return nil
+	}
+	return err
+}
+
+// TruncateTables empties tables on W and resets their auto-increment
+// counters, tables are quoted as single identifiers (QuoteIdentifier)
+//
+// postgresql -> TRUNCATE ... RESTART IDENTITY CASCADE, tables referencing them
+// are emptied too
+// mysql -> TRUNCATE TABLE each, foreign key checks off on the connection
+// meanwhile, not atomic (TRUNCATE commits)
+// sqlite -> DELETE FROM each and their sqlite_sequence rows in one
+// transaction, foreign keys are checked at commit
+func (ctx *GormDBCtx) TruncateTables(stdCtx context.Context, tables ...string) error {
+	if err := ctx.ensureConnected(); err != nil {
+		return err
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+
+	quoted := make([]string, len(tables))
+	for i, table := range tables {
+		quoted[i] = ctx.QuoteIdentifier(table)
+	}
+
+	switch ctx.DBMode {
+	case DBModePostgreSQL:
+		return ctx.W.WithContext(stdCtx).Exec("TRUNCATE TABLE " + strings.Join(quoted, ", ") + " RESTART IDENTITY CASCADE;").Error
+	case DBModeMySQL:
+		return ctx.W.WithContext(stdCtx).Connection(func(tx *gorm.DB) (err error) {
+			if err := tx.Exec("SET FOREIGN_KEY_CHECKS = 0;").Error; err != nil {
+				return err
+			}
+			// the connection goes back to the pool
+			defer func() {
+				err = errors.Join(err, tx.Exec("SET FOREIGN_KEY_CHECKS = 1;").Error)
+			}()
+
+			for _, table := range quoted {
+				if err := tx.Exec("TRUNCATE TABLE " + table + ";").Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case DBModeSQLite:
+		return ctx.W.WithContext(stdCtx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec("PRAGMA defer_foreign_keys = ON;").Error; err != nil {
+				return err
+			}
+			for _, table := range quoted {
+				if err := tx.Exec("DELETE FROM " + table + ";").Error; err != nil {
+					return err
+				}
+			}
+
+			// only exists once a table has AUTOINCREMENT
+			var hasSequence int64
+			if err := tx.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'sqlite_sequence';").Scan(&hasSequence).Error; err != nil {
+				return err
+			}
+			if hasSequence == 0 {
+				return nil
+			}
+			return tx.Exec("DELETE FROM sqlite_sequence WHERE name IN ?;", tables).Error
+		})
+	default:
+		return ErrNotSupported
+	}
+}
+
+// UpdateStatistics refreshes the planner statistics of tables on W, of every
+// table of the database when none is given; tables are quoted as single
+// identifiers (QuoteIdentifier)
+//
+// sqlite/postgresql -> ANALYZE
+// mysql -> ANALYZE TABLE, the errors it reports as result rows (missing
+// table...) are returned
+func (ctx *GormDBCtx) UpdateStatistics(tables ...string) error {
+	if err := ctx.ensureConnected(); err != nil {
+		return err
+	}
+
+	quoted := make([]string, len(tables))
+	for i, table := range tables {
+		quoted[i] = ctx.QuoteIdentifier(table)
+	}
+
+	switch ctx.DBMode {
+	case DBModeSQLite:
+		if len(quoted) == 0 {
+			return ctx.W.Exec("ANALYZE;").Error
+		}
+		for _, table := range quoted {
+			if err := ctx.W.Exec("ANALYZE " + table + ";").Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	case DBModePostgreSQL:
+		statement := "ANALYZE"
+		if len(quoted) > 0 {
+			statement += " " + strings.Join(quoted, ", ")
+		}
+		return ctx.W.Exec(statement + ";").Error
+	case DBModeMySQL:
+		if len(quoted) == 0 {
+			var names []string
+			if err := ctx.W.Raw("SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE';").Scan(&names).Error; err != nil {
+				return err
+			}
+			for _, name := range names {
+				quoted = append(quoted, ctx.QuoteIdentifier(name))
+			}
+			if len(quoted) == 0 {
+				return nil
+			}
+		}
+		return ctx.mysqlAnalyzeTables(quoted)
+	default:
+		return ErrNotSupported
+	}
+}
+
+func (ctx *GormDBCtx) mysqlAnalyzeTables(quoted []string) error {
+	rows, err := ctx.W.Raw("ANALYZE TABLE " + strings.Join(quoted, ", ") + ";").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// Table, Op, Msg_type, Msg_text
+	var errs []error
+	for rows.Next() {
+		var table, op, msgType, msgText sql.NullString
+		if err := rows.Scan(&table, &op, &msgType, &msgText); err != nil {
+			return err
+		}
+		if strings.EqualFold(msgType.String, "error") {
+			errs = append(errs, fmt.Errorf("analyze %s: %s", table.String, msgText.String))
+		}
+	}
+	return errors.Join(append(errs, rows.Err())...)
+}
@@ -0,0 +1,299 @@
+package db_test
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kdnetwork/code-snippet/go/db"
+)
+
+func TestDumpSchema(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "dump_schema_test.db"))
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	for _, statement := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL)",
+		"CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users(id), title TEXT)",
+		"CREATE INDEX idx_posts_user_id ON posts (user_id)",
+	} {
+		if err := ctx.W.Exec(statement).Error; err != nil {
+			t.Fatalf("Failed to create schema: %v", err)
+		}
+	}
+
+	schema, err := ctx.DumpSchema()
+	if err != nil {
+		t.Fatalf("DumpSchema failed: %v", err)
+	}
+
+	for _, want := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL);",
+		"CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users(id), title TEXT);",
+		"CREATE INDEX idx_posts_user_id ON posts (user_id);",
+	} {
+		if !strings.Contains(schema, want) {
+			t.Errorf("schema is missing %q:\n%s", want, schema)
+		}
+	}
+	if strings.Index(schema, "CREATE INDEX") < strings.Index(schema, "CREATE TABLE users") {
+		t.Errorf("tables should come before indexes:\n%s", schema)
+	}
+}
+
+type diffUserV1 struct {
+	ID   uint
+	Name string
+}
+
+func (diffUserV1) TableName() string { return "diff_users" }
+
+type diffUserV2 struct {
+	ID    uint
+	Name  string
+	Email string `gorm:"index"`
+}
+
+func (diffUserV2) TableName() string { return "diff_users" }
+
+func TestMigrationDiff(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "migration_diff_test.db"))
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	t.Run("NewTable", func(t *testing.T) {
+		diff, err := ctx.MigrationDiff(&diffUserV1{})
+		if err != nil {
+			t.Fatalf("MigrationDiff failed: %v", err)
+		}
+		if len(diff) == 0 || !strings.HasPrefix(diff[0], "CREATE TABLE `diff_users`") {
+			t.Errorf("Expected a CREATE TABLE, got %q", diff)
+		}
+		if ctx.W.Migrator().HasTable(&diffUserV1{}) {
+			t.Error("MigrationDiff must not create the table")
+		}
+	})
+
+	if err := ctx.W.AutoMigrate(&diffUserV1{}); err != nil {
+		t.Fatalf("AutoMigrate failed: %v", err)
+	}
+
+	t.Run("UpToDate", func(t *testing.T) {
+		diff, err := ctx.MigrationDiff(&diffUserV1{})
+		if err != nil {
+			t.Fatalf("MigrationDiff failed: %v", err)
+		}
+		if len(diff) != 0 {
+			t.Errorf("Expected no diff, got %q", diff)
+		}
+	})
+
+	t.Run("MissingColumn", func(t *testing.T) {
+		diff, err := ctx.MigrationDiff(&diffUserV2{})
+		if err != nil {
+			t.Fatalf("MigrationDiff failed: %v", err)
+		}
+
+		joined := strings.Join(diff, "\n")
+		if !strings.Contains(joined, "ALTER TABLE `diff_users` ADD `email` text") {
+			t.Errorf("Expected the missing email column, got %q", diff)
+		}
+		if !strings.Contains(joined, "CREATE INDEX `idx_diff_users_email`") {
+			t.Errorf("Expected the missing index, got %q", diff)
+		}
+		if ctx.W.Migrator().HasColumn(&diffUserV2{}, "email") {
+			t.Error("MigrationDiff must not add the column")
+		}
+	})
+}
+
+func TestListIndexes(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "list_indexes_test.db"))
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	for _, statement := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, org INTEGER, email TEXT, name TEXT);",
+		"CREATE UNIQUE INDEX idx_users_org_email ON users (org, email);",
+		"CREATE INDEX idx_users_name ON users (name);",
+	} {
+		if err := ctx.W.Exec(statement).Error; err != nil {
+			t.Fatalf("%s failed: %v", statement, err)
+		}
+	}
+
+	indexes, err := ctx.ListIndexes("users")
+	if err != nil {
+		t.Fatalf("ListIndexes failed: %v", err)
+	}
+	want := []db.IndexInfo{
+		{Name: "idx_users_name", Columns: []string{"name"}, Unique: false},
+		{Name: "idx_users_org_email", Columns: []string{"org", "email"}, Unique: true},
+	}
+	if !reflect.DeepEqual(indexes, want) {
+		t.Errorf("Expected %+v, got %+v", want, indexes)
+	}
+
+	if indexes, err := ctx.ListIndexes("missing"); err != nil || len(indexes) != 0 {
+		t.Errorf("Expected no index for a missing table, got %+v (err %v)", indexes, err)
+	}
+}
+
+func TestCreateIndexIfNotExists(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "create_index_test.db"))
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	if err := ctx.W.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, name TEXT)").Error; err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	for i := range 2 {
+		if err := ctx.CreateIndexIfNotExists("users", "idx_users_email", []string{"email", "name"}, true); err != nil {
+			t.Fatalf("CreateIndexIfNotExists #%d failed: %v", i+1, err)
+		}
+	}
+
+	indexes, err := ctx.ListIndexes("users")
+	if err != nil {
+		t.Fatalf("ListIndexes failed: %v", err)
+	}
+	want := []db.IndexInfo{{Name: "idx_users_email", Columns: []string{"email", "name"}, Unique: true}}
+	if !reflect.DeepEqual(indexes, want) {
+		t.Errorf("Expected a single index %+v, got %+v", want, indexes)
+	}
+
+	if err := ctx.CreateIndexIfNotExists("users", "idx_users_name", nil, false); err == nil {
+		t.Error("Expected an error without columns")
+	}
+	if err := ctx.CreateIndexIfNotExists("missing", "idx_missing", []string{"id"}, false); err == nil {
+		t.Error("Expected an error for a missing table")
+	}
+}
+
+func TestTruncateTables(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "truncate_tables_test.db"))
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	for _, statement := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL)",
+		"CREATE TABLE posts (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id INTEGER REFERENCES users(id), title TEXT)",
+		"INSERT INTO users (name) VALUES ('a'), ('b'), ('c')",
+		"INSERT INTO posts (user_id, title) VALUES (1, 'x'), (3, 'y')",
+	} {
+		if err := ctx.W.Exec(statement).Error; err != nil {
+			t.Fatalf("Failed to prepare tables: %v", err)
+		}
+	}
+
+	// users first, posts still references it until the commit
+	if err := ctx.TruncateTables(context.Background(), "users", "posts"); err != nil {
+		t.Fatalf("TruncateTables failed: %v", err)
+	}
+
+	for _, table := range []string{"users", "posts"} {
+		count, err := ctx.CountRows(context.Background(), table)
+		if err != nil || count != 0 {
+			t.Errorf("%s should be empty, got %d rows (%v)", table, count, err)
+		}
+	}
+
+	id, err := ctx.InsertReturningID(context.Background(), "INSERT INTO users (name) VALUES ('d')")
+	if err != nil || id != 1 {
+		t.Errorf("autoincrement should restart at 1, got %d (%v)", id, err)
+	}
+
+	t.Run("ForeignKeyKept", func(t *testing.T) {
+		if err := ctx.W.Exec("INSERT INTO posts (user_id, title) VALUES (1, 'z')").Error; err != nil {
+			t.Fatalf("Failed to insert post: %v", err)
+		}
+		if err := ctx.TruncateTables(context.Background(), "users"); err == nil {
+			t.Error("emptying a referenced table alone should fail")
+		}
+		if count, _ := ctx.CountRows(context.Background(), "users"); count != 1 {
+			t.Errorf("failed truncate should roll back, got %d users", count)
+		}
+	})
+}
+
+func TestUpdateStatistics(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "update_statistics_test.db"))
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	for _, statement := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)",
+		"CREATE INDEX idx_users_email ON users (email)",
+		"INSERT INTO users (email) VALUES ('a'), ('b'), ('c')",
+	} {
+		if err := ctx.W.Exec(statement).Error; err != nil {
+			t.Fatalf("Failed to prepare table: %v", err)
+		}
+	}
+
+	if err := ctx.UpdateStatistics("users"); err != nil {
+		t.Fatalf("UpdateStatistics failed: %v", err)
+	}
+	var analyzed int64
+	if err := ctx.W.Raw("SELECT COUNT(*) FROM sqlite_stat1 WHERE tbl = 'users'").Scan(&analyzed).Error; err != nil || analyzed == 0 {
+		t.Errorf("Expected statistics for users, got %d rows (%v)", analyzed, err)
+	}
+
+	if err := ctx.UpdateStatistics(); err != nil {
+		t.Errorf("UpdateStatistics of the whole database failed: %v", err)
+	}
+	if err := ctx.UpdateStatistics("missing"); err == nil {
+		t.Error("Expected an error for a missing table")
+	}
+}
+
+// needs the servers of gorm_conn_test.go, skipped otherwise
+func TestUpdateStatisticsServers(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		ctx  *db.GormDBCtx
+	}{
+		{"MySQL", new(db.GormDBCtx).SetDBMode(db.DBModeMySQL).SetDBAuth(mysqlUser, mysqlPassword, mysqlHost, "mysql", "").SetCertPool(mysqlCertPool)},
+		{"PostgreSQL", new(db.GormDBCtx).SetDBMode(db.DBModePostgreSQL).SetDBAuth(pgUser, pgPassword, pgHost, "postgres", "disable")},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := c.ctx
+			if err := ctx.Connect(); err != nil {
+				t.Skipf("Skipping ANALYZE as server is unavailable: %v", err)
+			}
+			defer ctx.Close()
+
+			ctx.W.Exec("DROP TABLE IF EXISTS update_statistics_test;")
+			if err := ctx.W.Exec("CREATE TABLE update_statistics_test (id INT PRIMARY KEY, name VARCHAR(32));").Error; err != nil {
+				t.Fatalf("Create table failed: %v", err)
+			}
+			defer ctx.W.Exec("DROP TABLE IF EXISTS update_statistics_test;")
+
+			if err := ctx.UpdateStatistics("update_statistics_test"); err != nil {
+				t.Errorf("UpdateStatistics failed: %v", err)
+			}
+			if err := ctx.UpdateStatistics(); err != nil {
+				t.Errorf("UpdateStatistics of the whole database failed: %v", err)
+			}
+			if err := ctx.UpdateStatistics("update_statistics_test_missing"); err == nil {
+				t.Error("Expected an error for a missing table")
+			}
+		})
+	}
+}
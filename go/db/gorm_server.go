@@ -0,0 +1,126 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+)
+
+var ErrNotReplica = errors.New("server is not a replica")
+
+const (
+	// max size of a field value
+	postgreSQLMaxFieldSize = 1 << 30
+	// default SQLITE_MAX_LENGTH (string/BLOB)
+	sqliteMaxLength = 1_000_000_000
+)
+
+// MaxAllowedPacket returns the largest statement/value in bytes the server
+// accepts, to chunk bulk inserts of large BLOBs
+//
+// mysql -> @@max_allowed_packet
+// postgresql -> 1GiB, the limit of a single field
+// sqlite -> 1e9, the default SQLITE_MAX_LENGTH
+func (ctx *GormDBCtx) MaxAllowedPacket() (int64, error) {
+	switch ctx.DBMode {
+	case DBModeMySQL:
+		if err := ctx.ensureConnected(); err != nil {
+			return 0, err
+		}
+		var size int64
+		err := ctx.R.Raw("SELECT @@max_allowed_packet;").Scan(&size).Error
+		return size, err
+	case DBModePostgreSQL:
+		return postgreSQLMaxFieldSize, nil
+	case DBModeSQLite:
+		return sqliteMaxLength, nil
+	}
+
+	return 0, ErrNotSupported
+}
+
+// ReplicaLag returns how far R is behind its primary
+//
+// mysql -> Seconds_Behind_Source (SHOW REPLICA STATUS, 8.0.22+) or
+// Seconds_Behind_Master (SHOW SLAVE STATUS), 1s resolution
+// postgresql -> now() - pg_last_xact_replay_timestamp(), 0 when everything
+// received is replayed
+func (ctx *GormDBCtx) ReplicaLag() (time.Duration, error) {
+	if err := ctx.ensureConnected(); err != nil {
+		return 0, err
+	}
+
+	switch ctx.DBMode {
+	case DBModeMySQL:
+		return ctx.mysqlReplicaLag()
+	case DBModePostgreSQL:
+		var inRecovery bool
+		if err := ctx.R.Raw("SELECT pg_is_in_recovery();").Scan(&inRecovery).Error; err != nil {
+			return 0, err
+		}
+		if !inRecovery {
+			return 0, ErrNotReplica
+		}
+
+		var lag sql.NullFloat64
+		if err := ctx.R.Raw(`SELECT CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+			ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()) END AS lag;`).Scan(&lag).Error; err != nil {
+			return 0, err
+		}
+		if !lag.Valid {
+			return 0, errors.New("replication lag unknown: nothing replayed yet")
+		}
+		return time.Duration(lag.Float64 * float64(time.Second)), nil
+	}
+
+	return 0, ErrNotSupported
+}
+
+func (ctx *GormDBCtx) mysqlReplicaLag() (time.Duration, error) {
+	rows, err := ctx.R.Raw("SHOW REPLICA STATUS;").Rows()
+	if err != nil {
+		// < 8.0.22
+		if rows, err = ctx.R.Raw("SHOW SLAVE STATUS;").Rows(); err != nil {
+			return 0, err
+		}
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		return 0, ErrNotReplica
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+
+	for i, column := range columns {
+		if column != "Seconds_Behind_Source" && column != "Seconds_Behind_Master" {
+			continue
+		}
+		// NULL -> replication threads are not running
+		if values[i] == nil {
+			return 0, errors.New("replication is not running")
+		}
+		seconds, err := strconv.ParseInt(string(values[i]), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	return 0, errors.New("replication lag column not found")
+}
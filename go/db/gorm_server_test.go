@@ -0,0 +1,85 @@
+package db_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/kdnetwork/code-snippet/go/db"
+)
+
+func TestReplicaLag(t *testing.T) {
+	t.Run("SQLite", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "replica_lag_test.db"))
+		if err := ctx.Connect(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+		defer ctx.Close()
+
+		if _, err := ctx.ReplicaLag(); !errors.Is(err, db.ErrNotSupported) {
+			t.Errorf("Expected ErrNotSupported, got %v", err)
+		}
+	})
+
+	// integration: point mysqlHost/pgHost at a replica to get a lag,
+	// a primary reports ErrNotReplica
+	t.Run("MySQL", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModeMySQL).SetDBAuth(mysqlUser, mysqlPassword, mysqlHost, "mysql", "").SetCertPool(mysqlCertPool)
+		if err := ctx.Connect(); err != nil {
+			t.Skipf("Skipping replica lag check as server is unavailable: %v", err)
+		}
+		defer ctx.Close()
+
+		lag, err := ctx.ReplicaLag()
+		if err != nil && !errors.Is(err, db.ErrNotReplica) {
+			t.Errorf("ReplicaLag failed: %v", err)
+		}
+		t.Logf("MySQL replica lag: %v (%v)", lag, err)
+	})
+
+	t.Run("PostgreSQL", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModePostgreSQL).SetDBAuth(pgUser, pgPassword, pgHost, "postgres", "disable")
+		if err := ctx.Connect(); err != nil {
+			t.Skipf("Skipping replica lag check as server is unavailable: %v", err)
+		}
+		defer ctx.Close()
+
+		lag, err := ctx.ReplicaLag()
+		if err != nil && !errors.Is(err, db.ErrNotReplica) {
+			t.Errorf("ReplicaLag failed: %v", err)
+		}
+		t.Logf("PostgreSQL replica lag: %v (%v)", lag, err)
+	})
+}
+
+func TestMaxAllowedPacket(t *testing.T) {
+	t.Run("NotApplicable", func(t *testing.T) {
+		for mode, want := range map[string]int64{db.DBModePostgreSQL: 1 << 30, db.DBModeSQLite: 1_000_000_000} {
+			if got, err := new(db.GormDBCtx).SetDBMode(mode).MaxAllowedPacket(); err != nil || got != want {
+				t.Errorf("%s: expected %d, got %d (%v)", mode, want, got, err)
+			}
+		}
+
+		if _, err := new(db.GormDBCtx).MaxAllowedPacket(); !errors.Is(err, db.ErrNotSupported) {
+			t.Errorf("Expected ErrNotSupported without a db mode, got %v", err)
+		}
+	})
+
+	// integration: 64MiB by default on MySQL 8.0
+	t.Run("MySQL", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModeMySQL).SetDBAuth(mysqlUser, mysqlPassword, mysqlHost, "mysql", "").SetCertPool(mysqlCertPool)
+		if err := ctx.Connect(); err != nil {
+			t.Skipf("Skipping max_allowed_packet check as server is unavailable: %v", err)
+		}
+		defer ctx.Close()
+
+		size, err := ctx.MaxAllowedPacket()
+		if err != nil {
+			t.Fatalf("MaxAllowedPacket failed: %v", err)
+		}
+		// the server range is 1KiB ~ 1GiB
+		if size < 1024 || size > 1<<30 {
+			t.Errorf("max_allowed_packet out of range: %d", size)
+		}
+	})
+}
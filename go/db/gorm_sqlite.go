@@ -0,0 +1,162 @@
+package db
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// JournalMode returns the journal mode actually active on W (sqlite only).
+//
+// WALMode may silently fall back to "delete" where WAL can't work, e.g. on
+// network filesystems (NFS/SMB) without shared memory support, or for
+// :memory: databases ("memory").
+func (ctx *GormDBCtx) JournalMode() (string, error) {
+	if ctx.DBMode != DBModeSQLite {
+		return "", ErrNotSupported
+	}
+	if err := ctx.ensureConnected(); err != nil {
+		return "", err
+	}
+
+	var mode string
+	if err := ctx.W.Raw("PRAGMA journal_mode;").Scan(&mode).Error; err != nil {
+		return "", err
+	}
+	return strings.ToLower(mode), nil
+}
+
+type ColumnInfo struct {
+	Name string
+	// as declared, "" when the column has none
+	Type       string
+	NotNull    bool
+	PrimaryKey bool
+	// generated columns (VIRTUAL/STORED)
+	Generated bool
+}
+
+type TableInfo struct {
+	Name         string
+	Strict       bool
+	WithoutRowID bool
+	// in declaration order, generated columns included
+	Columns []ColumnInfo
+}
+
+// TableInfo describes table on R (sqlite only): the STRICT/WITHOUT ROWID
+// options are read from its CREATE TABLE in sqlite_master, the columns from
+// PRAGMA table_xinfo
+func (ctx *GormDBCtx) TableInfo(table string) (TableInfo, error) {
+	if ctx.DBMode != DBModeSQLite {
+		return TableInfo{}, ErrNotSupported
+	}
+	if err := ctx.ensureConnected(); err != nil {
+		return TableInfo{}, err
+	}
+
+	var createSQL []string
+	if err := ctx.R.Raw("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?;", table).Scan(&createSQL).Error; err != nil {
+		return TableInfo{}, err
+	}
+	if len(createSQL) == 0 {
+		return TableInfo{}, fmt.Errorf("table `%s` not found", table)
+	}
+
+	info := TableInfo{Name: table}
+
+	// the table options follow the closing parenthesis of the column list
+	if end := strings.LastIndex(createSQL[0], ")"); end >= 0 {
+		for option := range strings.SplitSeq(createSQL[0][end+1:], ",") {
+			switch strings.ToUpper(strings.Join(strings.Fields(option), " ")) {
+			case "STRICT":
+				info.Strict = true
+			case "WITHOUT ROWID":
+				info.WithoutRowID = true
+			}
+		}
+	}
+
+	var columns []struct {
+		Name    string
+		Type    string
+		NotNull bool
+		Pk      int
+		Hidden  int
+	}
+	if err := ctx.R.Raw("SELECT name, type, \"notnull\" AS not_null, pk, hidden FROM pragma_table_xinfo(?);", table).Scan(&columns).Error; err != nil {
+		return TableInfo{}, err
+	}
+	for _, column := range columns {
+		info.Columns = append(info.Columns, ColumnInfo{
+			Name:       column.Name,
+			Type:       column.Type,
+			NotNull:    column.NotNull,
+			PrimaryKey: column.Pk > 0,
+			// 1 -> hidden column of a virtual table
+			Generated: column.Hidden >= 2,
+		})
+	}
+	return info, nil
+}
+
+// pragma -> the mattn/go-sqlite3 options setting it
+var sqliteDSNPragmaKeys = map[string][]string{
+	"busy_timeout":  {"_busy_timeout", "_timeout"},
+	"journal_mode":  {"_journal_mode", "_journal"},
+	"synchronous":   {"_synchronous", "_sync"},
+	"foreign_keys":  {"_foreign_keys", "_fk"},
+	"cache_size":    {"_cache_size"},
+	"auto_vacuum":   {"_auto_vacuum", "_vacuum"},
+	"secure_delete": {"_secure_delete"},
+	"locking_mode":  {"_locking_mode", "_locking"},
+	"query_only":    {"_query_only"},
+}
+
+// pragma -> value
+type sqlitePragmas map[string]string
+
+func (p sqlitePragmas) has(name string) bool {
+	_, ok := p[name]
+	return ok
+}
+
+// the pragmas the driver applies itself from the options in the query of
+// path, only the syntax of the driver in use counts:
+//
+//	cgo (mattn/go-sqlite3)    -> file.db?_busy_timeout=5000&_journal_mode=WAL
+//	pure go (glebarez/sqlite) -> file.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)
+func sqliteDSNPragmas(path string) sqlitePragmas {
+	_, rawQuery, ok := strings.Cut(path, "?")
+	if !ok {
+		return nil
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil
+	}
+
+	pragmas := make(sqlitePragmas)
+	if CgoEnabled {
+		for pragma, keys := range sqliteDSNPragmaKeys {
+			for _, key := range keys {
+				if query.Has(key) {
+					pragmas[pragma] = query.Get(key)
+				}
+			}
+		}
+		return pragmas
+	}
+
+	// name(value) or name=value
+	for _, pragma := range query["_pragma"] {
+		name, value, found := strings.Cut(pragma, "(")
+		if found {
+			value = strings.TrimSuffix(value, ")")
+		} else {
+			name, value, _ = strings.Cut(name, "=")
+		}
+		pragmas[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+	}
+	return pragmas
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+)
+
+// SetSQLiteBusyHandler replaces busy_timeout on W with handler: a statement
+// failing with SQLITE_BUSY is run again as long as handler(attempts) (0, 1,
+// 2...) returns true, handler does its own sleeping
+//
+// neither sqlite driver exposes sqlite3_busy_handler, so W runs with
+// busy_timeout = 0 and the retries happen in Go, per statement (also inside
+// transactions, except COMMIT); nil -> back to busy_timeout
+func (ctx *GormDBCtx) SetSQLiteBusyHandler(handler func(attempts int) bool) error {
+	if ctx.DBMode != DBModeSQLite {
+		return ErrNotSupported
+	}
+
+	ctx.sqliteBusyHandler = handler
+	if ctx.W == nil || ctx.sqliteBusy == nil {
+		return nil
+	}
+
+	// the single connection of W, the ones opened later get it from the init
+	// SQL
+	busyTimeout := "PRAGMA busy_timeout = 5000;"
+	if handler != nil {
+		ctx.sqliteBusy.Store(&handler)
+		busyTimeout = "PRAGMA busy_timeout = 0;"
+	} else {
+		ctx.sqliteBusy.Store(nil)
+		if ms, err := strconv.Atoi(sqliteDSNPragmas(ctx.sqlitePath)["busy_timeout"]); err == nil {
+			// back to the one of the path
+			busyTimeout = "PRAGMA busy_timeout = " + strconv.Itoa(ms) + ";"
+		}
+	}
+	return ctx.W.Exec(busyTimeout).Error
+}
+
+// *sql.DB, or the *sql.Conn SetAcquireTimeout took from it
+type busyRetryTarget interface {
+	gorm.ConnPool
+	BeginTx(stdCtx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+type busyRetryConnPool struct {
+	busyRetryTarget
+	db      *sql.DB
+	handler *atomic.Pointer[func(attempts int) bool]
+}
+
+// gorm.DB.DB() unwraps through it
+func (p *busyRetryConnPool) GetDBConn() (*sql.DB, error) {
+	return p.db, nil
+}
+
+// the same retries on a connection of the pool
+func (p *busyRetryConnPool) on(conn *sql.Conn) *busyRetryConnPool {
+	return &busyRetryConnPool{busyRetryTarget: conn, db: p.db, handler: p.handler}
+}
+
+// gorm.ConnPoolBeginner, statements of the transaction are retried too
+func (p *busyRetryConnPool) BeginTx(stdCtx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	tx, err := p.busyRetryTarget.BeginTx(stdCtx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &busyRetryTx{Tx: tx, handler: p.handler}, nil
+}
+
+func (p *busyRetryConnPool) ExecContext(stdCtx context.Context, query string, args ...any) (sql.Result, error) {
+	return busyRetry(stdCtx, p.handler, func() (sql.Result, error) { return p.busyRetryTarget.ExecContext(stdCtx, query, args...) })
+}
+
+func (p *busyRetryConnPool) QueryContext(stdCtx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return busyRetry(stdCtx, p.handler, func() (*sql.Rows, error) { return p.busyRetryTarget.QueryContext(stdCtx, query, args...) })
+}
+
+func (p *busyRetryConnPool) QueryRowContext(stdCtx context.Context, query string, args ...any) *sql.Row {
+	return busyRetryRow(stdCtx, p.handler, func() *sql.Row { return p.busyRetryTarget.QueryRowContext(stdCtx, query, args...) })
+}
+
+// implements gorm.TxCommitter
+type busyRetryTx struct {
+	*sql.Tx
+	handler *atomic.Pointer[func(attempts int) bool]
+}
+
+func (tx *busyRetryTx) ExecContext(stdCtx context.Context, query string, args ...any) (sql.Result, error) {
+	return busyRetry(stdCtx, tx.handler, func() (sql.Result, error) { return tx.Tx.ExecContext(stdCtx, query, args...) })
+}
+
+func (tx *busyRetryTx) QueryContext(stdCtx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return busyRetry(stdCtx, tx.handler, func() (*sql.Rows, error) { return tx.Tx.QueryContext(stdCtx, query, args...) })
+}
+
+func (tx *busyRetryTx) QueryRowContext(stdCtx context.Context, query string, args ...any) *sql.Row {
+	return busyRetryRow(stdCtx, tx.handler, func() *sql.Row { return tx.Tx.QueryRowContext(stdCtx, query, args...) })
+}
+
+func isSQLiteBusy(err error) bool {
+	code, ok := sqliteErrorCode(err)
+	return ok && code&0xff == sqliteErrBusy
+}
+
+// nil handler -> no retry, busy_timeout applies
+func retryBusy(stdCtx context.Context, handler *atomic.Pointer[func(attempts int) bool], err error, attempts int) bool {
+	if err == nil || !isSQLiteBusy(err) || stdCtx.Err() != nil {
+		return false
+	}
+	h := handler.Load()
+	return h != nil && (*h)(attempts)
+}
+
+func busyRetry[T any](stdCtx context.Context, handler *atomic.Pointer[func(attempts int) bool], fn func() (T, error)) (T, error) {
+	for attempts := 0; ; attempts++ {
+		result, err := fn()
+		if !retryBusy(stdCtx, handler, err, attempts) {
+			return result, err
+		}
+	}
+}
+
+func busyRetryRow(stdCtx context.Context, handler *atomic.Pointer[func(attempts int) bool], fn func() *sql.Row) *sql.Row {
+	for attempts := 0; ; attempts++ {
+		row := fn()
+		if !retryBusy(stdCtx, handler, row.Err(), attempts) {
+			return row
+		}
+	}
+}
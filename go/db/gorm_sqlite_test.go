@@ -0,0 +1,447 @@
+package db_test
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/kdnetwork/code-snippet/go/db"
+	"gorm.io/gorm"
+)
+
+func TestJournalMode(t *testing.T) {
+	t.Run("WAL", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "journal_wal_test.db"))
+		ctx.WALMode = true
+		if err := ctx.Connect(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+		defer ctx.Close()
+
+		mode, err := ctx.JournalMode()
+		if err != nil {
+			t.Fatalf("JournalMode failed: %v", err)
+		}
+		if mode != "wal" {
+			t.Errorf("Expected wal, got %s", mode)
+		}
+	})
+
+	// WAL can't be enabled on a read-only file, same as on most network
+	// filesystems
+	t.Run("WALFallback", func(t *testing.T) {
+		dbFile := filepath.Join(t.TempDir(), "journal_fallback_test.db")
+		ctx := new(db.GormDBCtx).SetDBPath(dbFile)
+		if err := ctx.Connect(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+		ctx.Close()
+
+		required := new(db.GormDBCtx).SetDBPath("file:" + dbFile + "?mode=ro")
+		required.WALMode = true
+		if err := required.Connect(); err == nil {
+			required.Close()
+			t.Fatal("Connect should fail when WAL is required")
+		}
+
+		optional := new(db.GormDBCtx).SetDBPath("file:" + dbFile + "?mode=ro").SetWALRequired(false)
+		optional.WALMode = true
+		if err := optional.Connect(); err != nil {
+			t.Fatalf("Connect should fall back to the default journal mode: %v", err)
+		}
+		defer optional.Close()
+
+		if mode, err := optional.JournalMode(); err != nil || mode != "delete" {
+			t.Errorf("Expected delete, got %s (%v)", mode, err)
+		}
+	})
+
+	t.Run("Default", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "journal_default_test.db"))
+		if err := ctx.Connect(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+		defer ctx.Close()
+
+		if mode, err := ctx.JournalMode(); err != nil || mode != "delete" {
+			t.Errorf("Expected delete, got %s (%v)", mode, err)
+		}
+	})
+
+	t.Run("NotSQLite", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModeMySQL)
+		if _, err := ctx.JournalMode(); !errors.Is(err, db.ErrNotSupported) {
+			t.Errorf("Expected ErrNotSupported, got %v", err)
+		}
+	})
+}
+
+func TestSQLiteBusyHandler(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "busy_handler_test.db")
+	ctx := new(db.GormDBCtx).SetDBPath(dbFile)
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	if err := ctx.W.Exec("CREATE TABLE busy (id INTEGER PRIMARY KEY);").Error; err != nil {
+		t.Fatalf("Create table failed: %v", err)
+	}
+
+	// a concurrent writer holding the write lock
+	other, err := gorm.Open(db.SqliteDriverOpen(dbFile), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Open second handle failed: %v", err)
+	}
+	otherDB, _ := other.DB()
+	defer otherDB.Close()
+	lockHolder := other.Begin()
+	if err := lockHolder.Exec("INSERT INTO busy (id) VALUES (1);").Error; err != nil {
+		t.Fatalf("Failed to take the write lock: %v", err)
+	}
+
+	var attempts []int
+	if err := ctx.SetSQLiteBusyHandler(func(n int) bool {
+		attempts = append(attempts, n)
+		if n == 2 {
+			lockHolder.Commit()
+		}
+		time.Sleep(time.Millisecond)
+		return true
+	}); err != nil {
+		t.Fatalf("SetSQLiteBusyHandler failed: %v", err)
+	}
+
+	if err := ctx.W.Exec("INSERT INTO busy (id) VALUES (2);").Error; err != nil {
+		t.Fatalf("Insert should succeed once the lock is released: %v", err)
+	}
+	if !slices.Equal(attempts, []int{0, 1, 2}) {
+		t.Errorf("Expected the handler to be called with attempts 0, 1, 2, got %v", attempts)
+	}
+
+	// Create runs in the implicit transaction of gorm
+	t.Run("Transaction", func(t *testing.T) {
+		lockHolder := other.Begin()
+		if err := lockHolder.Exec("INSERT INTO busy (id) VALUES (5);").Error; err != nil {
+			t.Fatalf("Failed to take the write lock: %v", err)
+		}
+
+		calls := 0
+		_ = ctx.SetSQLiteBusyHandler(func(n int) bool {
+			calls++
+			if n == 1 {
+				lockHolder.Commit()
+			}
+			return true
+		})
+		err := ctx.W.Transaction(func(tx *gorm.DB) error {
+			return tx.Exec("INSERT INTO busy (id) VALUES (6);").Error
+		})
+		if err != nil || calls != 2 {
+			t.Errorf("Expected the transaction to succeed after 2 handler calls, got %v (%d calls)", err, calls)
+		}
+	})
+
+	t.Run("GiveUp", func(t *testing.T) {
+		lockHolder := other.Begin()
+		defer lockHolder.Rollback()
+		if err := lockHolder.Exec("INSERT INTO busy (id) VALUES (3);").Error; err != nil {
+			t.Fatalf("Failed to take the write lock: %v", err)
+		}
+
+		calls := 0
+		_ = ctx.SetSQLiteBusyHandler(func(n int) bool {
+			calls++
+			return false
+		})
+		err := ctx.W.Exec("INSERT INTO busy (id) VALUES (4);").Error
+		if !db.IsRetryable(err) || calls != 1 {
+			t.Errorf("Expected SQLITE_BUSY after a single handler call, got %v (%d calls)", err, calls)
+		}
+	})
+
+	t.Run("AcquireTimeout", func(t *testing.T) {
+		ctx.SetAcquireTimeout(100 * time.Millisecond)
+		defer ctx.SetAcquireTimeout(0)
+
+		lockHolder := other.Begin()
+		if err := lockHolder.Exec("INSERT INTO busy (id) VALUES (7);").Error; err != nil {
+			t.Fatalf("Failed to take the write lock: %v", err)
+		}
+
+		// still retried on the acquired connection
+		calls := 0
+		_ = ctx.SetSQLiteBusyHandler(func(n int) bool {
+			calls++
+			if n == 1 {
+				lockHolder.Commit()
+			}
+			return true
+		})
+		if err := ctx.W.Exec("INSERT INTO busy (id) VALUES (8);").Error; err != nil || calls != 2 {
+			t.Errorf("Expected the insert to succeed after 2 handler calls, got %v (%d calls)", err, calls)
+		}
+
+		// the only W connection is held by the transaction
+		tx := ctx.W.Begin()
+		if tx.Error != nil {
+			t.Fatalf("Begin failed: %v", tx.Error)
+		}
+		defer tx.Rollback()
+		if err := ctx.W.Exec("INSERT INTO busy (id) VALUES (9);").Error; !errors.Is(err, db.ErrAcquireTimeout) {
+			t.Errorf("Expected ErrAcquireTimeout with a busy handler set, got %v", err)
+		}
+	})
+
+	// busy_timeout = 0 also on the connections W opens after recycling
+	t.Run("Reconnect", func(t *testing.T) {
+		calls := 0
+		_ = ctx.SetSQLiteBusyHandler(func(n int) bool {
+			calls++
+			return false
+		})
+		ctx.SetConnMaxLifetime(50 * time.Millisecond)
+		defer ctx.SetConnMaxLifetime(0)
+		time.Sleep(200 * time.Millisecond)
+
+		var busyTimeout int
+		if err := ctx.W.Raw("PRAGMA busy_timeout;").Scan(&busyTimeout).Error; err != nil || busyTimeout != 0 {
+			t.Errorf("Expected busy_timeout 0 after recycling, got %d (%v)", busyTimeout, err)
+		}
+		if sqlDB, _ := ctx.W.DB(); sqlDB.Stats().MaxLifetimeClosed == 0 {
+			t.Error("Expected W's connection to be recycled")
+		}
+
+		lockHolder := other.Begin()
+		defer lockHolder.Rollback()
+		if err := lockHolder.Exec("INSERT INTO busy (id) VALUES (10);").Error; err != nil {
+			t.Fatalf("Failed to take the write lock: %v", err)
+		}
+		if err := ctx.W.Exec("INSERT INTO busy (id) VALUES (11);").Error; !db.IsRetryable(err) || calls != 1 {
+			t.Errorf("Expected SQLITE_BUSY after a single handler call, got %v (%d calls)", err, calls)
+		}
+	})
+
+	t.Run("NotSQLite", func(t *testing.T) {
+		ctx := new(db.GormDBCtx).SetDBMode(db.DBModeMySQL)
+		if err := ctx.SetSQLiteBusyHandler(func(int) bool { return false }); !errors.Is(err, db.ErrNotSupported) {
+			t.Errorf("Expected ErrNotSupported, got %v", err)
+		}
+	})
+}
+
+func TestSQLiteMmapSize(t *testing.T) {
+	const mmapSize = 64 << 20
+
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "mmap_size_test.db")).SetSQLiteMmapSize(mmapSize)
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	var got int64
+	if err := ctx.W.Raw("PRAGMA mmap_size;").Scan(&got).Error; err != nil {
+		t.Fatalf("PRAGMA mmap_size failed: %v", err)
+	}
+	if got == 0 {
+		t.Skip("mmap is disabled in this sqlite build (SQLITE_MAX_MMAP_SIZE = 0)")
+	}
+	if got != mmapSize {
+		t.Errorf("Expected mmap_size %d on W, got %d", mmapSize, got)
+	}
+
+	// per connection, hold several R connections at once
+	sqlDB, err := ctx.R.DB()
+	if err != nil {
+		t.Fatalf("DB failed: %v", err)
+	}
+	for i := range 3 {
+		conn, err := sqlDB.Conn(t.Context())
+		if err != nil {
+			t.Fatalf("Conn %d failed: %v", i, err)
+		}
+		defer conn.Close()
+
+		if err := conn.QueryRowContext(t.Context(), "PRAGMA mmap_size;").Scan(&got); err != nil {
+			t.Fatalf("PRAGMA mmap_size on conn %d failed: %v", i, err)
+		}
+		if got != mmapSize {
+			t.Errorf("Expected mmap_size %d on R conn %d, got %d", mmapSize, i, got)
+		}
+	}
+}
+
+func TestSQLiteSecureDelete(t *testing.T) {
+	for mode, want := range map[string]int{"on": 1, "fast": 2} {
+		t.Run(mode, func(t *testing.T) {
+			ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "secure_delete_test.db")).SetSQLiteSecureDelete(mode)
+			if err := ctx.Connect(); err != nil {
+				t.Fatalf("Conn to db failed: %v", err)
+			}
+			defer ctx.Close()
+
+			for name, handle := range map[string]*gorm.DB{"W": ctx.W, "R": ctx.R} {
+				var got int
+				if err := handle.Raw("PRAGMA secure_delete;").Scan(&got).Error; err != nil {
+					t.Fatalf("PRAGMA secure_delete on %s failed: %v", name, err)
+				}
+				if got != want {
+					t.Errorf("Expected secure_delete %d on %s, got %d", want, name, got)
+				}
+			}
+		})
+	}
+
+	t.Run("InvalidMode", func(t *testing.T) {
+		clone := new(db.GormDBCtx).SetSQLiteSecureDelete("on").SetSQLiteSecureDelete("shred").Clone()
+		ctx := clone.SetDBPath(filepath.Join(t.TempDir(), "secure_delete_invalid_test.db"))
+		if err := ctx.Connect(); err != nil {
+			t.Fatalf("Conn to db failed: %v", err)
+		}
+		defer ctx.Close()
+
+		var got int
+		if err := ctx.W.Raw("PRAGMA secure_delete;").Scan(&got).Error; err != nil || got != 1 {
+			t.Errorf("Expected the previous mode to be kept, got %d (err %v)", got, err)
+		}
+	})
+}
+
+func TestTableInfo(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "table_info_test.db"))
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	for _, statement := range []string{
+		`CREATE TABLE kv (
+			key TEXT PRIMARY KEY,
+			value INTEGER NOT NULL,
+			doubled INTEGER GENERATED ALWAYS AS (value * 2) VIRTUAL
+		) STRICT,  without  rowid`,
+		"CREATE TABLE plain (id INTEGER PRIMARY KEY, note)",
+	} {
+		if err := ctx.W.Exec(statement).Error; err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+	}
+
+	info, err := ctx.TableInfo("kv")
+	if err != nil {
+		t.Fatalf("TableInfo failed: %v", err)
+	}
+	if !info.Strict || !info.WithoutRowID {
+		t.Errorf("Expected STRICT and WITHOUT ROWID, got %+v", info)
+	}
+	want := []db.ColumnInfo{
+		{Name: "key", Type: "TEXT", NotNull: true, PrimaryKey: true},
+		{Name: "value", Type: "INTEGER", NotNull: true},
+		{Name: "doubled", Type: "INTEGER", Generated: true},
+	}
+	if !slices.Equal(info.Columns, want) {
+		t.Errorf("Expected columns %+v, got %+v", want, info.Columns)
+	}
+
+	plain, err := ctx.TableInfo("plain")
+	if err != nil {
+		t.Fatalf("TableInfo failed: %v", err)
+	}
+	if plain.Strict || plain.WithoutRowID {
+		t.Errorf("Expected a plain rowid table, got %+v", plain)
+	}
+	if len(plain.Columns) != 2 || plain.Columns[1].Type != "" {
+		t.Errorf("Expected an untyped note column, got %+v", plain.Columns)
+	}
+
+	if _, err := ctx.TableInfo("missing"); err == nil {
+		t.Error("Expected an error for a missing table")
+	}
+}
+
+func TestSQLiteDSNPragmas(t *testing.T) {
+	// the driver options of the driver in use
+	options := "?_busy_timeout=1234&_synchronous=FULL"
+	if !db.CgoEnabled {
+		options = "?_pragma=busy_timeout(1234)&_pragma=synchronous(FULL)"
+	}
+
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "dsn_pragmas_test.db") + options)
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	for name, handle := range map[string]*gorm.DB{"R": ctx.R, "W": ctx.W} {
+		var busyTimeout, synchronous int
+		if err := handle.Raw("PRAGMA busy_timeout").Scan(&busyTimeout).Error; err != nil {
+			t.Fatalf("PRAGMA busy_timeout on %s failed: %v", name, err)
+		}
+		if err := handle.Raw("PRAGMA synchronous").Scan(&synchronous).Error; err != nil {
+			t.Fatalf("PRAGMA synchronous on %s failed: %v", name, err)
+		}
+		if busyTimeout != 1234 {
+			t.Errorf("busy_timeout of the path clobbered on %s, got %d", name, busyTimeout)
+		}
+		// 2 -> FULL
+		if synchronous != 2 {
+			t.Errorf("synchronous of the path clobbered on %s, got %d", name, synchronous)
+		}
+	}
+
+	// the pragmas not in the path are still applied
+	var foreignKeys bool
+	if err := ctx.W.Raw("PRAGMA foreign_keys").Scan(&foreignKeys).Error; err != nil || !foreignKeys {
+		t.Errorf("Expected foreign_keys on W, got %v (%v)", foreignKeys, err)
+	}
+
+	t.Run("BusyHandlerReset", func(t *testing.T) {
+		if err := ctx.SetSQLiteBusyHandler(func(int) bool { return false }); err != nil {
+			t.Fatalf("SetSQLiteBusyHandler failed: %v", err)
+		}
+		if err := ctx.SetSQLiteBusyHandler(nil); err != nil {
+			t.Fatalf("SetSQLiteBusyHandler(nil) failed: %v", err)
+		}
+
+		// back to the one of the path, not 5000
+		var busyTimeout int
+		if err := ctx.W.Raw("PRAGMA busy_timeout").Scan(&busyTimeout).Error; err != nil || busyTimeout != 1234 {
+			t.Errorf("Expected busy_timeout of the path back, got %d (%v)", busyTimeout, err)
+		}
+	})
+}
+
+// the pool replaces W's only connection, the defaults must come back with it
+func TestSQLitePragmasPerConnection(t *testing.T) {
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "pragmas_per_conn_test.db")).SetConnMaxLifetime(50 * time.Millisecond)
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+
+	check := func(when string) {
+		t.Helper()
+		for _, handle := range map[string]*gorm.DB{"r": ctx.R, "w": ctx.W} {
+			var foreignKeys, busyTimeout int
+			if err := handle.Raw("PRAGMA foreign_keys;").Scan(&foreignKeys).Error; err != nil {
+				t.Fatalf("PRAGMA foreign_keys failed: %v", err)
+			}
+			if err := handle.Raw("PRAGMA busy_timeout;").Scan(&busyTimeout).Error; err != nil {
+				t.Fatalf("PRAGMA busy_timeout failed: %v", err)
+			}
+			if foreignKeys != 1 || busyTimeout != 5000 {
+				t.Errorf("%s: expected foreign_keys 1 and busy_timeout 5000, got %d and %d", when, foreignKeys, busyTimeout)
+			}
+		}
+	}
+
+	check("first connection")
+	connw, _ := ctx.W.DB()
+	time.Sleep(200 * time.Millisecond)
+	check("after recycling")
+	if closed := connw.Stats().MaxLifetimeClosed; closed == 0 {
+		t.Error("Expected the W connection to be recycled")
+	}
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/kdnetwork/code-snippet/go/utils"
+	"gorm.io/gorm"
+)
+
+// WithSavepoint runs fn inside a savepoint of tx, the savepoint is rolled back
+// when fn returns an error (or panics) and released otherwise, the outer
+// transaction is left untouched either way
+func WithSavepoint(tx *gorm.DB, name string, fn func(*gorm.DB) error) (err error) {
+	if err = tx.SavePoint(name).Error; err != nil {
+		return err
+	}
+
+	panicked := true
+	defer func() {
+		if panicked || err != nil {
+			if rbErr := tx.RollbackTo(name).Error; rbErr != nil && err == nil {
+				err = rbErr
+			}
+		}
+	}()
+
+	err = fn(tx)
+	panicked = false
+
+	if err == nil {
+		err = tx.Exec("RELEASE SAVEPOINT " + name).Error
+	}
+	return err
+}
+
+// WithTransaction runs fn in a transaction on W, opts sets the isolation level
+// and the read-only flag (like gorm.DB.Transaction).
+//
+// sqlite drivers ignore sql.TxOptions: the isolation level is always
+// SERIALIZABLE, ReadOnly is enforced with PRAGMA query_only for the duration
+// of the transaction (left on after EnforceReadOnly).
+func (ctx *GormDBCtx) WithTransaction(stdCtx context.Context, fn func(tx *gorm.DB) error, opts ...*sql.TxOptions) error {
+	if err := ctx.ensureConnected(); err != nil {
+		return err
+	}
+
+	readOnly := len(opts) > 0 && opts[0] != nil && opts[0].ReadOnly
+
+	return ctx.W.WithContext(stdCtx).Transaction(func(tx *gorm.DB) error {
+		if readOnly && ctx.DBMode == DBModeSQLite && !ctx.readOnly {
+			if err := tx.Exec("PRAGMA query_only = ON;").Error; err != nil {
+				return err
+			}
+			// still on the connection of the transaction
+			defer tx.Exec("PRAGMA query_only = OFF;")
+		}
+
+		return fn(tx)
+	}, opts...)
+}
+
+// WithRetryableTx runs fn in a transaction on W and re-runs the whole
+// transaction (up to maxRetries times, with backoff) when it fails with a
+// retryable error (serialization failure, deadlock, SQLITE_BUSY...)
+func (ctx *GormDBCtx) WithRetryableTx(stdCtx context.Context, maxRetries int, fn func(tx *gorm.DB) error, opts ...*sql.TxOptions) error {
+	for attempt := 0; ; attempt++ {
+		err := ctx.WithTransaction(stdCtx, fn, opts...)
+		if err == nil || attempt >= maxRetries || !IsRetryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(utils.Backoff(attempt))
+		select {
+		case <-stdCtx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/kdnetwork/code-snippet/go/db"
+	"gorm.io/gorm"
+)
+
+type txTestItem struct {
+	ID   uint
+	Name string
+}
+
+func newTxTestCtx(t *testing.T) *db.GormDBCtx {
+	t.Helper()
+
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "tx_test.db"))
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	t.Cleanup(func() { _ = ctx.Close() })
+
+	if err := ctx.W.AutoMigrate(&txTestItem{}); err != nil {
+		t.Fatalf("AutoMigrate failed: %v", err)
+	}
+	return ctx
+}
+
+func txTestItemNames(t *testing.T, ctx *db.GormDBCtx) []string {
+	t.Helper()
+
+	var names []string
+	if err := ctx.R.Model(&txTestItem{}).Order("id").Pluck("name", &names).Error; err != nil {
+		t.Fatalf("Pluck failed: %v", err)
+	}
+	return names
+}
+
+func TestWithSavepoint(t *testing.T) {
+	ctx := newTxTestCtx(t)
+
+	errInner := errors.New("inner failed")
+	err := ctx.W.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&txTestItem{Name: "before"}).Error; err != nil {
+			return err
+		}
+
+		if err := db.WithSavepoint(tx, "sp1", func(tx *gorm.DB) error {
+			if err := tx.Create(&txTestItem{Name: "inner"}).Error; err != nil {
+				return err
+			}
+			return errInner
+		}); !errors.Is(err, errInner) {
+			t.Errorf("WithSavepoint should return the inner error, got %v", err)
+		}
+
+		if err := db.WithSavepoint(tx, "sp2", func(tx *gorm.DB) error {
+			return tx.Create(&txTestItem{Name: "released"}).Error
+		}); err != nil {
+			return err
+		}
+
+		return tx.Create(&txTestItem{Name: "after"}).Error
+	})
+	if err != nil {
+		t.Fatalf("Transaction failed: %v", err)
+	}
+
+	if names := txTestItemNames(t, ctx); !slices.Equal(names, []string{"before", "released", "after"}) {
+		t.Errorf("Unexpected rows after savepoint rollback: %v", names)
+	}
+}
+
+func TestWithRetryableTx(t *testing.T) {
+	t.Run("RetryUntilCommit", func(t *testing.T) {
+		ctx := newTxTestCtx(t)
+
+		attempts := 0
+		err := ctx.WithRetryableTx(context.Background(), 5, func(tx *gorm.DB) error {
+			attempts++
+			if err := tx.Create(&txTestItem{Name: "row"}).Error; err != nil {
+				return err
+			}
+			if attempts <= 2 {
+				return &pgconn.PgError{Code: "40001", Message: "could not serialize access"}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("WithRetryableTx failed: %v", err)
+		}
+
+		if attempts != 3 {
+			t.Errorf("Expected 3 attempts, got %d", attempts)
+		}
+		// failed attempts must be rolled back
+		if names := txTestItemNames(t, ctx); len(names) != 1 {
+			t.Errorf("Expected exactly 1 committed row, got %v", names)
+		}
+	})
+
+	t.Run("PermanentErrorNotRetried", func(t *testing.T) {
+		ctx := newTxTestCtx(t)
+
+		errPermanent := errors.New("permanent")
+		attempts := 0
+		err := ctx.WithRetryableTx(context.Background(), 5, func(tx *gorm.DB) error {
+			attempts++
+			return errPermanent
+		})
+		if !errors.Is(err, errPermanent) || attempts != 1 {
+			t.Errorf("Permanent error should not be retried: err=%v, attempts=%d", err, attempts)
+		}
+	})
+
+	t.Run("RetriesExhausted", func(t *testing.T) {
+		ctx := newTxTestCtx(t)
+
+		attempts := 0
+		err := ctx.WithRetryableTx(context.Background(), 2, func(tx *gorm.DB) error {
+			attempts++
+			return &pgconn.PgError{Code: "40P01", Message: "deadlock detected"}
+		})
+		if !db.IsRetryable(err) || attempts != 3 {
+			t.Errorf("Expected the last retryable error after 3 attempts: err=%v, attempts=%d", err, attempts)
+		}
+	})
+}
+
+func TestWithTransaction(t *testing.T) {
+	t.Run("ReadOnly", func(t *testing.T) {
+		ctx := newTxTestCtx(t)
+		if err := ctx.W.Create(&txTestItem{Name: "existing"}).Error; err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+
+		err := ctx.WithTransaction(context.Background(), func(tx *gorm.DB) error {
+			var count int64
+			if err := tx.Model(&txTestItem{}).Count(&count).Error; err != nil {
+				return err
+			}
+			if count != 1 {
+				t.Errorf("Expected 1 row in read-only tx, got %d", count)
+			}
+			return tx.Create(&txTestItem{Name: "forbidden"}).Error
+		}, &sql.TxOptions{Isolation: sql.LevelSerializable, ReadOnly: true})
+		if err == nil {
+			t.Error("write inside a read-only transaction should fail")
+		}
+
+		// the connection is writable again afterwards
+		if err := ctx.WithTransaction(context.Background(), func(tx *gorm.DB) error {
+			return tx.Create(&txTestItem{Name: "allowed"}).Error
+		}); err != nil {
+			t.Errorf("write after read-only transaction failed: %v", err)
+		}
+
+		if names := txTestItemNames(t, ctx); !slices.Equal(names, []string{"existing", "allowed"}) {
+			t.Errorf("Unexpected rows: %v", names)
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const slowQueryPoolKey = "kd:slow_query_pool"
+
+// mysql/postgresql/sqlite
+//
+// d > 0 -> a statement still running after d logs a warning with its SQL
+// (placeholders, not the args) while it runs, so hangs and lock waits show up
+// before they end, the statement isn't cancelled; Rows/Row/Scan stop
+// watching once the query returned, not after reading the rows; 0 -> off
+func (ctx *GormDBCtx) SetSlowQueryWatchdog(d time.Duration) *GormDBCtx {
+	ctx.slowQueryWatchdog.Store(int64(max(d, 0)))
+	ctx.installSlowQueryWatchdog()
+	return ctx
+}
+
+// the SQL is only built inside gorm:query/gorm:create..., the statement goes
+// through a pool that starts a timer once it has the SQL; the timers run until
+// the callback ends, sqlite only does the work of a query while its rows are
+// read
+type watchdogConnPool struct {
+	gorm.ConnPool
+	ctx       *GormDBCtx
+	threshold time.Duration
+	timers    []*time.Timer
+}
+
+func (p *watchdogConnPool) watch(query string) {
+	start := time.Now()
+	p.timers = append(p.timers, time.AfterFunc(p.threshold, func() {
+		p.ctx.slogger().Warn(p.ctx.ServicePrefix, "method", "slow_query_watchdog", "running", time.Since(start).String(), "sql", query)
+	}))
+}
+
+func (p *watchdogConnPool) stop() {
+	for _, timer := range p.timers {
+		timer.Stop()
+	}
+}
+
+func (p *watchdogConnPool) PrepareContext(stdCtx context.Context, query string) (*sql.Stmt, error) {
+	p.watch(query)
+	return p.ConnPool.PrepareContext(stdCtx, query)
+}
+
+func (p *watchdogConnPool) ExecContext(stdCtx context.Context, query string, args ...any) (sql.Result, error) {
+	p.watch(query)
+	return p.ConnPool.ExecContext(stdCtx, query, args...)
+}
+
+func (p *watchdogConnPool) QueryContext(stdCtx context.Context, query string, args ...any) (*sql.Rows, error) {
+	p.watch(query)
+	return p.ConnPool.QueryContext(stdCtx, query, args...)
+}
+
+func (p *watchdogConnPool) QueryRowContext(stdCtx context.Context, query string, args ...any) *sql.Row {
+	p.watch(query)
+	return p.ConnPool.QueryRowContext(stdCtx, query, args...)
+}
+
+func (ctx *GormDBCtx) installSlowQueryWatchdog() {
+	if ctx.slowQueryWatchdog.Load() <= 0 {
+		return
+	}
+
+	// around the main callback only, transactions begin/commit on the
+	// original pool
+	wrap := func(tx *gorm.DB) {
+		threshold := time.Duration(ctx.slowQueryWatchdog.Load())
+		if threshold <= 0 || tx.Statement.ConnPool == nil {
+			return
+		}
+		watchdog := &watchdogConnPool{ConnPool: tx.Statement.ConnPool, ctx: ctx, threshold: threshold}
+		tx.InstanceSet(slowQueryPoolKey, watchdog)
+		tx.Statement.ConnPool = watchdog
+	}
+	unwrap := func(tx *gorm.DB) {
+		if watchdog, ok := tx.InstanceGet(slowQueryPoolKey); ok {
+			watchdog.(*watchdogConnPool).stop()
+			tx.Statement.ConnPool = watchdog.(*watchdogConnPool).ConnPool
+		}
+	}
+
+	for _, db := range []*gorm.DB{ctx.R, ctx.W} {
+		if db == nil || db.Callback().Query().Get("kd:slow_query_watchdog_before") != nil {
+			continue
+		}
+
+		callback := db.Callback()
+		_ = callback.Create().Before("gorm:create").Register("kd:slow_query_watchdog_before", wrap)
+		_ = callback.Create().Before("gorm:commit_or_rollback_transaction").Register("kd:slow_query_watchdog_after", unwrap)
+		_ = callback.Query().Before("gorm:query").Register("kd:slow_query_watchdog_before", wrap)
+		_ = callback.Query().After("gorm:query").Register("kd:slow_query_watchdog_after", unwrap)
+		_ = callback.Update().Before("gorm:update").Register("kd:slow_query_watchdog_before", wrap)
+		_ = callback.Update().Before("gorm:commit_or_rollback_transaction").Register("kd:slow_query_watchdog_after", unwrap)
+		_ = callback.Delete().Before("gorm:delete").Register("kd:slow_query_watchdog_before", wrap)
+		_ = callback.Delete().Before("gorm:commit_or_rollback_transaction").Register("kd:slow_query_watchdog_after", unwrap)
+		_ = callback.Row().Before("gorm:row").Register("kd:slow_query_watchdog_before", wrap)
+		_ = callback.Row().After("gorm:row").Register("kd:slow_query_watchdog_after", unwrap)
+		_ = callback.Raw().Before("gorm:raw").Register("kd:slow_query_watchdog_before", wrap)
+		_ = callback.Raw().After("gorm:raw").Register("kd:slow_query_watchdog_after", unwrap)
+	}
+}
@@ -0,0 +1,102 @@
+package db_test
+
+import (
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kdnetwork/code-snippet/go/db"
+)
+
+// slog records as they are written, with their arrival time
+type timedLines chan struct {
+	at   time.Time
+	line string
+}
+
+func (l timedLines) Write(p []byte) (int, error) {
+	l <- struct {
+		at   time.Time
+		line string
+	}{time.Now(), string(p)}
+	return len(p), nil
+}
+
+func TestSlowQueryWatchdog(t *testing.T) {
+	lines := make(timedLines, 16)
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(lines, nil)))
+	defer slog.SetDefault(prev)
+
+	ctx := new(db.GormDBCtx).SetDBPath(filepath.Join(t.TempDir(), "slow_query_watchdog_test.db")).
+		SetSlowQueryWatchdog(50 * time.Millisecond)
+	if err := ctx.Connect(); err != nil {
+		t.Fatalf("Conn to db failed: %v", err)
+	}
+	defer ctx.Close()
+	for len(lines) > 0 {
+		<-lines
+	}
+
+	// long enough to outlast the threshold by far
+	const slowSQL = "WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < ?) SELECT COUNT(*) FROM n;"
+	var count int64
+	start := time.Now()
+	if err := ctx.R.Raw(slowSQL, 3_000_000).Find(&count).Error; err != nil {
+		t.Fatalf("Slow query failed: %v", err)
+	}
+	done := time.Now()
+	if done.Sub(start) < 200*time.Millisecond {
+		t.Skipf("query too fast (%v) to check the watchdog", done.Sub(start))
+	}
+
+	select {
+	case l := <-lines:
+		var record struct {
+			Level  string `json:"level"`
+			Method string `json:"method"`
+			SQL    string `json:"sql"`
+		}
+		if err := json.Unmarshal([]byte(l.line), &record); err != nil {
+			t.Fatalf("Unexpected log line %q: %v", l.line, err)
+		}
+		if record.Level != "WARN" || record.Method != "slow_query_watchdog" || !strings.Contains(record.SQL, "WITH RECURSIVE") {
+			t.Errorf("Expected a slow query warning with the SQL, got %s", l.line)
+		}
+		if !l.at.Before(done) {
+			t.Errorf("Expected the warning while the query was running, got it %v after", l.at.Sub(done))
+		}
+	default:
+		t.Fatal("Expected a slow query warning")
+	}
+
+	t.Run("FastQuery", func(t *testing.T) {
+		if err := ctx.W.Exec("CREATE TABLE watchdog_test (id INTEGER PRIMARY KEY);").Error; err != nil {
+			t.Fatalf("Create table failed: %v", err)
+		}
+		if err := ctx.R.Raw("SELECT COUNT(*) FROM watchdog_test;").Scan(&count).Error; err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if len(lines) != 0 {
+			t.Errorf("Expected no warning for fast statements, got %s", (<-lines).line)
+		}
+	})
+
+	t.Run("WithAcquireTimeout", func(t *testing.T) {
+		// the acquired connection goes under the watchdog pool
+		ctx.SetAcquireTimeout(time.Second)
+		defer ctx.SetAcquireTimeout(0)
+
+		var ids []int64
+		if err := ctx.R.Raw("SELECT id FROM watchdog_test;").Find(&ids).Error; err != nil {
+			t.Errorf("Query with both callbacks failed: %v", err)
+		}
+		if err := ctx.W.Exec("INSERT INTO watchdog_test (id) VALUES (1);").Error; err != nil {
+			t.Errorf("Exec with both callbacks failed: %v", err)
+		}
+	})
+}
@@ -0,0 +1,18 @@
+package utils
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	backoffBase = 50 * time.Millisecond
+	backoffMax  = 5 * time.Second
+)
+
+// Backoff -> 50ms * 2^attempt (attempt starts from 0) capped at 5s, with
+// equal jitter so concurrent callers don't retry in lockstep
+func Backoff(attempt int) time.Duration {
+	d := min(backoffBase<<Clamp(attempt, 0, 16), backoffMax)
+	return d/2 + rand.N(d/2+1)
+}
@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Retry calls fn until it succeeds, up to attempts times (at least once),
+// sleeping backoff(i) between tries (Backoff if nil). It gives up early
+// when ctx is done; the returned error wraps the last error of fn
+func Retry(ctx context.Context, attempts int, backoff func(int) time.Duration, fn func() error) error {
+	if backoff == nil {
+		backoff = Backoff
+	}
+	attempts = max(attempts, 1)
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt == attempts-1 {
+			break
+		}
+
+		timer := time.NewTimer(backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("retry stopped after %d attempts: %w: %w", attempt+1, ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+
+	return fmt.Errorf("retry failed after %d attempts: %w", attempts, err)
+}
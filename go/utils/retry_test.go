@@ -0,0 +1,96 @@
+package utils_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kdnetwork/code-snippet/go/utils"
+)
+
+func noBackoff(int) time.Duration { return 0 }
+
+func TestRetry(t *testing.T) {
+	errFail := errors.New("fail")
+
+	t.Run("SuccessFirstTry", func(t *testing.T) {
+		calls := 0
+		err := utils.Retry(context.Background(), 3, noBackoff, func() error {
+			calls++
+			return nil
+		})
+		if err != nil || calls != 1 {
+			t.Errorf("Expected 1 call and nil error, got %d calls, err %v", calls, err)
+		}
+	})
+
+	t.Run("SuccessAfterRetries", func(t *testing.T) {
+		calls := 0
+		err := utils.Retry(context.Background(), 5, noBackoff, func() error {
+			calls++
+			if calls < 3 {
+				return errFail
+			}
+			return nil
+		})
+		if err != nil || calls != 3 {
+			t.Errorf("Expected 3 calls and nil error, got %d calls, err %v", calls, err)
+		}
+	})
+
+	t.Run("Exhausted", func(t *testing.T) {
+		calls := 0
+		err := utils.Retry(context.Background(), 4, noBackoff, func() error {
+			calls++
+			return errFail
+		})
+		if calls != 4 {
+			t.Errorf("Expected 4 calls, got %d", calls)
+		}
+		if !errors.Is(err, errFail) {
+			t.Errorf("Expected error wrapping errFail, got %v", err)
+		}
+	})
+
+	t.Run("AtLeastOnce", func(t *testing.T) {
+		calls := 0
+		_ = utils.Retry(context.Background(), 0, noBackoff, func() error {
+			calls++
+			return errFail
+		})
+		if calls != 1 {
+			t.Errorf("Expected 1 call, got %d", calls)
+		}
+	})
+
+	t.Run("ContextCanceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		err := utils.Retry(ctx, 10, func(int) time.Duration { return time.Hour }, func() error {
+			calls++
+			cancel()
+			return errFail
+		})
+		if calls != 1 {
+			t.Errorf("Expected 1 call, got %d", calls)
+		}
+		if !errors.Is(err, context.Canceled) || !errors.Is(err, errFail) {
+			t.Errorf("Expected error wrapping context.Canceled and errFail, got %v", err)
+		}
+	})
+
+	t.Run("DefaultBackoff", func(t *testing.T) {
+		calls := 0
+		err := utils.Retry(context.Background(), 2, nil, func() error {
+			calls++
+			if calls < 2 {
+				return errFail
+			}
+			return nil
+		})
+		if err != nil || calls != 2 {
+			t.Errorf("Expected 2 calls and nil error, got %d calls, err %v", calls, err)
+		}
+	})
+}
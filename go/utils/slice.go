@@ -0,0 +1,61 @@
+package utils
+
+// Chunk splits items into sub-slices of at most size items (the last one holds
+// the remainder), size <= 0 -> one chunk. Chunks share the backing array of
+// items but are capped, so appending to a chunk never overwrites the next one.
+func Chunk[T any](items []T, size int) [][]T {
+	if len(items) == 0 {
+		return [][]T{}
+	}
+	if size <= 0 || size >= len(items) {
+		return [][]T{items[:len(items):len(items)]}
+	}
+
+	chunks := make([][]T, 0, (len(items)+size-1)/size)
+	for start := 0; start < len(items); start += size {
+		end := min(start+size, len(items))
+		chunks = append(chunks, items[start:end:end])
+	}
+
+	return chunks
+}
+
+// Dedup removes duplicates, keeping the first occurrence and the order
+func Dedup[T comparable](items []T) []T {
+	return DedupFunc(items, func(item T) T { return item })
+}
+
+// DedupFunc is Dedup for non-comparable items, two items are duplicates when
+// key returns the same value for both
+func DedupFunc[T any, K comparable](items []T, key func(T) K) []T {
+	seen := make(map[K]struct{}, len(items))
+	result := make([]T, 0, len(items))
+
+	for _, item := range items {
+		k := key(item)
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		result = append(result, item)
+	}
+
+	return result
+}
+
+// Partition splits items into the ones pred matches and the rest, both keep
+// the order of items and are never nil; nil pred matches nothing
+func Partition[T any](items []T, pred func(T) bool) (matched, rest []T) {
+	matched = make([]T, 0, len(items))
+	rest = make([]T, 0, len(items))
+
+	for _, item := range items {
+		if pred != nil && pred(item) {
+			matched = append(matched, item)
+		} else {
+			rest = append(rest, item)
+		}
+	}
+
+	return matched, rest
+}
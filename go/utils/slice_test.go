@@ -0,0 +1,113 @@
+package utils_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kdnetwork/code-snippet/go/utils"
+)
+
+func TestChunk(t *testing.T) {
+	cases := []struct {
+		name  string
+		items []int
+		size  int
+		want  [][]int
+	}{
+		{"ExactMultiple", []int{1, 2, 3, 4, 5, 6}, 2, [][]int{{1, 2}, {3, 4}, {5, 6}}},
+		{"Remainder", []int{1, 2, 3, 4, 5}, 2, [][]int{{1, 2}, {3, 4}, {5}}},
+		{"SizeOne", []int{1, 2, 3}, 1, [][]int{{1}, {2}, {3}}},
+		{"SizeLargerThanItems", []int{1, 2, 3}, 10, [][]int{{1, 2, 3}}},
+		{"SizeZero", []int{1, 2, 3}, 0, [][]int{{1, 2, 3}}},
+		{"SizeNegative", []int{1, 2, 3}, -1, [][]int{{1, 2, 3}}},
+		{"Empty", []int{}, 3, [][]int{}},
+		{"Nil", nil, 3, [][]int{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := utils.Chunk(c.items, c.size)
+			if got == nil || !reflect.DeepEqual(got, c.want) {
+				t.Errorf("Chunk(%v, %d) = %v, want %v", c.items, c.size, got, c.want)
+			}
+		})
+	}
+
+	t.Run("AppendDoesNotClobber", func(t *testing.T) {
+		items := []int{1, 2, 3, 4}
+		chunks := utils.Chunk(items, 2)
+		_ = append(chunks[0], 99)
+		if items[2] != 3 {
+			t.Errorf("append to a chunk overwrote the next one: %v", items)
+		}
+	})
+}
+
+func TestDedup(t *testing.T) {
+	cases := []struct {
+		name  string
+		items []string
+		want  []string
+	}{
+		{"Duplicates", []string{"b", "a", "b", "c", "a"}, []string{"b", "a", "c"}},
+		{"AllUnique", []string{"a", "b", "c"}, []string{"a", "b", "c"}},
+		{"AllSame", []string{"a", "a", "a"}, []string{"a"}},
+		{"Empty", []string{}, []string{}},
+		{"Nil", nil, []string{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := utils.Dedup(c.items)
+			if got == nil || !reflect.DeepEqual(got, c.want) {
+				t.Errorf("Dedup(%v) = %v, want %v", c.items, got, c.want)
+			}
+		})
+	}
+
+	t.Run("KeyFunc", func(t *testing.T) {
+		type task struct {
+			ID      int
+			Payload []byte // not comparable
+		}
+
+		items := []task{{1, []byte("first")}, {2, nil}, {1, []byte("second")}, {3, nil}, {2, []byte("dup")}}
+		got := utils.DedupFunc(items, func(t task) int { return t.ID })
+
+		want := []task{{1, []byte("first")}, {2, nil}, {3, nil}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("DedupFunc = %v, want %v", got, want)
+		}
+	})
+}
+
+func TestPartition(t *testing.T) {
+	even := func(n int) bool { return n%2 == 0 }
+
+	cases := []struct {
+		name        string
+		items       []int
+		pred        func(int) bool
+		wantMatched []int
+		wantRest    []int
+	}{
+		{"AllMatch", []int{2, 4, 6}, even, []int{2, 4, 6}, []int{}},
+		{"NoneMatch", []int{1, 3, 5}, even, []int{}, []int{1, 3, 5}},
+		{"Mixed", []int{1, 2, 3, 4, 5}, even, []int{2, 4}, []int{1, 3, 5}},
+		{"Empty", []int{}, even, []int{}, []int{}},
+		{"Nil", nil, even, []int{}, []int{}},
+		{"NilPred", []int{1, 2}, nil, []int{}, []int{1, 2}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			matched, rest := utils.Partition(c.items, c.pred)
+			if matched == nil || !reflect.DeepEqual(matched, c.wantMatched) {
+				t.Errorf("Partition(%v) matched = %v, want %v", c.items, matched, c.wantMatched)
+			}
+			if rest == nil || !reflect.DeepEqual(rest, c.wantRest) {
+				t.Errorf("Partition(%v) rest = %v, want %v", c.items, rest, c.wantRest)
+			}
+		})
+	}
+}
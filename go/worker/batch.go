@@ -0,0 +1,153 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kdnetwork/code-snippet/go/utils"
+)
+
+// RunWorkerPoolBatch is RunWorkerPool handing fn batchSize consecutive tasks
+// at a time (the last batch may be shorter), every task of a batch gets the
+// error of its batch; WithAdaptiveBatch -> batchSize of the first batches only
+//
+// opts -> WithPanicPolicy, WithStartJitter, WithAdaptiveBatch
+func RunWorkerPoolBatch[T any, K comparable, V any](ctx context.Context, tasks []T, batchSize, maxWorkers int, fn func(ctx context.Context, batch []T, store map[K]V) error, opts ...Option) []error {
+	o := newOptions(opts)
+	tasksLen := len(tasks)
+
+	if tasksLen == 0 {
+		return []error{}
+	}
+	if fn == nil {
+		return nilFuncErrors(tasksLen)
+	}
+
+	sizer := &batchSizer{size: float64(max(batchSize, 1)), adaptive: o.adaptiveBatch}
+
+	maxWorkers = utils.Clamp((tasksLen+int(sizer.size)-1)/int(sizer.size), 1, maxWorkers)
+
+	ctx, abort := context.WithCancelCause(ctx)
+	defer abort(nil)
+	taskCtx := context.WithValue(ctx, abortKey{}, abort)
+
+	errs := make([]error, tasksLen)
+	started := make([]bool, tasksLen)
+
+	// next task to hand out, batches are cut when taken so they follow the
+	// latest size
+	var mu sync.Mutex
+	next := 0
+	take := func() (from, to int) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		from = next
+		next = min(next+sizer.next(), tasksLen)
+		return from, next
+	}
+
+	var firstPanic atomic.Pointer[PanicError]
+
+	var wg sync.WaitGroup
+	for range maxWorkers {
+		wg.Go(func() {
+			if !o.sleepStartJitter(ctx) {
+				return
+			}
+
+			store := make(map[K]V)
+			for ctx.Err() == nil {
+				from, to := take()
+				if from == to {
+					return
+				}
+
+				start := time.Now()
+				err := runTask(func() error { return fn(taskCtx, tasks[from:to], store) })
+				sizer.observe(to-from, time.Since(start))
+				if errors.Is(err, ErrStop) && ctx.Err() == nil {
+					err = nil
+					abort(ErrStop)
+				}
+
+				if panicErr, ok := err.(*PanicError); ok {
+					firstPanic.CompareAndSwap(nil, panicErr)
+				}
+				for i := from; i < to; i++ {
+					started[i] = true
+					errs[i] = err
+				}
+			}
+		})
+	}
+
+	wg.Wait()
+
+	for i := range errs {
+		if !started[i] {
+			errs[i] = fmt.Errorf("%w: %w", ErrNotStarted, context.Cause(ctx))
+		}
+	}
+
+	switch o.panicPolicy {
+	case PanicPropagate:
+		if panicErr := firstPanic.Load(); panicErr != nil {
+			panic(panicErr.Value)
+		}
+	case PanicIgnore:
+		for i, err := range errs {
+			if _, ok := err.(*PanicError); ok {
+				errs[i] = nil
+			}
+		}
+	}
+
+	return errs
+}
+
+type adaptiveBatch struct {
+	target  time.Duration
+	maxSize int
+}
+
+// shared by the workers of a pool, nil adaptive -> fixed size
+type batchSizer struct {
+	mu       sync.Mutex
+	size     float64
+	adaptive *adaptiveBatch
+}
+
+func (b *batchSizer) next() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return max(int(b.size+0.5), 1)
+}
+
+// the size a batch of n tasks that took elapsed should have had, at most
+// twice or half the current size per step, averaged with it to smooth out
+// uneven tasks
+func (b *batchSizer) observe(n int, elapsed time.Duration) {
+	if b.adaptive == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ideal := b.size * 2
+	if elapsed > 0 {
+		ideal = float64(n) * float64(b.adaptive.target) / float64(elapsed)
+	}
+	ideal = utils.Clamp(ideal, b.size/2, b.size*2)
+
+	b.size = max((b.size+ideal)/2, 1)
+	if b.adaptive.maxSize > 0 {
+		b.size = min(b.size, float64(b.adaptive.maxSize))
+	}
+}
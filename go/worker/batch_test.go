@@ -0,0 +1,106 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kdnetwork/code-snippet/go/worker"
+)
+
+func TestRunWorkerPoolBatch(t *testing.T) {
+	tasks := make([]int, 103)
+	for i := range tasks {
+		tasks[i] = i
+	}
+
+	t.Run("FixedSize", func(t *testing.T) {
+		var mu sync.Mutex
+		var sizes []int
+		seen := make([]int, len(tasks))
+		errTask := errors.New("batch failed")
+		errs := worker.RunWorkerPoolBatch(context.Background(), tasks, 10, 3, func(ctx context.Context, batch []int, store map[string]int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			sizes = append(sizes, len(batch))
+			for _, task := range batch {
+				seen[task]++
+			}
+			if slices.Contains(batch, 42) {
+				return errTask
+			}
+			return nil
+		})
+
+		slices.Sort(sizes)
+		if want := append([]int{3}, slices.Repeat([]int{10}, 10)...); !slices.Equal(sizes, want) {
+			t.Errorf("Expected batch sizes %v, got %v", want, sizes)
+		}
+		for i, n := range seen {
+			if n != 1 {
+				t.Errorf("task %d ran %d times", i, n)
+			}
+		}
+		for i, err := range errs {
+			if failed := i >= 40 && i < 50; failed != errors.Is(err, errTask) {
+				t.Errorf("task %d: unexpected error %v", i, err)
+			}
+		}
+	})
+
+	t.Run("Adaptive", func(t *testing.T) {
+		// 0.5ms-1.5ms per task, ~20 tasks per 20ms batch
+		costs := make([]time.Duration, 2000)
+		for i := range costs {
+			costs[i] = time.Duration(500+rand.IntN(1000)) * time.Microsecond
+		}
+		target := 20 * time.Millisecond
+
+		var mu sync.Mutex
+		var durations []time.Duration
+		var sizes []int
+		worker.RunWorkerPoolBatch(context.Background(), costs, 1, 4, func(ctx context.Context, batch []time.Duration, store map[string]int) error {
+			var d time.Duration
+			for _, cost := range batch {
+				d += cost
+			}
+			time.Sleep(d)
+
+			mu.Lock()
+			defer mu.Unlock()
+			durations = append(durations, d)
+			sizes = append(sizes, len(batch))
+			return nil
+		}, worker.WithAdaptiveBatch(target, 0))
+
+		if len(durations) < 20 {
+			t.Fatalf("Expected the batches to stay small, got %d batches", len(durations))
+		}
+		// the last batch takes what's left
+		last := slices.Clone(durations[len(durations)-11 : len(durations)-1])
+		slices.Sort(last)
+		if median := last[len(last)/2]; median < target/2 || median > target*2 {
+			t.Errorf("Expected batches converging toward %v, median of the last ones %v (sizes %v)", target, median, sizes)
+		}
+		if sizes[0] != 1 {
+			t.Errorf("Expected the first batch to have the initial size, got %d", sizes[0])
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		errs := worker.RunWorkerPoolBatch(ctx, tasks, 10, 1, func(ctx context.Context, batch []int, store map[string]int) error {
+			cancel()
+			return nil
+		})
+
+		completed, notStarted := worker.PartialResults(errs)
+		if len(completed) != 10 || len(notStarted) != len(tasks)-10 {
+			t.Errorf("Expected 10 completed tasks, got %d completed and %d not started", len(completed), len(notStarted))
+		}
+	})
+}
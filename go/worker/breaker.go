@@ -0,0 +1,82 @@
+package worker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("worker circuit breaker open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	// one probe task is running, the others short-circuit
+	breakerHalfOpen
+)
+
+// shared by every worker of a pool
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	// bumped on every state change, results of tasks let through in an
+	// earlier one are dropped
+	generation uint64
+}
+
+// allow reports whether a task may run and the generation to record its
+// result with, once the cooldown is over the first caller becomes the
+// half-open probe
+func (b *circuitBreaker) allow() (uint64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return 0, false
+		}
+		b.setState(breakerHalfOpen)
+		return b.generation, true
+	case breakerHalfOpen:
+		return 0, false
+	}
+	return b.generation, true
+}
+
+// record the result of a task allow let through, while open or half-open
+// only the probe counts
+func (b *circuitBreaker) record(generation uint64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	if err == nil {
+		if b.state != breakerClosed {
+			b.setState(breakerClosed)
+		}
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.setState(breakerOpen)
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *circuitBreaker) setState(state breakerState) {
+	b.state = state
+	b.generation++
+}
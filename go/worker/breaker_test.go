@@ -0,0 +1,169 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kdnetwork/code-snippet/go/worker"
+)
+
+func TestWithCircuitBreaker(t *testing.T) {
+	errDownstream := errors.New("downstream unavailable")
+
+	t.Run("Trips", func(t *testing.T) {
+		var calls atomic.Int64
+		tasks := make([]int, 10)
+		errs := worker.RunWorkerPool(context.Background(), tasks, 1, func(ctx context.Context, task int, store map[string]int) error {
+			calls.Add(1)
+			return errDownstream
+		}, worker.WithCircuitBreaker(3, time.Hour))
+
+		if got := calls.Load(); got != 3 {
+			t.Errorf("Expected fn to run 3 times before the breaker opens, got %d", got)
+		}
+		for i, err := range errs {
+			if want := i >= 3; errors.Is(err, worker.ErrCircuitOpen) != want {
+				t.Errorf("task %d: unexpected error %v", i, err)
+			}
+		}
+	})
+
+	t.Run("SharedAcrossWorkers", func(t *testing.T) {
+		var calls atomic.Int64
+		tasks := make([]int, 100)
+		errs := worker.RunWorkerPool(context.Background(), tasks, 8, func(ctx context.Context, task int, store map[string]int) error {
+			calls.Add(1)
+			return errDownstream
+		}, worker.WithCircuitBreaker(5, time.Hour))
+
+		// workers already past allow() when the breaker opened still run
+		if got := calls.Load(); got < 5 || got > 5+8 {
+			t.Errorf("Expected 5 to 13 calls, got %d", got)
+		}
+		open := 0
+		for _, err := range errs {
+			if errors.Is(err, worker.ErrCircuitOpen) {
+				open++
+			}
+		}
+		if open != len(tasks)-int(calls.Load()) {
+			t.Errorf("Expected every other task to short-circuit, got %d of %d", open, len(tasks))
+		}
+	})
+
+	t.Run("SuccessResets", func(t *testing.T) {
+		// fails, fails, succeeds, fails, fails: never 3 in a row
+		tasks := []int{1, 1, 0, 1, 1}
+		errs := worker.RunWorkerPool(context.Background(), tasks, 1, func(ctx context.Context, task int, store map[string]int) error {
+			if task == 1 {
+				return errDownstream
+			}
+			return nil
+		}, worker.WithCircuitBreaker(3, time.Hour))
+
+		for i, err := range errs {
+			if errors.Is(err, worker.ErrCircuitOpen) {
+				t.Errorf("task %d: breaker should stay closed", i)
+			}
+		}
+	})
+
+	// let through while closed, lands once the breaker tripped
+	t.Run("LateSuccess", func(t *testing.T) {
+		const slow = -1
+
+		started, release := make(chan struct{}), make(chan struct{})
+		s := worker.StartStreamPool[int, string, int](context.Background(), 2, func(ctx context.Context, task int, store map[string]int) error {
+			switch task {
+			case slow:
+				close(started)
+				<-release
+				return nil
+			case 1:
+				return errDownstream
+			}
+			return nil
+		}, worker.WithCircuitBreaker(2, time.Hour))
+		defer s.Stop()
+
+		submit := func(task int) {
+			t.Helper()
+			if err := s.Submit(task); err != nil {
+				t.Fatalf("Submit failed: %v", err)
+			}
+		}
+
+		submit(slow)
+		<-started
+		submit(1)
+		submit(1)
+		for range 2 {
+			if err := (<-s.Results()).Err; !errors.Is(err, errDownstream) {
+				t.Fatalf("Expected the failing tasks to run, got %v", err)
+			}
+		}
+
+		close(release)
+		if res := <-s.Results(); res.Task != slow || res.Err != nil {
+			t.Fatalf("Expected the slow task to succeed, got %v", res.Err)
+		}
+		submit(0)
+		if err := (<-s.Results()).Err; !errors.Is(err, worker.ErrCircuitOpen) {
+			t.Errorf("Expected the breaker to stay open after a late success, got %v", err)
+		}
+	})
+
+	t.Run("HalfOpen", func(t *testing.T) {
+		const cooldown = 50 * time.Millisecond
+
+		var failing atomic.Bool
+		failing.Store(true)
+		var calls atomic.Int64
+		s := worker.StartStreamPool[int, string, int](context.Background(), 1, func(ctx context.Context, task int, store map[string]int) error {
+			calls.Add(1)
+			if failing.Load() {
+				return errDownstream
+			}
+			return nil
+		}, worker.WithCircuitBreaker(2, cooldown))
+		defer s.Stop()
+
+		submit := func() error {
+			t.Helper()
+			if err := s.Submit(0); err != nil {
+				t.Fatalf("Submit failed: %v", err)
+			}
+			return (<-s.Results()).Err
+		}
+
+		submit()
+		submit()
+		if err := submit(); !errors.Is(err, worker.ErrCircuitOpen) {
+			t.Fatalf("Expected ErrCircuitOpen after 2 failures, got %v", err)
+		}
+
+		// the probe fails -> open for another cooldown
+		time.Sleep(cooldown)
+		if err := submit(); !errors.Is(err, errDownstream) {
+			t.Fatalf("Expected the probe to run, got %v", err)
+		}
+		if err := submit(); !errors.Is(err, worker.ErrCircuitOpen) {
+			t.Fatalf("Expected ErrCircuitOpen after a failed probe, got %v", err)
+		}
+
+		// the probe succeeds -> closed
+		failing.Store(false)
+		time.Sleep(cooldown)
+		for i := range 3 {
+			if err := submit(); err != nil {
+				t.Fatalf("Expected the breaker to close after a successful probe, task %d got %v", i, err)
+			}
+		}
+		if got := calls.Load(); got != 2+1+3 {
+			t.Errorf("Expected 6 calls of fn, got %d", got)
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package worker
+
+import "sync"
+
+// singleflight keyed by the comparable keys of WithDedup
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[any]*flightCall
+}
+
+type flightCall struct {
+	done chan struct{}
+	err  error
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[any]*flightCall)}
+}
+
+// do runs fn unless a call with key is in flight, in which case it waits for
+// that call and returns its error
+func (g *flightGroup) do(key any, fn func() error) error {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.err
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	// fn is run through runTask, it doesn't panic
+	call.err = fn()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(call.done)
+
+	return call.err
+}
@@ -0,0 +1,85 @@
+package worker
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// PoolHandle controls a pool started by StartWorkerPool
+type PoolHandle struct {
+	mu        sync.Mutex
+	cancels   []context.CancelFunc
+	cancelled []bool
+
+	done chan struct{}
+	errs []error
+}
+
+// StartWorkerPool runs RunWorkerPool in the background, every task gets its
+// own child context that CancelTask can cancel alone
+func StartWorkerPool[T any, K comparable, V any](ctx context.Context, tasks []T, maxWorkers int, fn func(ctx context.Context, task T, store map[K]V) error, opts ...Option) *PoolHandle {
+	h := &PoolHandle{
+		cancels:   make([]context.CancelFunc, len(tasks)),
+		cancelled: make([]bool, len(tasks)),
+		done:      make(chan struct{}),
+	}
+
+	go func() {
+		defer close(h.done)
+
+		if fn == nil {
+			h.errs = nilFuncErrors(len(tasks))
+			return
+		}
+
+		h.errs = RunWorkerPool(ctx, indexes(len(tasks)), maxWorkers, func(ctx context.Context, i int, store map[K]V) error {
+			taskCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			h.mu.Lock()
+			if h.cancelled[i] {
+				h.mu.Unlock()
+				return context.Canceled
+			}
+			h.cancels[i] = cancel
+			h.mu.Unlock()
+
+			defer func() {
+				h.mu.Lock()
+				h.cancels[i] = nil
+				h.mu.Unlock()
+			}()
+
+			return fn(taskCtx, tasks[i], store)
+		}, append(slices.Clone(opts), withIndexedTasks(tasks))...)
+	}()
+
+	return h
+}
+
+// CancelTask cancels the context of tasks[index] only, a task that hasn't
+// started yet is skipped with context.Canceled, finished tasks are unaffected
+func (h *PoolHandle) CancelTask(index int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if index < 0 || index >= len(h.cancelled) {
+		return
+	}
+	h.cancelled[index] = true
+	if cancel := h.cancels[index]; cancel != nil {
+		cancel()
+	}
+}
+
+// Done is closed once every task finished
+func (h *PoolHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Wait blocks until the pool finished, errs[i] belongs to tasks[i]
+func (h *PoolHandle) Wait() []error {
+	<-h.done
+	return h.errs
+}
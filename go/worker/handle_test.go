@@ -0,0 +1,69 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kdnetwork/code-snippet/go/worker"
+)
+
+func TestCancelTask(t *testing.T) {
+	tasks := []int{0, 1, 2, 3}
+	stuckStarted := make(chan struct{})
+
+	h := worker.StartWorkerPool[int, string, int](context.Background(), tasks, len(tasks), func(ctx context.Context, task int, store map[string]int) error {
+		if task == 2 {
+			close(stuckStarted)
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+			return nil
+		}
+	})
+
+	<-stuckStarted
+	h.CancelTask(2)
+
+	errs := h.Wait()
+	for i, err := range errs {
+		if i == 2 {
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("cancelled task should return context.Canceled, got %v", err)
+			}
+		} else if err != nil {
+			t.Errorf("task %d should succeed, got %v", i, err)
+		}
+	}
+
+	t.Run("NotStarted", func(t *testing.T) {
+		release := make(chan struct{})
+		ran := make([]bool, len(tasks))
+
+		h := worker.StartWorkerPool[int, string, int](context.Background(), tasks, 1, func(ctx context.Context, task int, store map[string]int) error {
+			ran[task] = true
+			if task == 0 {
+				<-release
+			}
+			return nil
+		})
+		h.CancelTask(3)
+		h.CancelTask(99) // out of range, ignored
+		close(release)
+
+		errs := h.Wait()
+		if !errors.Is(errs[3], context.Canceled) || ran[3] {
+			t.Errorf("task 3 should be skipped, got %v (ran %v)", errs[3], ran[3])
+		}
+		for i := range 3 {
+			if errs[i] != nil || !ran[i] {
+				t.Errorf("task %d should run and succeed, got %v", i, errs[i])
+			}
+		}
+	})
+}
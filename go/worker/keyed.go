@@ -0,0 +1,83 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var ErrDuplicateKey = errors.New("duplicate task key")
+
+type DuplicateKeyPolicy int
+
+const (
+	// later tasks (in slice order) overwrite earlier ones
+	DuplicateKeyLastWins DuplicateKeyPolicy = iota
+	// nothing runs, every duplicated key gets ErrDuplicateKey and the other
+	// keys ErrNotStarted wrapping it
+	DuplicateKeyError
+)
+
+// RunWorkerPoolKeyed is RunWorkerPool with results looked up by keyOf(task),
+// a key is either in results (fn returned nil) or in errs
+func RunWorkerPoolKeyed[T any, TK comparable, R any, K comparable, V any](ctx context.Context, tasks []T, maxWorkers int, keyOf func(T) TK, policy DuplicateKeyPolicy, fn func(ctx context.Context, task T, store map[K]V) (R, error), opts ...Option) (map[TK]R, map[TK]error) {
+	keys := make([]TK, len(tasks))
+	for i, task := range tasks {
+		keys[i] = keyOf(task)
+	}
+
+	results := make(map[TK]R, len(tasks))
+	errs := make(map[TK]error)
+
+	if policy == DuplicateKeyError {
+		seen := make(map[TK]struct{}, len(keys))
+		for _, key := range keys {
+			if _, ok := seen[key]; ok {
+				errs[key] = ErrDuplicateKey
+			}
+			seen[key] = struct{}{}
+		}
+		if len(errs) > 0 {
+			for _, key := range keys {
+				if _, ok := errs[key]; !ok {
+					errs[key] = fmt.Errorf("%w: %w", ErrNotStarted, ErrDuplicateKey)
+				}
+			}
+			return results, errs
+		}
+	}
+
+	if fn == nil {
+		for _, key := range keys {
+			errs[key] = ErrNilWorkerFunc
+		}
+		return results, errs
+	}
+
+	values := make([]R, len(tasks))
+	taskErrs := RunWorkerPool(ctx, indexes(len(tasks)), maxWorkers, func(ctx context.Context, i int, store map[K]V) (err error) {
+		values[i], err = fn(ctx, tasks[i], store)
+		return err
+	}, append(slices.Clone(opts), withIndexedTasks(tasks), withoutDedup)...)
+
+	for i, key := range keys {
+		if taskErrs[i] != nil {
+			errs[key] = taskErrs[i]
+			delete(results, key)
+		} else {
+			results[key] = values[i]
+			delete(errs, key)
+		}
+	}
+
+	return results, errs
+}
+
+func indexes(n int) []int {
+	s := make([]int, n)
+	for i := range s {
+		s[i] = i
+	}
+	return s
+}
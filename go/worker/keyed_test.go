@@ -0,0 +1,79 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/kdnetwork/code-snippet/go/worker"
+)
+
+type keyedTask struct {
+	ID    string
+	Value int
+}
+
+func TestRunWorkerPoolKeyed(t *testing.T) {
+	keyOf := func(task keyedTask) string { return task.ID }
+	double := func(ctx context.Context, task keyedTask, store map[string]int) (int, error) {
+		if task.Value < 0 {
+			return 0, fmt.Errorf("negative value %d", task.Value)
+		}
+		return task.Value * 2, nil
+	}
+
+	t.Run("Lookup", func(t *testing.T) {
+		tasks := []keyedTask{{"a", 1}, {"b", 2}, {"c", -3}, {"d", 4}}
+
+		results, errs := worker.RunWorkerPoolKeyed(context.Background(), tasks, 2, keyOf, worker.DuplicateKeyLastWins, double)
+
+		for id, want := range map[string]int{"a": 2, "b": 4, "d": 8} {
+			if got, ok := results[id]; !ok || got != want {
+				t.Errorf("results[%s] = %d (%v), want %d", id, got, ok, want)
+			}
+		}
+		if _, ok := results["c"]; ok || errs["c"] == nil {
+			t.Errorf("task c should only be in errs, results %v, errs %v", results, errs)
+		}
+		if len(errs) != 1 {
+			t.Errorf("Expected 1 error, got %v", errs)
+		}
+	})
+
+	t.Run("DuplicateLastWins", func(t *testing.T) {
+		tasks := []keyedTask{{"a", -1}, {"b", 2}, {"a", 5}}
+
+		results, errs := worker.RunWorkerPoolKeyed(context.Background(), tasks, 2, keyOf, worker.DuplicateKeyLastWins, double)
+
+		if results["a"] != 10 || errs["a"] != nil {
+			t.Errorf("last task of key a should win, results %v, errs %v", results, errs)
+		}
+	})
+
+	t.Run("DuplicateError", func(t *testing.T) {
+		tasks := []keyedTask{{"a", 1}, {"b", 2}, {"a", 5}, {"c", 3}}
+		ran := false
+
+		results, errs := worker.RunWorkerPoolKeyed(context.Background(), tasks, 2, keyOf, worker.DuplicateKeyError, func(ctx context.Context, task keyedTask, store map[string]int) (int, error) {
+			ran = true
+			return task.Value, nil
+		})
+
+		if ran {
+			t.Error("no task should run when keys are duplicated")
+		}
+		if len(results) != 0 || len(errs) != 3 {
+			t.Fatalf("Expected every key in errs, results %v, errs %v", results, errs)
+		}
+		if !errors.Is(errs["a"], worker.ErrDuplicateKey) || errors.Is(errs["a"], worker.ErrNotStarted) {
+			t.Errorf("Expected errs[a] = ErrDuplicateKey, got %v", errs["a"])
+		}
+		// unique keys, not run because of a
+		for _, id := range []string{"b", "c"} {
+			if !errors.Is(errs[id], worker.ErrNotStarted) || !errors.Is(errs[id], worker.ErrDuplicateKey) {
+				t.Errorf("Expected errs[%s] = ErrNotStarted wrapping ErrDuplicateKey, got %v", id, errs[id])
+			}
+		}
+	})
+}
@@ -0,0 +1,44 @@
+package worker
+
+import "context"
+
+type globalLimitKey struct{}
+type heldSlotKey struct{}
+
+type globalLimit struct {
+	slots chan struct{}
+}
+
+// WithGlobalLimit caps the number of tasks running at once across every
+// RunWorkerPool using the returned ctx, including pools nested inside fn.
+//
+// A nested pool inherits the slot of the task that started it for one of its
+// workers, so nesting never deadlocks on the limit.
+func WithGlobalLimit(ctx context.Context, maxConcurrent int) context.Context {
+	return context.WithValue(ctx, globalLimitKey{}, &globalLimit{
+		slots: make(chan struct{}, max(1, maxConcurrent)),
+	})
+}
+
+func globalLimitFrom(ctx context.Context) *globalLimit {
+	limit, _ := ctx.Value(globalLimitKey{}).(*globalLimit)
+	return limit
+}
+
+func holdsSlot(ctx context.Context) bool {
+	held, _ := ctx.Value(heldSlotKey{}).(bool)
+	return held
+}
+
+func (l *globalLimit) acquire(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case l.slots <- struct{}{}:
+		return nil
+	}
+}
+
+func (l *globalLimit) release() {
+	<-l.slots
+}
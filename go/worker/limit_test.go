@@ -0,0 +1,58 @@
+package worker_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kdnetwork/code-snippet/go/worker"
+)
+
+func TestWithGlobalLimit(t *testing.T) {
+	const globalCap = 3
+
+	var running, peak, leaves int64
+	observe := func() {
+		cur := atomic.AddInt64(&running, 1)
+		defer atomic.AddInt64(&running, -1)
+		for {
+			old := atomic.LoadInt64(&peak)
+			if cur <= old || atomic.CompareAndSwapInt64(&peak, old, cur) {
+				break
+			}
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	ctx := worker.WithGlobalLimit(context.Background(), globalCap)
+
+	outer := []int{1, 2, 3, 4, 5, 6}
+	errs := worker.RunWorkerPool[int, string, int](ctx, outer, len(outer), func(ctx context.Context, task int, store map[string]int) error {
+		inner := make([]int, 8)
+		for _, err := range worker.RunWorkerPool[int, string, int](ctx, inner, len(inner), func(ctx context.Context, task int, store map[string]int) error {
+			observe()
+			atomic.AddInt64(&leaves, 1)
+			return nil
+		}) {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("Nested pool failed: %v", err)
+		}
+	}
+
+	if leaves != int64(len(outer)*8) {
+		t.Errorf("Expected %d leaf tasks, got %d", len(outer)*8, leaves)
+	}
+	if peak > globalCap {
+		t.Errorf("Global concurrency exceeded the cap: peak %d > %d", peak, globalCap)
+	}
+	t.Logf("Peak concurrency: %d (cap %d)", peak, globalCap)
+}
@@ -0,0 +1,223 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+type options struct {
+	panicPolicy PanicPolicy
+
+	// func(ctx context.Context, store map[K]V) error
+	storeFlush    any
+	flushInterval int
+
+	// func(ctx context.Context, task T) (context.Context, func(err error))
+	taskHook any
+
+	// func(task T) any, the key of WithDedup
+	dedupKey any
+
+	// func(index int, task T, err error)
+	onError any
+
+	breaker *circuitBreaker
+
+	startJitter time.Duration
+
+	// RunWorkerPoolBatch only, nil -> fixed size
+	adaptiveBatch *adaptiveBatch
+
+	// StreamPool only
+	resultBuffer    int
+	resultBufferSet bool
+}
+
+type Option func(*options)
+
+func newOptions(opts []Option) *options {
+	o := new(options)
+	for _, opt := range opts {
+		if opt != nil {
+			opt(o)
+		}
+	}
+	return o
+}
+
+// WithPanicPolicy -> what a panicking task does to the pool, default
+// PanicRecover
+func WithPanicPolicy(policy PanicPolicy) Option {
+	return func(o *options) {
+		o.panicPolicy = policy
+	}
+}
+
+// WithCircuitBreaker short-circuits tasks with ErrCircuitOpen (fn isn't
+// called) after threshold consecutive failures across the workers of the
+// pool; after cooldown a single task runs as a probe, success closes the
+// breaker, failure opens it for another cooldown
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(o *options) {
+		o.breaker = &circuitBreaker{threshold: max(threshold, 1), cooldown: cooldown}
+	}
+}
+
+// WithStartJitter makes every worker sleep a random [0, d) before its first
+// task, so the opening burst doesn't hit a shared resource all at once
+func WithStartJitter(d time.Duration) Option {
+	return func(o *options) {
+		o.startJitter = max(d, 0)
+	}
+}
+
+// false -> ctx ended during the sleep
+func (o *options) sleepStartJitter(ctx context.Context) bool {
+	if o.startJitter <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(rand.N(o.startJitter))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// WithAdaptiveBatch makes RunWorkerPoolBatch resize its batches after each
+// one so a batch takes about target, between 1 and maxSize (<= 0 -> no
+// limit) tasks
+func WithAdaptiveBatch(target time.Duration, maxSize int) Option {
+	return func(o *options) {
+		if target <= 0 {
+			o.adaptiveBatch = nil
+			return
+		}
+		o.adaptiveBatch = &adaptiveBatch{target: target, maxSize: maxSize}
+	}
+}
+
+// WithResultBuffer -> capacity of Results() of a StreamPool, default
+// maxWorkers, 0 -> unbuffered; workers block (until Stop) while it's full, so
+// at most size+maxWorkers results wait for the consumer
+func WithResultBuffer(size int) Option {
+	return func(o *options) {
+		o.resultBuffer = max(size, 0)
+		o.resultBufferSet = true
+	}
+}
+
+// WithStoreFlush calls flush with the store of a worker after every interval
+// tasks it ran, and once more when the worker exits (if it ran tasks since),
+// flush may reset the store; K, V must match the pool
+func WithStoreFlush[K comparable, V any](interval int, flush func(ctx context.Context, store map[K]V) error) Option {
+	return func(o *options) {
+		o.storeFlush = flush
+		o.flushInterval = max(interval, 1)
+	}
+}
+
+func storeFlushOf[K comparable, V any](o *options) func(ctx context.Context, store map[K]V) error {
+	if o.storeFlush == nil {
+		return nil
+	}
+	flush, ok := o.storeFlush.(func(ctx context.Context, store map[K]V) error)
+	if !ok {
+		panic(fmt.Sprintf("worker: WithStoreFlush expects %T, got %T", flush, o.storeFlush))
+	}
+	return flush
+}
+
+// WithTaskHook calls hook before every task, fn gets the returned ctx and
+// done gets the error of the task (*PanicError included), for tracing,
+// metrics...; T must match the pool
+func WithTaskHook[T any](hook func(ctx context.Context, task T) (context.Context, func(err error))) Option {
+	return func(o *options) {
+		o.taskHook = hook
+	}
+}
+
+func taskHookOf[T any](o *options) func(ctx context.Context, task T) (context.Context, func(err error)) {
+	if o.taskHook == nil {
+		return nil
+	}
+	hook, ok := o.taskHook.(func(ctx context.Context, task T) (context.Context, func(err error)))
+	if !ok {
+		panic(fmt.Sprintf("worker: WithTaskHook expects %T, got %T", hook, o.taskHook))
+	}
+	return hook
+}
+
+// WithDedup coalesces tasks with the same keyOf(task) while one of them is
+// running: the duplicates don't call fn and get its error; a key is free
+// again once its task finished. RunWorkerPool, StartWorkerPool and
+// StreamPool only, pools with per-task outputs (RunWorkerPoolKeyed,
+// RunWorkerPoolWriter) ignore it; T must match the pool
+func WithDedup[T any, TK comparable](keyOf func(task T) TK) Option {
+	return func(o *options) {
+		o.dedupKey = func(task T) any { return keyOf(task) }
+	}
+}
+
+func dedupKeyOf[T any](o *options) func(task T) any {
+	if o.dedupKey == nil {
+		return nil
+	}
+	keyOf, ok := o.dedupKey.(func(task T) any)
+	if !ok {
+		panic(fmt.Sprintf("worker: WithDedup expects %T, got %T", keyOf, o.dedupKey))
+	}
+	return keyOf
+}
+
+// WithOnError calls onError as soon as a task fails, one call at a time,
+// index is the one of the task in tasks and err is its error (the one
+// returned in errs, flush errors included); tasks that never started and
+// panics dropped by PanicIgnore aren't reported, neither is the flush of a
+// worker exiting. RunWorkerPool and the pools built on it (StartWorkerPool,
+// RunWorkerPoolKeyed, RunWorkerPoolWriter...); T must match the pool
+func WithOnError[T any](onError func(index int, task T, err error)) Option {
+	return func(o *options) {
+		o.onError = onError
+	}
+}
+
+func onErrorOf[T any](o *options) func(index int, task T, err error) {
+	if o.onError == nil {
+		return nil
+	}
+	onError, ok := o.onError.(func(index int, task T, err error))
+	if !ok {
+		panic(fmt.Sprintf("worker: WithOnError expects %T, got %T", onError, o.onError))
+	}
+	return onError
+}
+
+// the outputs of a duplicate would stay empty
+func withoutDedup(o *options) {
+	o.dedupKey = nil
+}
+
+// pools running RunWorkerPool on indexes(len(tasks)) append it to opts, the
+// hook, the dedup key and onError still get tasks[i]
+func withIndexedTasks[T any](tasks []T) Option {
+	return func(o *options) {
+		if hook := taskHookOf[T](o); hook != nil {
+			o.taskHook = func(ctx context.Context, i int) (context.Context, func(err error)) {
+				return hook(ctx, tasks[i])
+			}
+		}
+		if keyOf := dedupKeyOf[T](o); keyOf != nil {
+			o.dedupKey = func(i int) any { return keyOf(tasks[i]) }
+		}
+		if onError := onErrorOf[T](o); onError != nil {
+			o.onError = func(index int, i int, err error) { onError(index, tasks[i], err) }
+		}
+	}
+}
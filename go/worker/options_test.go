@@ -0,0 +1,273 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kdnetwork/code-snippet/go/worker"
+)
+
+func TestWithStoreFlush(t *testing.T) {
+	tasks := make([]int, 25)
+	for i := range tasks {
+		tasks[i] = i
+	}
+	fn := func(ctx context.Context, task int, store map[string]int) error {
+		store["seen"]++
+		store["pending"] += task
+		return nil
+	}
+
+	t.Run("SingleWorker", func(t *testing.T) {
+		var flushed []int
+		sum := 0
+		errs := worker.RunWorkerPool(context.Background(), tasks, 1, fn, worker.WithStoreFlush(10, func(ctx context.Context, store map[string]int) error {
+			flushed = append(flushed, store["seen"])
+			sum += store["pending"]
+			store["pending"] = 0
+			return nil
+		}))
+
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("task %d failed: %v", i, err)
+			}
+		}
+		if !reflect.DeepEqual(flushed, []int{10, 20, 25}) {
+			t.Errorf("Expected flushes after 10, 20 and 25 tasks, got %v", flushed)
+		}
+		if sum != 300 { // 0 + 1 + ... + 24
+			t.Errorf("Expected every task to be flushed once, got sum %d", sum)
+		}
+	})
+
+	t.Run("PerWorker", func(t *testing.T) {
+		var mu sync.Mutex
+		flushes := make(map[uintptr]int)
+		seen := make(map[uintptr]int)
+
+		worker.RunWorkerPool(context.Background(), tasks, 3, fn, worker.WithStoreFlush(10, func(ctx context.Context, store map[string]int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			id := reflect.ValueOf(store).Pointer()
+			flushes[id]++
+			seen[id] = store["seen"]
+			return nil
+		}))
+
+		total := 0
+		for id, n := range seen {
+			total += n
+			if want := (n + 9) / 10; flushes[id] != want {
+				t.Errorf("worker with %d tasks: expected %d flushes, got %d", n, want, flushes[id])
+			}
+		}
+		if total != len(tasks) {
+			t.Errorf("Expected the final flushes to cover %d tasks, got %d", len(tasks), total)
+		}
+	})
+
+	t.Run("FlushError", func(t *testing.T) {
+		errFlush := errors.New("flush failed")
+		errs := worker.RunWorkerPool(context.Background(), tasks, 1, fn, worker.WithStoreFlush(10, func(ctx context.Context, store map[string]int) error {
+			if store["seen"] == 20 {
+				return errFlush
+			}
+			return nil
+		}))
+
+		for i, err := range errs {
+			if (i == 19) != errors.Is(err, errFlush) {
+				t.Errorf("task %d: unexpected error %v", i, err)
+			}
+		}
+	})
+
+	t.Run("StoreTypeMismatch", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected a panic for a mismatched store type")
+			}
+		}()
+		worker.RunWorkerPool(context.Background(), tasks, 1, fn, worker.WithStoreFlush(10, func(ctx context.Context, store map[string]string) error {
+			return nil
+		}))
+	})
+}
+
+func TestWithTaskHook(t *testing.T) {
+	type hookKey struct{}
+	tasks := []string{"a", "b", "c"}
+
+	var mu sync.Mutex
+	hooked := map[string]error{}
+	hook := worker.WithTaskHook(func(ctx context.Context, task string) (context.Context, func(err error)) {
+		return context.WithValue(ctx, hookKey{}, task), func(err error) {
+			mu.Lock()
+			hooked[task] = err
+			mu.Unlock()
+		}
+	})
+
+	errB := errors.New("b failed")
+	fn := func(ctx context.Context, task string, store map[string]int) error {
+		if ctx.Value(hookKey{}) != task {
+			t.Errorf("task %s: expected the ctx of the hook", task)
+		}
+		if task == "b" {
+			return errB
+		}
+		return nil
+	}
+
+	check := func(t *testing.T) {
+		if len(hooked) != len(tasks) {
+			t.Fatalf("Expected the hook on every task, got %v", hooked)
+		}
+		for _, task := range tasks {
+			if (task == "b") != errors.Is(hooked[task], errB) {
+				t.Errorf("task %s: unexpected error %v given to done", task, hooked[task])
+			}
+		}
+	}
+
+	t.Run("RunWorkerPool", func(t *testing.T) {
+		clear(hooked)
+		worker.RunWorkerPool(context.Background(), tasks, 2, fn, hook)
+		check(t)
+	})
+
+	// runs RunWorkerPool on the indexes of tasks
+	t.Run("StartWorkerPool", func(t *testing.T) {
+		clear(hooked)
+		worker.StartWorkerPool(context.Background(), tasks, 2, fn, hook).Wait()
+		check(t)
+	})
+}
+
+func TestWithStartJitter(t *testing.T) {
+	const workers = 8
+	jitter := 200 * time.Millisecond
+
+	t.Run("Spread", func(t *testing.T) {
+		// each task outlasts the jitter, so every worker takes exactly one
+		var mu sync.Mutex
+		var starts []time.Time
+		errs := worker.RunWorkerPool(context.Background(), make([]int, workers), workers, func(ctx context.Context, task int, store map[string]int) error {
+			mu.Lock()
+			starts = append(starts, time.Now())
+			mu.Unlock()
+			time.Sleep(jitter + 50*time.Millisecond)
+			return nil
+		}, worker.WithStartJitter(jitter))
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("task %d failed: %v", i, err)
+			}
+		}
+
+		if len(starts) != workers {
+			t.Fatalf("Expected %d starts, got %d", workers, len(starts))
+		}
+		first, last := slices.MinFunc(starts, time.Time.Compare), slices.MaxFunc(starts, time.Time.Compare)
+		if spread := last.Sub(first); spread < 20*time.Millisecond || spread >= jitter+50*time.Millisecond {
+			t.Errorf("Expected first tasks spread over [0, %v), got %v", jitter, spread)
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		errs := worker.RunWorkerPool(ctx, make([]int, workers), workers, func(ctx context.Context, task int, store map[string]int) error {
+			return nil
+		}, worker.WithStartJitter(time.Hour))
+		for i, err := range errs {
+			if !errors.Is(err, worker.ErrNotStarted) {
+				t.Errorf("task %d: expected ErrNotStarted, got %v", i, err)
+			}
+		}
+	})
+}
+
+func TestWithDedup(t *testing.T) {
+	type refresh struct {
+		key string
+		id  int
+	}
+	keyOf := func(task refresh) string { return task.key }
+
+	tasks := make([]refresh, 10)
+	for i := range tasks {
+		tasks[i] = refresh{key: "users", id: i}
+	}
+
+	t.Run("RunWorkerPool", func(t *testing.T) {
+		var calls atomic.Int64
+		errs := worker.RunWorkerPool(context.Background(), tasks, len(tasks), func(ctx context.Context, task refresh, store map[string]int) error {
+			calls.Add(1)
+			time.Sleep(100 * time.Millisecond)
+			return fmt.Errorf("refresh %d failed", task.id)
+		}, worker.WithDedup(keyOf))
+
+		if n := calls.Load(); n != 1 {
+			t.Errorf("Expected fn to run once, ran %d times", n)
+		}
+		for i, err := range errs {
+			if err == nil || err != errs[0] {
+				t.Errorf("task %d: expected the shared error %v, got %v", i, errs[0], err)
+			}
+		}
+	})
+
+	t.Run("StreamPool", func(t *testing.T) {
+		var calls atomic.Int64
+		release := make(chan struct{})
+		pool := worker.StartStreamPool(context.Background(), len(tasks), func(ctx context.Context, task refresh, store map[string]int) error {
+			calls.Add(1)
+			<-release
+			return nil
+		}, worker.WithDedup(keyOf))
+
+		for _, task := range tasks {
+			if err := pool.Submit(task); err != nil {
+				t.Fatalf("Submit failed: %v", err)
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+
+		results := pool.Drain()
+		if len(results) != len(tasks) {
+			t.Fatalf("Expected %d results, got %d", len(tasks), len(results))
+		}
+		for _, res := range results {
+			if res.Err != nil {
+				t.Errorf("task %d failed: %v", res.Index, res.Err)
+			}
+		}
+		if n := calls.Load(); n != 1 {
+			t.Errorf("Expected fn to run once, ran %d times", n)
+		}
+	})
+
+	t.Run("NotInFlight", func(t *testing.T) {
+		// a single worker runs them one after the other
+		var calls atomic.Int64
+		worker.RunWorkerPool(context.Background(), tasks[:3], 1, func(ctx context.Context, task refresh, store map[string]int) error {
+			calls.Add(1)
+			return nil
+		}, worker.WithDedup(keyOf))
+
+		if n := calls.Load(); n != 3 {
+			t.Errorf("Expected fn to run 3 times, ran %d times", n)
+		}
+	})
+}
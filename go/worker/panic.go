@@ -0,0 +1,43 @@
+package worker
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+type PanicPolicy int
+
+const (
+	// the panic becomes the *PanicError of the task
+	PanicRecover PanicPolicy = iota
+	// recovered, then re-panicked with the first value once every worker
+	// stopped
+	PanicPropagate
+	// recovered, the task counts as succeeded
+	PanicIgnore
+)
+
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("worker task panicked: %v", e.Value)
+}
+
+// Unwrap -> the value of panic(err)
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+func runTask(fn func() error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+
+	return fn()
+}
@@ -0,0 +1,130 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kdnetwork/code-snippet/go/worker"
+)
+
+func TestPanicPolicy(t *testing.T) {
+	tasks := []int{1, 2, 3, 4}
+	fn := func(ctx context.Context, task int, store map[string]int) error {
+		if task == 3 {
+			panic("boom")
+		}
+		return nil
+	}
+
+	t.Run("Recover", func(t *testing.T) {
+		errs := worker.RunWorkerPool(context.Background(), tasks, 2, fn)
+
+		var panicErr *worker.PanicError
+		if !errors.As(errs[2], &panicErr) || panicErr.Value != "boom" {
+			t.Fatalf("Expected a PanicError with the panic value for task 3, got %v", errs[2])
+		}
+		if len(panicErr.Stack) == 0 {
+			t.Error("PanicError should carry the stack")
+		}
+		for _, i := range []int{0, 1, 3} {
+			if errs[i] != nil {
+				t.Errorf("task %d should succeed, got %v", tasks[i], errs[i])
+			}
+		}
+	})
+
+	t.Run("Propagate", func(t *testing.T) {
+		var executed [4]bool
+		defer func() {
+			if v := recover(); v != "boom" {
+				t.Errorf("Expected the pool to re-panic with boom, got %v", v)
+			}
+			for i, ok := range executed {
+				if !ok {
+					t.Errorf("task %d should have run before the re-panic", tasks[i])
+				}
+			}
+		}()
+
+		worker.RunWorkerPool(context.Background(), tasks, 2, func(ctx context.Context, task int, store map[string]int) error {
+			executed[task-1] = true
+			return fn(ctx, task, store)
+		}, worker.WithPanicPolicy(worker.PanicPropagate))
+
+		t.Error("RunWorkerPool should not return")
+	})
+
+	t.Run("Ignore", func(t *testing.T) {
+		errs := worker.RunWorkerPool(context.Background(), tasks, 2, fn, worker.WithPanicPolicy(worker.PanicIgnore))
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("task %d: panic should be ignored, got %v", tasks[i], err)
+			}
+		}
+	})
+
+	t.Run("PanicWithError", func(t *testing.T) {
+		errBoom := errors.New("boom")
+		errs := worker.RunWorkerPool(context.Background(), []int{1}, 1, func(ctx context.Context, task int, store map[string]int) error {
+			panic(errBoom)
+		})
+		if !errors.Is(errs[0], errBoom) {
+			t.Errorf("Expected PanicError to unwrap to the panicked error, got %v", errs[0])
+		}
+	})
+}
+
+func TestStreamPoolPanicPolicy(t *testing.T) {
+	fn := func(ctx context.Context, task int, store map[string]int) error {
+		if task == 3 {
+			panic("boom")
+		}
+		return nil
+	}
+	run := func(opts ...worker.Option) []worker.Result[int] {
+		s := worker.StartStreamPool(context.Background(), 2, fn, opts...)
+		for i := range 5 {
+			if err := s.Submit(i); err != nil {
+				t.Fatalf("Submit failed: %v", err)
+			}
+		}
+		return s.Drain()
+	}
+
+	t.Run("Recover", func(t *testing.T) {
+		results := run()
+		if len(results) != 5 {
+			t.Fatalf("Expected 5 results, got %d", len(results))
+		}
+
+		var panicErr *worker.PanicError
+		if !errors.As(results[3].Err, &panicErr) || panicErr.Value != "boom" {
+			t.Errorf("Expected a PanicError with the panic value for task 3, got %v", results[3].Err)
+		}
+		for _, i := range []int{0, 1, 2, 4} {
+			if results[i].Err != nil {
+				t.Errorf("task %d should succeed, got %v", i, results[i].Err)
+			}
+		}
+	})
+
+	t.Run("Propagate", func(t *testing.T) {
+		defer func() {
+			if v := recover(); v != "boom" {
+				t.Errorf("Expected Drain to re-panic with boom, got %v", v)
+			}
+		}()
+
+		run(worker.WithPanicPolicy(worker.PanicPropagate))
+		t.Error("Drain should not return")
+	})
+
+	t.Run("Ignore", func(t *testing.T) {
+		for _, res := range run(worker.WithPanicPolicy(worker.PanicIgnore)) {
+			if res.Err != nil {
+				t.Errorf("task %d: panic should be ignored, got %v", res.Task, res.Err)
+			}
+		}
+	})
+}
@@ -2,61 +2,307 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/kdnetwork/code-snippet/go/utils"
 )
 
-func RunWorkerPool[T any, K comparable, V any](ctx context.Context, tasks []T, maxWorkers int, fn func(ctx context.Context, task T, store map[K]V) error) []error {
+var (
+	ErrAborted = errors.New("worker pool aborted")
+	// the task never ran because the pool was cancelled or aborted first
+	ErrNotStarted = errors.New("worker task not started")
+	// every task gets it when the pool is started with a nil fn
+	ErrNilWorkerFunc = errors.New("worker pool started with a nil fn")
+	// returned by fn (wrapped or not): the task succeeded (its error is nil)
+	// and the pool stops like Abort, the tasks that haven't started get
+	// ErrNotStarted wrapping ErrStop, the tasks still running see it as the
+	// cause of their ctx
+	ErrStop = errors.New("worker pool stopped")
+)
+
+type abortKey struct{}
+
+// Abort cancels the RunWorkerPool that ctx (the ctx passed to fn) belongs to,
+// tasks that haven't started yet get ErrNotStarted wrapping ErrAborted
+func Abort(ctx context.Context) {
+	if abort, ok := ctx.Value(abortKey{}).(context.CancelCauseFunc); ok {
+		abort(ErrAborted)
+	}
+}
+
+// RunWorkerPool returns one error per task, errs[i] belongs to tasks[i]
+//
+// when ctx ends (or Abort, or fn returns ErrStop) partway, the tasks that
+// never ran get ErrNotStarted wrapping the cause, the others keep their
+// result, see PartialResults
+func RunWorkerPool[T any, K comparable, V any](ctx context.Context, tasks []T, maxWorkers int, fn func(ctx context.Context, task T, store map[K]V) error, opts ...Option) []error {
+	errs, _ := runWorkerPool(ctx, tasks, maxWorkers, fn, opts...)
+	return errs
+}
+
+// RunWorkerPoolStores is RunWorkerPool also returning the store of every
+// worker as it was when the worker exited, stores[i] belongs to the i-th
+// worker (at most len(tasks) workers), a worker that ran no task has an empty
+// store
+func RunWorkerPoolStores[T any, K comparable, V any](ctx context.Context, tasks []T, maxWorkers int, fn func(ctx context.Context, task T, store map[K]V) error, opts ...Option) (errs []error, stores []map[K]V) {
+	return runWorkerPool(ctx, tasks, maxWorkers, fn, opts...)
+}
+
+func runWorkerPool[T any, K comparable, V any](ctx context.Context, tasks []T, maxWorkers int, fn func(ctx context.Context, task T, store map[K]V) error, opts ...Option) ([]error, []map[K]V) {
+	o := newOptions(opts)
 	tasksLen := len(tasks)
 
 	if tasksLen == 0 {
-		return []error{}
+		return []error{}, []map[K]V{}
+	}
+	if fn == nil {
+		return nilFuncErrors(tasksLen), []map[K]V{}
 	}
 
 	maxWorkers = utils.Clamp(tasksLen, 1, maxWorkers)
+	stores := make([]map[K]V, maxWorkers)
+
+	flush := storeFlushOf[K, V](o)
+	hook := taskHookOf[T](o)
+	onError := onErrorOf[T](o)
+	var onErrorMu sync.Mutex
+	var flights *flightGroup
+	dedupKey := dedupKeyOf[T](o)
+	if dedupKey != nil {
+		flights = newFlightGroup()
+	}
+	parentCtx := ctx
+
+	ctx, abort := context.WithCancelCause(ctx)
+	defer abort(nil)
 
-	tasksChan := make(chan T, tasksLen)
-	errorsChan := make(chan error, tasksLen)
+	tasksChan := make(chan int, tasksLen)
+	for i := range tasks {
+		tasksChan <- i
+	}
+	close(tasksChan)
+
+	errs := make([]error, tasksLen)
+	started := make([]bool, tasksLen)
+
+	// wakes up the workers still waiting on the global limit once every task
+	// is done
+	var remaining atomic.Int64
+	remaining.Store(int64(tasksLen))
+
+	limit := globalLimitFrom(ctx)
+	inheritSlot := limit != nil && holdsSlot(ctx)
+	taskCtx := context.WithValue(ctx, abortKey{}, abort)
+	if limit != nil {
+		taskCtx = context.WithValue(taskCtx, heldSlotKey{}, true)
+	}
+
+	var firstPanic atomic.Pointer[PanicError]
 
 	var wg sync.WaitGroup
 
-	for range maxWorkers {
+	for i := range maxWorkers {
 		wg.Go(func() {
+			// nested pool: the first worker runs on the slot of the parent task
+			acquireSlot := limit != nil && !(inheritSlot && i == 0)
+			releaseSlot := func() {
+				if acquireSlot {
+					limit.release()
+				}
+			}
+
 			store := make(map[K]V)
+			stores[i] = store
+
+			runOnce := func(index int) error {
+				var generation uint64
+				if o.breaker != nil {
+					var ok bool
+					if generation, ok = o.breaker.allow(); !ok {
+						return ErrCircuitOpen
+					}
+				}
+
+				runCtx, done := taskCtx, func(error) {}
+				if hook != nil {
+					runCtx, done = hook(taskCtx, tasks[index])
+				}
+				err := runTask(func() error { return fn(runCtx, tasks[index], store) })
+				// a task still running after the stop returns the ctx cause, it
+				// was cancelled and keeps it
+				stop := errors.Is(err, ErrStop) && ctx.Err() == nil
+				if stop {
+					err = nil
+				}
+				done(err)
+
+				if o.breaker != nil {
+					o.breaker.record(generation, err)
+				}
+				if stop {
+					abort(ErrStop)
+				}
+				return err
+			}
+
+			// duplicates wait for the running task and share its error
+			run := runOnce
+			if flights != nil {
+				run = func(index int) error {
+					return flights.do(dedupKey(tasks[index]), func() error { return runOnce(index) })
+				}
+			}
+
+			// flush errors join the error of the last task of the worker
+			sinceFlush, lastIndex := 0, -1
+			if flush != nil {
+				defer func() {
+					// ctx itself is cancelled once every task is done
+					if sinceFlush > 0 {
+						errs[lastIndex] = errors.Join(errs[lastIndex], runTask(func() error { return flush(parentCtx, store) }))
+					}
+				}()
+			}
+
+			if !o.sleepStartJitter(ctx) {
+				return
+			}
+
 			for {
+				// take the slot before the task, a worker waiting on the limit
+				// must not sit on a task the inheriting worker could run
+				if acquireSlot && limit.acquire(ctx) != nil {
+					return
+				}
+
 				select {
 				case <-ctx.Done():
+					releaseSlot()
 					return
-				case task, ok := <-tasksChan:
-					if !ok {
+				case index, ok := <-tasksChan:
+					if !ok || ctx.Err() != nil {
+						releaseSlot()
 						return
 					}
-					errorsChan <- fn(ctx, task, store)
+					started[index] = true
+					errs[index] = run(index)
+					if panicErr, ok := errs[index].(*PanicError); ok {
+						firstPanic.CompareAndSwap(nil, panicErr)
+					}
+					if flush != nil {
+						sinceFlush++
+						lastIndex = index
+						if sinceFlush >= o.flushInterval {
+							errs[index] = errors.Join(errs[index], runTask(func() error { return flush(taskCtx, store) }))
+							sinceFlush = 0
+						}
+					}
+					if _, ignored := errs[index].(*PanicError); onError != nil && errs[index] != nil && !(ignored && o.panicPolicy == PanicIgnore) {
+						onErrorMu.Lock()
+						onError(index, tasks[index], errs[index])
+						onErrorMu.Unlock()
+					}
+					releaseSlot()
+					if remaining.Add(-1) == 0 {
+						abort(nil)
+					}
 				}
 			}
 		})
 	}
 
-	go func() {
-		defer close(tasksChan)
-		for _, task := range tasks {
-			select {
-			case <-ctx.Done():
-				return
-			case tasksChan <- task:
-			}
+	wg.Wait()
+
+	// ctx only ends early by Abort or by the parent ctx
+	for i := range errs {
+		if !started[i] {
+			errs[i] = fmt.Errorf("%w: %w", ErrNotStarted, context.Cause(ctx))
 		}
-	}()
+	}
 
-	go func() {
-		wg.Wait()
-		close(errorsChan)
-	}()
-	errs := make([]error, 0, tasksLen)
-	for range tasks {
-		errs = append(errs, <-errorsChan)
+	switch o.panicPolicy {
+	case PanicPropagate:
+		if panicErr := firstPanic.Load(); panicErr != nil {
+			panic(panicErr.Value)
+		}
+	case PanicIgnore:
+		for i, err := range errs {
+			if _, ok := err.(*PanicError); ok {
+				errs[i] = nil
+			}
+		}
 	}
 
+	return errs, stores
+}
+
+func nilFuncErrors(n int) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = ErrNilWorkerFunc
+	}
 	return errs
 }
+
+// PartialResults splits the indexes of errs (as returned by RunWorkerPool)
+// into tasks that ran (whatever their error) and tasks that never started
+func PartialResults(errs []error) (completed, notStarted []int) {
+	for i, err := range errs {
+		if errors.Is(err, ErrNotStarted) {
+			notStarted = append(notStarted, i)
+		} else {
+			completed = append(completed, i)
+		}
+	}
+	return completed, notStarted
+}
+
+// FilterErrors returns the non-nil errors of errs (as returned by
+// RunWorkerPool) in order, nil when every task succeeded
+func FilterErrors(errs []error) []error {
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	return failed
+}
+
+// CountErrors returns the number of non-nil errors in errs
+func CountErrors(errs []error) int {
+	count := 0
+	for _, err := range errs {
+		if err != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// RunWorkerPoolCPU is RunWorkerPool for CPU-bound fn: one worker per
+// GOMAXPROCS, more would only add scheduling overhead; long tasks can call
+// runtime.Gosched between steps to leave room to other goroutines
+func RunWorkerPoolCPU[T any, K comparable, V any](ctx context.Context, tasks []T, fn func(ctx context.Context, task T, store map[K]V) error, opts ...Option) []error {
+	maxWorkers := utils.Clamp(runtime.GOMAXPROCS(0), 1, max(len(tasks), 1))
+	return RunWorkerPool(ctx, tasks, maxWorkers, fn, opts...)
+}
+
+// RunWorkerPoolWithin is RunWorkerPool with a wall-clock budget for the whole
+// run, not per task: once totalDeadline elapsed no task is started anymore
+// (ErrNotStarted wrapping context.DeadlineExceeded, see PartialResults) and
+// the running ones see their ctx end, it returns near the deadline as long as
+// fn honours ctx; totalDeadline <= 0 -> no budget
+func RunWorkerPoolWithin[T any, K comparable, V any](ctx context.Context, tasks []T, maxWorkers int, totalDeadline time.Duration, fn func(ctx context.Context, task T, store map[K]V) error, opts ...Option) []error {
+	if totalDeadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, totalDeadline)
+		defer cancel()
+	}
+	return RunWorkerPool(ctx, tasks, maxWorkers, fn, opts...)
+}
@@ -4,6 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"runtime"
+	"slices"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -109,6 +113,29 @@ func TestRunWorkerPool(t *testing.T) {
 		}
 	})
 
+	t.Run("NilFunc", func(t *testing.T) {
+		errs := worker.RunWorkerPool[int, string, int](context.Background(), []int{1, 2, 3}, 2, nil)
+		if len(errs) != 3 {
+			t.Fatalf("Expected 3 errors, got %v", errs)
+		}
+		for i, err := range errs {
+			if !errors.Is(err, worker.ErrNilWorkerFunc) {
+				t.Errorf("task %d: expected ErrNilWorkerFunc, got %v", i, err)
+			}
+		}
+
+		_, keyedErrs := worker.RunWorkerPoolKeyed[int, int, int, string, int](context.Background(), []int{1, 2}, 2, func(task int) int { return task }, worker.DuplicateKeyLastWins, nil)
+		if len(keyedErrs) != 2 || !errors.Is(keyedErrs[1], worker.ErrNilWorkerFunc) {
+			t.Errorf("Expected ErrNilWorkerFunc per key, got %v", keyedErrs)
+		}
+
+		pool := worker.StartStreamPool[int, string, int](context.Background(), 1, nil)
+		pool.Submit(1)
+		if results := pool.Drain(); len(results) != 1 || !errors.Is(results[0].Err, worker.ErrNilWorkerFunc) {
+			t.Errorf("Expected ErrNilWorkerFunc from the stream pool, got %v", results)
+		}
+	})
+
 	t.Run("HighWorkerCountClamp", func(t *testing.T) {
 		// Test if maxWorkers > tasksLen works correctly via utils.Clamp
 		tasks := []int{1, 2}
@@ -129,3 +156,341 @@ func TestRunWorkerPool(t *testing.T) {
 		t.Logf("Pool with 2 tasks and 100 maxWorkers finished in %v", duration)
 	})
 }
+
+func TestAbort(t *testing.T) {
+	tasks := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	errPoison := errors.New("poison message")
+
+	var executed int64
+	errs := worker.RunWorkerPool[int, string, int](context.Background(), tasks, 1, func(ctx context.Context, task int, store map[string]int) error {
+		atomic.AddInt64(&executed, 1)
+		if task == 3 {
+			worker.Abort(ctx)
+			return errPoison
+		}
+		return nil
+	})
+
+	if executed != 4 {
+		t.Errorf("Expected 4 executed tasks, got %d", executed)
+	}
+	for i, err := range errs {
+		switch {
+		case i < 3 && err != nil:
+			t.Errorf("task %d should succeed, got %v", i, err)
+		case i == 3 && !errors.Is(err, errPoison):
+			t.Errorf("aborting task should keep its own error, got %v", err)
+		case i > 3 && !errors.Is(err, worker.ErrAborted):
+			t.Errorf("task %d should be marked aborted, got %v", i, err)
+		}
+	}
+
+	t.Run("ParentUnaffected", func(t *testing.T) {
+		parent, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		worker.RunWorkerPool[int, string, int](parent, tasks, 2, func(ctx context.Context, task int, store map[string]int) error {
+			worker.Abort(ctx)
+			return nil
+		})
+		if parent.Err() != nil {
+			t.Error("Abort should not cancel the parent context")
+		}
+	})
+}
+
+func TestStop(t *testing.T) {
+	tasks := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	results := make([]int, len(tasks))
+
+	errs := worker.RunWorkerPool[int, string, int](context.Background(), tasks, 1, func(ctx context.Context, task int, store map[string]int) error {
+		results[task] = task * 10
+		if task == 3 {
+			return fmt.Errorf("found it: %w", worker.ErrStop)
+		}
+		return nil
+	})
+
+	for i, err := range errs {
+		switch {
+		case i <= 3 && err != nil:
+			t.Errorf("task %d should succeed, got %v", i, err)
+		case i <= 3 && results[i] != i*10:
+			t.Errorf("task %d result should be kept, got %d", i, results[i])
+		case i > 3 && (!errors.Is(err, worker.ErrNotStarted) || !errors.Is(err, worker.ErrStop)):
+			t.Errorf("task %d should be cancelled by the stop, got %v", i, err)
+		case i > 3 && results[i] != 0:
+			t.Errorf("task %d should not have run", i)
+		}
+	}
+
+	t.Run("InFlightCancelled", func(t *testing.T) {
+		running := make(chan struct{})
+		errs := worker.RunWorkerPool[int, string, int](context.Background(), []int{0, 1}, 2, func(ctx context.Context, task int, store map[string]int) error {
+			if task == 0 {
+				<-running
+				return worker.ErrStop
+			}
+			close(running)
+			<-ctx.Done()
+			return context.Cause(ctx)
+		})
+
+		if errs[0] != nil {
+			t.Errorf("stopping task should succeed, got %v", errs[0])
+		}
+		if !errors.Is(errs[1], worker.ErrStop) {
+			t.Errorf("running task should see the stop as its ctx cause, got %v", errs[1])
+		}
+		if errors.Is(errs[1], worker.ErrNotStarted) {
+			t.Error("running task should not be marked as not started")
+		}
+	})
+}
+
+func TestPartialResults(t *testing.T) {
+	tasks := make([]int, 20)
+	for i := range tasks {
+		tasks[i] = i
+	}
+	results := make([]int, len(tasks))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := worker.RunWorkerPool[int, string, int](ctx, tasks, 2, func(ctx context.Context, task int, store map[string]int) error {
+		if task == 5 {
+			cancel()
+		}
+		time.Sleep(5 * time.Millisecond)
+		results[task] = task * task
+		return nil
+	})
+
+	completed, notStarted := worker.PartialResults(errs)
+	if len(completed) == 0 || len(notStarted) == 0 || len(completed)+len(notStarted) != len(tasks) {
+		t.Fatalf("Expected a partial run, got %d completed and %d not started", len(completed), len(notStarted))
+	}
+
+	for _, i := range completed {
+		if errs[i] != nil || results[i] != i*i {
+			t.Errorf("completed task %d lost its result: %d (%v)", i, results[i], errs[i])
+		}
+	}
+	for _, i := range notStarted {
+		if !errors.Is(errs[i], context.Canceled) {
+			t.Errorf("task %d should carry the cancellation cause, got %v", i, errs[i])
+		}
+		if results[i] != 0 {
+			t.Errorf("task %d should not have run", i)
+		}
+	}
+
+	t.Run("Aborted", func(t *testing.T) {
+		errs := worker.RunWorkerPool[int, string, int](context.Background(), tasks, 1, func(ctx context.Context, task int, store map[string]int) error {
+			if task == 9 {
+				worker.Abort(ctx)
+			}
+			return nil
+		})
+
+		completed, notStarted := worker.PartialResults(errs)
+		if len(completed) != 10 || len(notStarted) != 10 || notStarted[0] != 10 {
+			t.Errorf("Expected tasks 10~19 not started, got completed %v, not started %v", completed, notStarted)
+		}
+	})
+}
+
+func TestRunWorkerPoolWithin(t *testing.T) {
+	tasks := make([]int, 100)
+	for i := range tasks {
+		tasks[i] = i
+	}
+
+	const budget = 100 * time.Millisecond
+	start := time.Now()
+	errs := worker.RunWorkerPoolWithin[int, string, int](context.Background(), tasks, 4, budget, func(ctx context.Context, task int, store map[string]int) error {
+		select {
+		case <-time.After(30 * time.Millisecond):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	elapsed := time.Since(start)
+
+	if elapsed < budget || elapsed > 2*budget {
+		t.Errorf("Expected to return near the %v budget, took %v", budget, elapsed)
+	}
+
+	completed, notStarted := worker.PartialResults(errs)
+	if len(completed) == 0 || len(notStarted) == 0 {
+		t.Fatalf("Expected a partial run, got %d completed and %d not started", len(completed), len(notStarted))
+	}
+	for _, i := range notStarted {
+		if !errors.Is(errs[i], context.DeadlineExceeded) {
+			t.Errorf("task %d should carry the deadline, got %v", i, errs[i])
+		}
+	}
+	succeeded := 0
+	for _, i := range completed {
+		if errs[i] == nil {
+			succeeded++
+		}
+	}
+	if succeeded < 4 {
+		t.Errorf("Expected the tasks that fit in the budget to succeed, got %d", succeeded)
+	}
+
+	t.Run("NoBudget", func(t *testing.T) {
+		errs := worker.RunWorkerPoolWithin[int, string, int](context.Background(), tasks[:10], 2, 0, func(ctx context.Context, task int, store map[string]int) error {
+			if _, ok := ctx.Deadline(); ok {
+				return errors.New("unexpected deadline")
+			}
+			return nil
+		})
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("task %d: %v", i, err)
+			}
+		}
+	})
+}
+
+func TestRunWorkerPoolStores(t *testing.T) {
+	const workers = 3
+	tasks := make([]int, 30)
+	for i := range tasks {
+		tasks[i] = i + 1
+	}
+
+	// the first task of every worker waits for the others, so each one runs
+	// at least a task
+	var firstTasks sync.WaitGroup
+	firstTasks.Add(workers)
+
+	errs, stores := worker.RunWorkerPoolStores[int, string, int](context.Background(), tasks, workers, func(ctx context.Context, task int, store map[string]int) error {
+		if store["count"] == 0 {
+			firstTasks.Done()
+			firstTasks.Wait()
+		}
+		store["count"]++
+		store["sum"] += task
+		return nil
+	})
+
+	if n := worker.CountErrors(errs); n != 0 {
+		t.Fatalf("Expected no error, got %d", n)
+	}
+	if len(stores) != workers {
+		t.Fatalf("Expected %d stores, got %d", workers, len(stores))
+	}
+
+	count, sum := 0, 0
+	for i, store := range stores {
+		if store["count"] == 0 {
+			t.Errorf("worker %d should have run a task", i)
+		}
+		count += store["count"]
+		sum += store["sum"]
+	}
+	if count != len(tasks) || sum != 465 {
+		t.Errorf("Expected the stores to add up to 30 tasks summing to 465, got %d tasks summing to %d", count, sum)
+	}
+
+	t.Run("FewerTasks", func(t *testing.T) {
+		_, stores := worker.RunWorkerPoolStores[int, string, int](context.Background(), tasks[:2], 8, func(ctx context.Context, task int, store map[string]int) error {
+			return nil
+		})
+		if len(stores) != 2 {
+			t.Errorf("Expected one store per task when tasks < maxWorkers, got %d", len(stores))
+		}
+	})
+}
+
+func TestFilterErrors(t *testing.T) {
+	errA := errors.New("a")
+	errB := errors.New("b")
+
+	cases := []struct {
+		name  string
+		errs  []error
+		want  []error
+		count int
+	}{
+		{"AllNil", []error{nil, nil, nil}, nil, 0},
+		{"AllError", []error{errA, errB, errA}, []error{errA, errB, errA}, 3},
+		{"Mixed", []error{nil, errB, nil, errA, nil}, []error{errB, errA}, 2},
+		{"Empty", []error{}, nil, 0},
+		{"Nil", nil, nil, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := worker.FilterErrors(c.errs); !slices.Equal(got, c.want) {
+				t.Errorf("FilterErrors(%v) = %v, want %v", c.errs, got, c.want)
+			}
+			if got := worker.CountErrors(c.errs); got != c.count {
+				t.Errorf("CountErrors(%v) = %d, want %d", c.errs, got, c.count)
+			}
+		})
+	}
+}
+
+func TestOnError(t *testing.T) {
+	errOdd := errors.New("odd")
+	tasks := []int{10, 11, 12, 13, 14, 15, 16}
+
+	// serial calls, the map needs no lock
+	calls := make(map[int]int)
+	errs := worker.RunWorkerPool(context.Background(), tasks, 3, func(ctx context.Context, task int, store map[string]int) error {
+		if task%2 == 1 {
+			return errOdd
+		}
+		return nil
+	}, worker.WithOnError(func(index int, task int, err error) {
+		calls[index]++
+		if task != tasks[index] {
+			t.Errorf("OnError(%d) task = %d, want %d", index, task, tasks[index])
+		}
+		if !errors.Is(err, errOdd) {
+			t.Errorf("OnError(%d) err = %v, want %v", index, err, errOdd)
+		}
+	}))
+
+	if got := worker.CountErrors(errs); got != 3 {
+		t.Fatalf("errors = %d, want 3", got)
+	}
+	if want := map[int]int{1: 1, 3: 1, 5: 1}; !maps.Equal(calls, want) {
+		t.Errorf("OnError calls = %v, want %v", calls, want)
+	}
+}
+
+func TestRunWorkerPoolCPU(t *testing.T) {
+	const procs = 4
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+
+	// one store per worker
+	var mu sync.Mutex
+	workers := 0
+	tasks := make([]int, 1000)
+	errs := worker.RunWorkerPoolCPU(context.Background(), tasks, func(ctx context.Context, task int, store map[string]int) error {
+		if _, ok := store["worker"]; !ok {
+			mu.Lock()
+			workers++
+			store["worker"] = workers
+			mu.Unlock()
+		}
+		time.Sleep(50 * time.Microsecond)
+		return nil
+	})
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("task %d failed: %v", i, err)
+		}
+	}
+	if workers != procs {
+		t.Errorf("Expected %d workers (GOMAXPROCS), got %d", procs, workers)
+	}
+}
@@ -0,0 +1,216 @@
+package worker
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrStreamClosed = errors.New("stream pool closed")
+
+type Result[T any] struct {
+	Index int // submission order
+	Task  T
+	Err   error
+}
+
+type indexedTask[T any] struct {
+	index int
+	task  T
+}
+
+// StreamPool is a long-running worker pool fed through Submit, results are
+// delivered on Results() and must be consumed
+type StreamPool[T any] struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	tasks   chan indexedTask[T]
+	results chan Result[T]
+	nextIdx atomic.Int64
+
+	// Submit holds mu.RLock while sending, so tasks is closed only after
+	// every pending send returned
+	mu        sync.RWMutex
+	closed    bool
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	done chan struct{}
+
+	// PanicPropagate re-panics with it from Wait
+	propagate  bool
+	firstPanic atomic.Pointer[PanicError]
+}
+
+// StartStreamPool starts maxWorkers workers, opts -> WithResultBuffer,
+// WithCircuitBreaker, WithStartJitter, WithDedup, WithPanicPolicy
+func StartStreamPool[T any, K comparable, V any](ctx context.Context, maxWorkers int, fn func(ctx context.Context, task T, store map[K]V) error, opts ...Option) *StreamPool[T] {
+	o := newOptions(opts)
+	maxWorkers = max(maxWorkers, 1)
+	poolCtx, cancel := context.WithCancel(ctx)
+
+	resultBuffer := maxWorkers
+	if o.resultBufferSet {
+		resultBuffer = o.resultBuffer
+	}
+
+	s := &StreamPool[T]{
+		ctx:     poolCtx,
+		cancel:  cancel,
+		tasks:   make(chan indexedTask[T], maxWorkers),
+		results: make(chan Result[T], resultBuffer),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	s.propagate = o.panicPolicy == PanicPropagate
+
+	run := func(task T, store map[K]V) error {
+		if fn == nil {
+			return ErrNilWorkerFunc
+		}
+		var generation uint64
+		if o.breaker != nil {
+			var ok bool
+			if generation, ok = o.breaker.allow(); !ok {
+				return ErrCircuitOpen
+			}
+		}
+		err := runTask(func() error { return fn(poolCtx, task, store) })
+		if o.breaker != nil {
+			o.breaker.record(generation, err)
+		}
+		return err
+	}
+
+	var flights *flightGroup
+	dedupKey := dedupKeyOf[T](o)
+	if dedupKey != nil {
+		flights = newFlightGroup()
+	}
+
+	var wg sync.WaitGroup
+	for range maxWorkers {
+		wg.Go(func() {
+			if !o.sleepStartJitter(poolCtx) {
+				return
+			}
+
+			store := make(map[K]V)
+			for {
+				select {
+				case <-poolCtx.Done():
+					return
+				case it, ok := <-s.tasks:
+					if !ok {
+						return
+					}
+
+					// Stop may race with the receive above
+					if poolCtx.Err() != nil {
+						return
+					}
+
+					res := Result[T]{Index: it.index, Task: it.task}
+					if flights != nil {
+						res.Err = flights.do(dedupKey(it.task), func() error { return run(it.task, store) })
+					} else {
+						res.Err = run(it.task, store)
+					}
+					if panicErr, ok := res.Err.(*PanicError); ok {
+						s.firstPanic.CompareAndSwap(nil, panicErr)
+						if o.panicPolicy == PanicIgnore {
+							res.Err = nil
+						}
+					}
+					select {
+					case <-poolCtx.Done():
+						return
+					case s.results <- res:
+					}
+				}
+			}
+		})
+	}
+
+	go func() {
+		wg.Wait()
+		cancel()
+		close(s.results)
+		close(s.done)
+	}()
+
+	return s
+}
+
+// Submit queues a task, blocks while the queue is full
+func (s *StreamPool[T]) Submit(task T) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return ErrStreamClosed
+	}
+
+	select {
+	case <-s.ctx.Done():
+		return ErrStreamClosed
+	case <-s.closing:
+		return ErrStreamClosed
+	case s.tasks <- indexedTask[T]{index: int(s.nextIdx.Add(1) - 1), task: task}:
+		return nil
+	}
+}
+
+func (s *StreamPool[T]) Results() <-chan Result[T] {
+	return s.results
+}
+
+// Stop stops dispatching, queued tasks are abandoned and in-flight tasks see
+// their ctx cancelled. Doesn't wait, see Wait.
+func (s *StreamPool[T]) Stop() {
+	s.cancel()
+	s.closeInput()
+}
+
+// Drain stops accepting tasks (Submit -> ErrStreamClosed), lets the workers
+// finish every queued task and returns the results not read from Results()
+// yet, ordered by Index
+func (s *StreamPool[T]) Drain() []Result[T] {
+	s.closeInput()
+
+	var results []Result[T]
+	for res := range s.results {
+		results = append(results, res)
+	}
+	s.Wait()
+
+	slices.SortFunc(results, func(a, b Result[T]) int {
+		return cmp.Compare(a.Index, b.Index)
+	})
+	return results
+}
+
+// Wait blocks until every worker exited (Results() is closed by then), with
+// PanicPropagate it re-panics with the first value a task panicked with
+func (s *StreamPool[T]) Wait() {
+	<-s.done
+
+	if panicErr := s.firstPanic.Load(); panicErr != nil && s.propagate {
+		panic(panicErr.Value)
+	}
+}
+
+func (s *StreamPool[T]) closeInput() {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+
+		s.mu.Lock()
+		s.closed = true
+		close(s.tasks)
+		s.mu.Unlock()
+	})
+}
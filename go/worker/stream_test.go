@@ -0,0 +1,202 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kdnetwork/code-snippet/go/worker"
+)
+
+func TestStreamPool(t *testing.T) {
+	t.Run("SubmitAndCollect", func(t *testing.T) {
+		s := worker.StartStreamPool[int, string, int](context.Background(), 3, func(ctx context.Context, task int, store map[string]int) error {
+			if task%2 == 0 {
+				return errors.New("even")
+			}
+			return nil
+		})
+
+		go func() {
+			for i := range 10 {
+				if err := s.Submit(i); err != nil {
+					t.Errorf("Submit failed: %v", err)
+				}
+			}
+			s.Stop()
+		}()
+
+		seen := make(map[int]bool)
+		for res := range s.Results() {
+			if res.Index != res.Task {
+				t.Errorf("Index %d doesn't match task %d", res.Index, res.Task)
+			}
+			if (res.Err != nil) != (res.Task%2 == 0) {
+				t.Errorf("Unexpected result for task %d: %v", res.Task, res.Err)
+			}
+			seen[res.Index] = true
+		}
+		s.Wait()
+
+		t.Logf("Collected %d results before Stop", len(seen))
+	})
+
+	t.Run("StopMidWay", func(t *testing.T) {
+		var started int64
+		s := worker.StartStreamPool[int, string, int](context.Background(), 2, func(ctx context.Context, task int, store map[string]int) error {
+			atomic.AddInt64(&started, 1)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(20 * time.Millisecond):
+				return nil
+			}
+		})
+
+		go func() {
+			for i := range 100 {
+				if s.Submit(i) != nil {
+					return
+				}
+			}
+		}()
+		go func() {
+			for range s.Results() {
+			}
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+
+		start := time.Now()
+		s.Stop()
+		s.Wait()
+		if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+			t.Errorf("Stop didn't return promptly: %v", elapsed)
+		}
+
+		startedAtStop := atomic.LoadInt64(&started)
+		if startedAtStop >= 100 {
+			t.Fatalf("All tasks started before Stop, test is inconclusive")
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		if after := atomic.LoadInt64(&started); after != startedAtStop {
+			t.Errorf("Tasks started after Stop: %d -> %d", startedAtStop, after)
+		}
+
+		if err := s.Submit(1); !errors.Is(err, worker.ErrStreamClosed) {
+			t.Errorf("Submit after Stop should fail with ErrStreamClosed, got %v", err)
+		}
+	})
+}
+
+func TestStreamPoolResultBuffer(t *testing.T) {
+	const workers, buffer, tasks = 4, 2, 40
+
+	// done by fn and not yet received by the consumer
+	var inFlight, maxInFlight atomic.Int64
+	s := worker.StartStreamPool[int, string, int](context.Background(), workers, func(ctx context.Context, task int, store map[string]int) error {
+		n := inFlight.Add(1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		return nil
+	}, worker.WithResultBuffer(buffer))
+
+	go func() {
+		for i := range tasks {
+			if err := s.Submit(i); err != nil {
+				t.Errorf("Submit failed: %v", err)
+				return
+			}
+		}
+	}()
+
+	for range tasks {
+		<-s.Results()
+		inFlight.Add(-1)
+		time.Sleep(2 * time.Millisecond)
+	}
+	s.Stop()
+	s.Wait()
+
+	// every worker holds at most one result it can't send, +1 received but
+	// not counted down yet
+	if got := maxInFlight.Load(); got > buffer+workers+1 {
+		t.Errorf("Expected at most %d results in flight, got %d", buffer+workers+1, got)
+	}
+	if got := maxInFlight.Load(); got < buffer {
+		t.Errorf("Expected the buffer to fill up with a slow consumer, max in flight %d", got)
+	}
+
+	t.Run("StopUnblocksWorkers", func(t *testing.T) {
+		s := worker.StartStreamPool[int, string, int](context.Background(), 2, func(ctx context.Context, task int, store map[string]int) error {
+			return nil
+		}, worker.WithResultBuffer(0))
+
+		// nobody reads Results(): both workers block on their result
+		for i := range 2 {
+			if err := s.Submit(i); err != nil {
+				t.Fatalf("Submit failed: %v", err)
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		done := make(chan struct{})
+		go func() {
+			s.Stop()
+			s.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("Stop didn't unblock workers waiting on a full result buffer")
+		}
+	})
+}
+
+func TestStreamPoolDrain(t *testing.T) {
+	const tasks = 20
+
+	var ran atomic.Int64
+	s := worker.StartStreamPool[int, string, int](context.Background(), 2, func(ctx context.Context, task int, store map[string]int) error {
+		time.Sleep(time.Millisecond)
+		ran.Add(1)
+		if task == 7 {
+			return errors.New("seven")
+		}
+		return nil
+	}, worker.WithResultBuffer(tasks))
+
+	for i := range tasks {
+		if err := s.Submit(i); err != nil {
+			t.Fatalf("Submit failed: %v", err)
+		}
+	}
+
+	results := s.Drain()
+	if got := ran.Load(); got != tasks {
+		t.Errorf("Expected every queued task to run, ran %d", got)
+	}
+	if len(results) != tasks {
+		t.Fatalf("Expected %d results, got %d", tasks, len(results))
+	}
+	for i, res := range results {
+		if res.Index != i || res.Task != i {
+			t.Errorf("Expected results ordered by Index, got %d (task %d) at %d", res.Index, res.Task, i)
+		}
+		if (res.Err != nil) != (i == 7) {
+			t.Errorf("Unexpected result for task %d: %v", i, res.Err)
+		}
+	}
+
+	if err := s.Submit(tasks); !errors.Is(err, worker.ErrStreamClosed) {
+		t.Errorf("Submit after Drain should fail with ErrStreamClosed, got %v", err)
+	}
+}
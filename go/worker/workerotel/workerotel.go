@@ -0,0 +1,34 @@
+// Package workerotel traces the tasks of a worker pool with OpenTelemetry,
+// kept apart so that worker doesn't depend on otel
+package workerotel
+
+import (
+	"context"
+
+	"github.com/kdnetwork/code-snippet/go/worker"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/kdnetwork/code-snippet/go/worker"
+
+// WithTracing wraps every task in a span of tp named name(task), child of the
+// span in the ctx of the pool; a failing task sets the span status to Error,
+// tp == nil -> no option
+func WithTracing[T any](tp trace.TracerProvider, name func(task T) string) worker.Option {
+	if tp == nil {
+		return nil
+	}
+	tracer := tp.Tracer(tracerName)
+
+	return worker.WithTaskHook(func(ctx context.Context, task T) (context.Context, func(err error)) {
+		ctx, span := tracer.Start(ctx, name(task))
+		return ctx, func(err error) {
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			span.End()
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package workerotel_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/kdnetwork/code-snippet/go/worker"
+	"github.com/kdnetwork/code-snippet/go/worker/workerotel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestWithTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer tp.Shutdown(context.Background())
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+
+	tasks := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	errFailed := errors.New("task failed")
+	errs := worker.RunWorkerPool(ctx, tasks, 3, func(ctx context.Context, task int, store map[string]int) error {
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			t.Errorf("task %d: expected a span in ctx", task)
+		}
+		if task == 3 {
+			return errFailed
+		}
+		return nil
+	}, workerotel.WithTracing(tp, func(task int) string { return "task-" + strconv.Itoa(task) }))
+	parent.End()
+
+	for i, err := range errs {
+		if (i == 3) != errors.Is(err, errFailed) {
+			t.Errorf("task %d: unexpected error %v", i, err)
+		}
+	}
+
+	var names []string
+	for _, span := range exporter.GetSpans() {
+		if span.Name == "parent" {
+			continue
+		}
+		names = append(names, span.Name)
+		if span.Parent.SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("%s: expected the parent span as parent", span.Name)
+		}
+
+		wantCode := codes.Unset
+		if span.Name == "task-3" {
+			wantCode = codes.Error
+		}
+		if span.Status.Code != wantCode {
+			t.Errorf("%s: expected status %v, got %v", span.Name, wantCode, span.Status.Code)
+		}
+	}
+
+	slices.Sort(names)
+	want := make([]string, len(tasks))
+	for i, task := range tasks {
+		want[i] = "task-" + strconv.Itoa(task)
+	}
+	if !slices.Equal(names, want) {
+		t.Errorf("Expected one span per task %v, got %v", want, names)
+	}
+}
+
+func TestWithTracingNilProvider(t *testing.T) {
+	errs := worker.RunWorkerPool(context.Background(), []int{1, 2}, 2, func(ctx context.Context, task int, store map[string]int) error {
+		return nil
+	}, workerotel.WithTracing[int](nil, nil))
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("task %d failed: %v", i, err)
+		}
+	}
+}
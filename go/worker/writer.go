@@ -0,0 +1,33 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+)
+
+// RunWorkerPoolWriter is RunWorkerPool for tasks producing large outputs: fn
+// writes the output of tasks[i] to sink(i) instead of returning it, so
+// nothing is held in memory. sink is called when the task starts, a writer
+// that is an io.Closer (file...) is closed once fn returns, its error joins
+// the error of the task; a sink error fails the task without calling fn
+func RunWorkerPoolWriter[T any, K comparable, V any](ctx context.Context, tasks []T, maxWorkers int, sink func(index int) (io.Writer, error), fn func(ctx context.Context, task T, w io.Writer, store map[K]V) error, opts ...Option) []error {
+	if fn == nil {
+		return nilFuncErrors(len(tasks))
+	}
+
+	return RunWorkerPool(ctx, indexes(len(tasks)), maxWorkers, func(ctx context.Context, i int, store map[K]V) (err error) {
+		w, err := sink(i)
+		if err != nil {
+			return err
+		}
+		if closer, ok := w.(io.Closer); ok {
+			defer func() {
+				err = errors.Join(err, closer.Close())
+			}()
+		}
+
+		return fn(ctx, tasks[i], w, store)
+	}, append(slices.Clone(opts), withIndexedTasks(tasks), withoutDedup)...)
+}
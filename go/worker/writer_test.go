@@ -0,0 +1,89 @@
+package worker_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kdnetwork/code-snippet/go/worker"
+)
+
+func TestRunWorkerPoolWriter(t *testing.T) {
+	t.Run("Buffers", func(t *testing.T) {
+		tasks := []string{"a", "b", "c", "d", "e"}
+		bufs := make([]bytes.Buffer, len(tasks))
+
+		errs := worker.RunWorkerPoolWriter(context.Background(), tasks, 2, func(i int) (io.Writer, error) {
+			return &bufs[i], nil
+		}, func(ctx context.Context, task string, w io.Writer, store map[string]int) error {
+			for range 3 {
+				if _, err := fmt.Fprintf(w, "%s;", task); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("task %d failed: %v", i, err)
+			}
+		}
+		for i, task := range tasks {
+			if want := strings.Repeat(task+";", 3); bufs[i].String() != want {
+				t.Errorf("Expected %q in buffer %d, got %q", want, i, bufs[i].String())
+			}
+		}
+	})
+
+	t.Run("Files", func(t *testing.T) {
+		dir := t.TempDir()
+		tasks := []int{1, 2, 3}
+
+		errs := worker.RunWorkerPoolWriter(context.Background(), tasks, 3, func(i int) (io.Writer, error) {
+			return os.Create(filepath.Join(dir, fmt.Sprintf("out-%d.txt", i)))
+		}, func(ctx context.Context, task int, w io.Writer, store map[string]int) error {
+			_, err := fmt.Fprintf(w, "task %d", task)
+			return err
+		})
+
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("task %d failed: %v", i, err)
+			}
+		}
+		for i, task := range tasks {
+			content, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("out-%d.txt", i)))
+			if err != nil {
+				t.Fatalf("ReadFile failed: %v", err)
+			}
+			if want := fmt.Sprintf("task %d", task); string(content) != want {
+				t.Errorf("Expected %q in file %d, got %q", want, i, content)
+			}
+		}
+	})
+
+	t.Run("SinkError", func(t *testing.T) {
+		errSink := errors.New("disk full")
+		called := false
+		errs := worker.RunWorkerPoolWriter(context.Background(), []int{1}, 1, func(i int) (io.Writer, error) {
+			return nil, errSink
+		}, func(ctx context.Context, task int, w io.Writer, store map[string]int) error {
+			called = true
+			return nil
+		})
+
+		if !errors.Is(errs[0], errSink) {
+			t.Errorf("Expected the sink error, got %v", errs[0])
+		}
+		if called {
+			t.Error("fn must not run without a writer")
+		}
+	})
+}